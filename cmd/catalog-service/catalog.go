@@ -0,0 +1,756 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/catalogsync"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+var (
+	errNotFound    = errors.New("product not found")
+	errInvalidEdit = errors.New("invalid edit")
+)
+
+// editSource is the source holding edits made through the API. Edits rank
+// above every platform for the fields they set.
+const editSource = "catalog"
+
+// mergedFields are the product fields whose source of truth can be
+// configured per field.
+var mergedFields = []string{"name", "description", "price", "cost", "stock"}
+
+// catalog merges platform product records into one product per canonical
+// SKU. Products are kept in a store logged to the compacted catalog topic,
+// so the topic is both the published catalog and the store's changelog.
+type catalog struct {
+	mu         sync.Mutex
+	products   *state.Store
+	byPlatform map[string]string
+	byVariant  map[string]string
+	precedence map[string][]string
+	available  map[string]int
+	echoes     map[string][]catalogsync.Push
+	echoWindow time.Duration
+	index      *searchIndex
+	taxonomy   *taxonomy
+}
+
+func newCatalog(products *state.Store, taxonomy *taxonomy, precedence map[string][]string, echoWindow time.Duration) (*catalog, error) {
+	c := &catalog{
+		products:   products,
+		taxonomy:   taxonomy,
+		byPlatform: make(map[string]string),
+		byVariant:  make(map[string]string),
+		precedence: precedence,
+		available:  make(map[string]int),
+		echoes:     make(map[string][]catalogsync.Push),
+		echoWindow: echoWindow,
+		index:      newSearchIndex(),
+	}
+	err := products.ForEach(func(key string, value []byte) error {
+		var product models.CatalogProduct
+		if err := json.Unmarshal(value, &product); err != nil {
+			return err
+		}
+		for platform, id := range product.PlatformIDs {
+			c.byPlatform[platform+"/"+id] = key
+		}
+		c.indexLocked(nil, product)
+		return nil
+	})
+	return c, err
+}
+
+// parsePrecedence reads entries written as field=platform|platform, listing
+// the platforms trusted for a field from most to least.
+func parsePrecedence(entries []string) (map[string][]string, error) {
+	precedence := make(map[string][]string)
+	for _, entry := range entries {
+		field, platforms, ok := strings.Cut(entry, "=")
+		if !ok || !slices.Contains(mergedFields, field) || platforms == "" {
+			return nil, fmt.Errorf("invalid precedence %q", entry)
+		}
+		precedence[field] = strings.Split(platforms, "|")
+	}
+	return precedence, nil
+}
+
+// keyLocked picks the product a platform record belongs to. Products
+// sharing a variant SKU are the same product, as Shopify products have no
+// SKU of their own. Otherwise the product SKU is the key, then the first
+// variant SKU. A record without either stays where it was, or is kept
+// per platform since it cannot be matched across platforms.
+func (c *catalog) keyLocked(platform, sku string, source models.ProductSource) string {
+	for _, variant := range source.Variants {
+		if key, ok := c.byVariant[models.CanonicalSKU(variant.SKU)]; ok {
+			return key
+		}
+	}
+	if key := models.CanonicalSKU(sku); key != "" {
+		return key
+	}
+	for _, variant := range source.Variants {
+		if key := models.CanonicalSKU(variant.SKU); key != "" {
+			return key
+		}
+	}
+	if key, ok := c.byPlatform[platform+"/"+source.ID]; ok {
+		return key
+	}
+	return platform + ":" + source.ID
+}
+
+// lookup returns the key a platform record would be stored under and the
+// product stored there, if any.
+func (c *catalog) lookup(platform, sku string, source models.ProductSource) (string, models.CatalogProduct, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.keyLocked(platform, sku, source)
+	var product models.CatalogProduct
+	found, err := c.products.Get(key, &product)
+	return key, product, found, err
+}
+
+// upsert records a platform's version of a product. Fields missing from
+// source keep the value the platform sent before, and so do values the
+// platform echoes back after they were pushed to it. The record only gets
+// more recent when a value changes. When the platform changes the SKU, its
+// record moves to the product with the new SKU.
+func (c *catalog) upsert(platform, sku string, source models.ProductSource) (models.CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.keyLocked(platform, sku, source)
+
+	if previous, ok := c.byPlatform[platform+"/"+source.ID]; ok && previous != key {
+		var moved models.CatalogProduct
+		if _, err := c.products.Get(previous, &moved); err != nil {
+			return moved, err
+		}
+		if old, ok := moved.Sources[platform]; ok {
+			source = overlay(old, source)
+		}
+		if _, _, err := c.detachLocked(previous, platform); err != nil {
+			return moved, err
+		}
+	}
+
+	var product models.CatalogProduct
+	found, err := c.products.Get(key, &product)
+	if err != nil {
+		return product, err
+	}
+	if !found {
+		product = models.CatalogProduct{ID: key}
+	}
+	if product.SKU == "" {
+		product.SKU = models.CanonicalSKU(sku)
+	}
+	if product.Sources == nil {
+		product.Sources = make(map[string]models.ProductSource)
+	}
+
+	if old, ok := product.Sources[platform]; ok && old.ID == source.ID {
+		source = overlay(old, c.dropEchoesLocked(platform, old, source))
+		if sameSource(old, source) {
+			source.UpdatedAt = old.UpdatedAt
+		}
+	}
+	previous := product
+	product.Sources[platform] = source
+	c.merge(&product)
+
+	if err := c.products.Put(key, product); err != nil {
+		return product, err
+	}
+	c.byPlatform[platform+"/"+source.ID] = key
+	c.indexLocked(&previous, product)
+	return product, nil
+}
+
+// remove drops a platform's record of a product and reports whether that
+// deleted the product, which happens once no platform has it any more.
+func (c *catalog) remove(platform, platformID string) (models.CatalogProduct, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.byPlatform[platform+"/"+platformID]
+	if !ok {
+		return models.CatalogProduct{}, false, errNotFound
+	}
+	return c.detachLocked(key, platform)
+}
+
+func (c *catalog) detachLocked(key, platform string) (models.CatalogProduct, bool, error) {
+	var product models.CatalogProduct
+	found, err := c.products.Get(key, &product)
+	if err != nil || !found {
+		return product, false, err
+	}
+
+	if source, ok := product.Sources[platform]; ok {
+		delete(c.byPlatform, platform+"/"+source.ID)
+	}
+	previous := product
+	delete(product.Sources, platform)
+
+	if _, edited := product.Sources[editSource]; len(product.Sources) == 0 || edited && len(product.Sources) == 1 {
+		if err := c.products.Delete(key); err != nil {
+			return product, false, err
+		}
+		c.unindexLocked(previous)
+		return product, true, nil
+	}
+	c.merge(&product)
+	if err := c.products.Put(key, product); err != nil {
+		return product, false, err
+	}
+	c.indexLocked(&previous, product)
+	return product, false, nil
+}
+
+// indexLocked points the variant SKUs of product at it, dropping those of
+// the previous version, and updates the search index.
+func (c *catalog) indexLocked(previous *models.CatalogProduct, product models.CatalogProduct) {
+	if previous != nil {
+		for _, variant := range previous.Variants {
+			if c.byVariant[variant.SKU] == previous.ID {
+				delete(c.byVariant, variant.SKU)
+			}
+		}
+	}
+	for _, variant := range product.Variants {
+		c.byVariant[variant.SKU] = product.ID
+	}
+	c.index.put(product)
+}
+
+func (c *catalog) unindexLocked(product models.CatalogProduct) {
+	for _, variant := range product.Variants {
+		if c.byVariant[variant.SKU] == product.ID {
+			delete(c.byVariant, variant.SKU)
+		}
+	}
+	c.index.remove(product.ID)
+}
+
+// merge recomputes the product fields from its sources. For each field the
+// first platform in the configured precedence that sent a value wins;
+// platforms not listed rank below those listed, most recent first. Stock
+// of SKUs tracked by the inventory is its available-to-sell instead.
+// Categories are mapped to the canonical category tree.
+func (c *catalog) merge(product *models.CatalogProduct) {
+	platforms := make([]string, 0, len(product.Sources))
+	product.PlatformIDs = make(map[string]string, len(product.Sources))
+	for platform, source := range product.Sources {
+		platforms = append(platforms, platform)
+		if platform != editSource {
+			product.PlatformIDs[platform] = source.ID
+		}
+	}
+
+	ranked := func(field string) []string {
+		order := c.precedence[field]
+		rank := func(platform string) int {
+			if platform == editSource {
+				return -1
+			}
+			for i, p := range order {
+				if p == platform {
+					return i
+				}
+			}
+			return len(order)
+		}
+		sorted := append([]string(nil), platforms...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			ri, rj := rank(sorted[i]), rank(sorted[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return product.Sources[sorted[i]].UpdatedAt.After(product.Sources[sorted[j]].UpdatedAt)
+		})
+		return sorted
+	}
+
+	product.Name, product.Description, product.Price, product.Cost, product.Stock = "", "", 0, 0, 0
+	for _, platform := range ranked("name") {
+		if name := product.Sources[platform].Name; name != nil {
+			product.Name = *name
+			break
+		}
+	}
+	for _, platform := range ranked("description") {
+		if description := product.Sources[platform].Description; description != nil {
+			product.Description = *description
+			break
+		}
+	}
+	var hasPrice, hasStock bool
+	for _, platform := range ranked("price") {
+		if price := product.Sources[platform].Price; price != nil {
+			product.Price, hasPrice = *price, true
+			break
+		}
+	}
+	for _, platform := range ranked("cost") {
+		if cost := product.Sources[platform].Cost; cost != nil {
+			product.Cost = *cost
+			break
+		}
+	}
+	for _, platform := range ranked("stock") {
+		if stock := product.Sources[platform].Stock; stock != nil {
+			product.Stock, hasStock = *stock, true
+			break
+		}
+	}
+	if available, ok := c.available[product.SKU]; ok {
+		product.Stock, hasStock = available, true
+	}
+
+	c.mergeVariants(product, ranked)
+	if !hasPrice && len(product.Variants) > 0 {
+		product.Price = product.Variants[0].Price
+		for _, variant := range product.Variants[1:] {
+			product.Price = math.Min(product.Price, variant.Price)
+		}
+	}
+	if !hasStock && len(product.Variants) > 0 {
+		for _, variant := range product.Variants {
+			product.Stock += variant.Stock
+		}
+	}
+
+	product.Components = nil
+	for _, platform := range ranked("name") {
+		if components := product.Sources[platform].Components; len(components) > 0 {
+			product.Components = components
+			break
+		}
+	}
+
+	c.mergeCategories(product, ranked)
+
+	switch {
+	case len(product.Components) > 0:
+		product.Type = models.ProductBundle
+	case len(product.Variants) > 0:
+		product.Type = models.ProductConfigurable
+	default:
+		product.Type = models.ProductSimple
+	}
+	product.UpdatedAt = time.Now()
+}
+
+// mergeVariants merges variants by canonical SKU the same way as product
+// fields: title and options follow the name precedence, price, cost and
+// stock their own. Variants are listed in the order of the platform first
+// in the name precedence. Options are the union over all platforms.
+func (c *catalog) mergeVariants(product *models.CatalogProduct, ranked func(field string) []string) {
+	product.Options = nil
+	product.Variants = nil
+	positions := make(map[string]int)
+
+	for _, platform := range ranked("name") {
+		source := product.Sources[platform]
+		for _, option := range source.Options {
+			product.Options = addOption(product.Options, option.Name)
+			for _, value := range option.Values {
+				product.Options = addOptionValue(product.Options, option.Name, value)
+			}
+		}
+		for _, variant := range source.Variants {
+			sku := models.CanonicalSKU(variant.SKU)
+			if sku == "" || platform == editSource {
+				continue
+			}
+			i, ok := positions[sku]
+			if !ok {
+				i = len(product.Variants)
+				positions[sku] = i
+				product.Variants = append(product.Variants, models.ProductVariant{
+					SKU:         sku,
+					Title:       variant.Title,
+					Options:     variant.Options,
+					PlatformIDs: make(map[string]string),
+				})
+			}
+			if variant.ID != "" {
+				product.Variants[i].PlatformIDs[platform] = variant.ID
+			}
+			for name, value := range variant.Options {
+				product.Options = addOptionValue(product.Options, name, value)
+			}
+		}
+	}
+
+	set := func(field string, apply func(v *models.ProductVariant, sv models.SourceVariant) bool) {
+		done := make(map[string]bool)
+		for _, platform := range ranked(field) {
+			for _, variant := range product.Sources[platform].Variants {
+				sku := models.CanonicalSKU(variant.SKU)
+				i, ok := positions[sku]
+				if ok && !done[sku] && apply(&product.Variants[i], variant) {
+					done[sku] = true
+				}
+			}
+		}
+	}
+	set("price", func(v *models.ProductVariant, sv models.SourceVariant) bool {
+		if sv.Price == nil {
+			return false
+		}
+		v.Price = *sv.Price
+		return true
+	})
+	set("cost", func(v *models.ProductVariant, sv models.SourceVariant) bool {
+		if sv.Cost == nil {
+			return false
+		}
+		v.Cost = *sv.Cost
+		return true
+	})
+	set("stock", func(v *models.ProductVariant, sv models.SourceVariant) bool {
+		if sv.Stock == nil {
+			return false
+		}
+		v.Stock = *sv.Stock
+		return true
+	})
+	for i := range product.Variants {
+		if available, ok := c.available[product.Variants[i].SKU]; ok {
+			product.Variants[i].Stock = available
+		}
+	}
+}
+
+// setAvailable records the available-to-sell of sku published by the
+// inventory, or forgets it when available is nil, and updates the stock of
+// the product selling sku.
+func (c *catalog) setAvailable(sku string, available *int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if available == nil {
+		delete(c.available, sku)
+	} else {
+		c.available[sku] = *available
+	}
+
+	key, ok := c.byVariant[sku]
+	if !ok {
+		key = sku
+	}
+	var product models.CatalogProduct
+	found, err := c.products.Get(key, &product)
+	if err != nil || !found {
+		return err
+	}
+	previous := product
+	c.merge(&product)
+	if sameStock(previous, product) {
+		return nil
+	}
+	if err := c.products.Put(key, product); err != nil {
+		return err
+	}
+	c.indexLocked(&previous, product)
+	return nil
+}
+
+func sameStock(a, b models.CatalogProduct) bool {
+	if a.Stock != b.Stock || len(a.Variants) != len(b.Variants) {
+		return false
+	}
+	for i := range a.Variants {
+		if a.Variants[i].Stock != b.Variants[i].Stock {
+			return false
+		}
+	}
+	return true
+}
+
+// edit applies changes made through the API on top of earlier edits.
+// Variants are edited by SKU and must exist already. Categories are
+// canonical category IDs and replace those mapped from the platforms.
+func (c *catalog) edit(id string, changes models.ProductSource) (models.CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var product models.CatalogProduct
+	found, err := c.products.Get(id, &product)
+	if err != nil {
+		return product, err
+	}
+	if !found {
+		return product, errNotFound
+	}
+
+	if changes.Price != nil && *changes.Price < 0 || changes.Cost != nil && *changes.Cost < 0 {
+		return product, errInvalidEdit
+	}
+	for i, variant := range changes.Variants {
+		sku := models.CanonicalSKU(variant.SKU)
+		if _, ok := product.Variant(sku); !ok {
+			return product, fmt.Errorf("%w: unknown variant %s", errInvalidEdit, variant.SKU)
+		}
+		changes.Variants[i] = models.SourceVariant{SKU: sku, Price: variant.Price, Cost: variant.Cost, Stock: variant.Stock}
+	}
+	for i, ref := range changes.Categories {
+		if !c.taxonomy.exists(ref.ID) {
+			return product, fmt.Errorf("%w: unknown category %s", errInvalidEdit, ref.ID)
+		}
+		changes.Categories[i] = models.CategoryRef{ID: ref.ID}
+	}
+
+	edits := catalogsync.Overlay(product.Sources[editSource], models.ProductSource{
+		Name:        changes.Name,
+		Description: changes.Description,
+		Price:       changes.Price,
+		Cost:        changes.Cost,
+		Stock:       changes.Stock,
+		Variants:    changes.Variants,
+		Categories:  changes.Categories,
+	})
+	edits.ID = product.ID
+	edits.UpdatedAt = time.Now()
+
+	previous := product
+	product.Sources[editSource] = edits
+	c.merge(&product)
+	if err := c.products.Put(id, product); err != nil {
+		return product, err
+	}
+	c.indexLocked(&previous, product)
+	return product, nil
+}
+
+// clearEdits drops the edits of a product, so its fields follow the
+// platforms again.
+func (c *catalog) clearEdits(id string) (models.CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var product models.CatalogProduct
+	found, err := c.products.Get(id, &product)
+	if err != nil {
+		return product, err
+	}
+	if !found {
+		return product, errNotFound
+	}
+	if _, ok := product.Sources[editSource]; !ok {
+		return product, nil
+	}
+
+	previous := product
+	delete(product.Sources, editSource)
+	c.merge(&product)
+	if err := c.products.Put(id, product); err != nil {
+		return product, err
+	}
+	c.indexLocked(&previous, product)
+	return product, nil
+}
+
+// recordPush applies the fields a connector pushed to its platform's record
+// of the product, as the platform has them now, and remembers them so the
+// webhook the platform sends back is recognized as an echo. The record
+// keeps the time the platform itself last changed it.
+func (c *catalog) recordPush(push catalogsync.Push) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := push.Platform + "/" + push.Changes.ID
+	c.echoes[key] = append(c.recentEchoesLocked(key), push)
+
+	var product models.CatalogProduct
+	found, err := c.products.Get(push.ProductID, &product)
+	if err != nil || !found {
+		return err
+	}
+	source, ok := product.Sources[push.Platform]
+	if !ok || source.ID != push.Changes.ID {
+		return nil
+	}
+	updated := catalogsync.Overlay(source, push.Changes)
+	if sameSource(source, updated) {
+		return nil
+	}
+
+	previous := product
+	product.Sources[push.Platform] = updated
+	c.merge(&product)
+	if err := c.products.Put(push.ProductID, product); err != nil {
+		return err
+	}
+	c.indexLocked(&previous, product)
+	return nil
+}
+
+func (c *catalog) recentEchoesLocked(key string) []catalogsync.Push {
+	cutoff := time.Now().Add(-c.echoWindow)
+	var recent []catalogsync.Push
+	for _, push := range c.echoes[key] {
+		if push.PushedAt.After(cutoff) {
+			recent = append(recent, push)
+		}
+	}
+	if len(recent) == 0 {
+		delete(c.echoes, key)
+	}
+	return recent
+}
+
+// dropEchoesLocked leaves out of update the values that were pushed to the
+// platform within the echo window, keeping the values of old instead. A
+// late echo of an earlier push would otherwise undo a later change.
+func (c *catalog) dropEchoesLocked(platform string, old, update models.ProductSource) models.ProductSource {
+	key := platform + "/" + update.ID
+	recent := c.recentEchoesLocked(key)
+	if len(recent) == 0 {
+		return update
+	}
+	c.echoes[key] = recent
+
+	update.Variants = append([]models.SourceVariant(nil), update.Variants...)
+	for _, push := range recent {
+		pushed := push.Changes
+		if pushed.Name != nil && update.Name != nil && *update.Name == *pushed.Name {
+			update.Name = nil
+		}
+		if pushed.Description != nil && update.Description != nil && *update.Description == *pushed.Description {
+			update.Description = nil
+		}
+		if pushed.Price != nil && update.Price != nil && models.SamePrice(*update.Price, *pushed.Price) {
+			update.Price = nil
+		}
+		if pushed.Stock != nil && update.Stock != nil && *update.Stock == *pushed.Stock {
+			update.Stock = nil
+		}
+		if pushed.Categories != nil && update.Categories != nil && catalogsync.SameCategories(update.Categories, pushed.Categories) {
+			update.Categories = nil
+		}
+		for _, pushedVariant := range pushed.Variants {
+			for i := range update.Variants {
+				variant := &update.Variants[i]
+				if models.CanonicalSKU(variant.SKU) != pushedVariant.SKU {
+					continue
+				}
+				var previous models.SourceVariant
+				for _, v := range old.Variants {
+					if models.CanonicalSKU(v.SKU) == pushedVariant.SKU {
+						previous = v
+					}
+				}
+				if pushedVariant.Price != nil && variant.Price != nil && models.SamePrice(*variant.Price, *pushedVariant.Price) {
+					variant.Price = previous.Price
+				}
+				if pushedVariant.Stock != nil && variant.Stock != nil && *variant.Stock == *pushedVariant.Stock {
+					variant.Stock = previous.Stock
+				}
+			}
+		}
+	}
+	return update
+}
+
+// sameSource reports whether two records of a platform hold the same
+// values, whenever they were received.
+func sameSource(a, b models.ProductSource) bool {
+	a.UpdatedAt, b.UpdatedAt = time.Time{}, time.Time{}
+	return reflect.DeepEqual(a, b)
+}
+
+// overlay applies a partial update on top of the previous record. An
+// update naming categories without IDs, as a Shopify product type does,
+// keeps the category IDs received before.
+func overlay(old, update models.ProductSource) models.ProductSource {
+	if update.Name == nil {
+		update.Name = old.Name
+	}
+	if update.Description == nil {
+		update.Description = old.Description
+	}
+	if update.Price == nil {
+		update.Price = old.Price
+	}
+	if update.Cost == nil {
+		update.Cost = old.Cost
+	}
+	if update.Stock == nil {
+		update.Stock = old.Stock
+	}
+	if update.Options == nil {
+		update.Options = old.Options
+	}
+	if update.Variants == nil {
+		update.Variants = old.Variants
+	}
+	if update.Components == nil {
+		update.Components = old.Components
+	}
+	if update.Categories == nil {
+		update.Categories = old.Categories
+	} else if !catalogsync.HasCategoryIDs(update.Categories) {
+		for _, ref := range old.Categories {
+			if ref.ID != "" {
+				update.Categories = append(update.Categories, ref)
+			}
+		}
+	}
+	return update
+}
+
+func (c *catalog) get(id string) (models.CatalogProduct, error) {
+	var product models.CatalogProduct
+	found, err := c.products.Get(id, &product)
+	if err == nil && !found {
+		err = errNotFound
+	}
+	return product, err
+}
+
+// bySKU looks a product up by its own or one of its variants' SKU, in any
+// spelling that normalizes to the same canonical SKU.
+func (c *catalog) bySKU(sku string) (models.CatalogProduct, error) {
+	if sku = models.CanonicalSKU(sku); sku == "" {
+		return models.CatalogProduct{}, errNotFound
+	}
+	product, err := c.get(sku)
+	if !errors.Is(err, errNotFound) {
+		return product, err
+	}
+
+	c.mu.Lock()
+	key, ok := c.byVariant[sku]
+	c.mu.Unlock()
+	if !ok {
+		return product, err
+	}
+	return c.get(key)
+}
+
+// byPlatformID looks a product up by the ID a platform knows it by.
+func (c *catalog) byPlatformID(platform, id string) (models.CatalogProduct, error) {
+	c.mu.Lock()
+	key, ok := c.byPlatform[platform+"/"+id]
+	c.mu.Unlock()
+	if !ok {
+		return models.CatalogProduct{}, errNotFound
+	}
+	return c.get(key)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errInvalidCategory  = errors.New("invalid category")
+	errCategoryInUse    = errors.New("category has subcategories")
+)
+
+// taxonomy is the canonical category tree, kept in a store logged to the
+// compacted categories topic and in memory with indexes to map platform
+// categories to canonical ones.
+type taxonomy struct {
+	mu         sync.RWMutex
+	store      *state.Store
+	categories map[string]models.Category
+	byPlatform map[string]string
+	byName     map[string]string
+}
+
+func newTaxonomy(store *state.Store) (*taxonomy, error) {
+	t := &taxonomy{
+		store:      store,
+		categories: make(map[string]models.Category),
+	}
+	err := store.ForEach(func(key string, value []byte) error {
+		var category models.Category
+		if err := json.Unmarshal(value, &category); err != nil {
+			return err
+		}
+		t.categories[key] = category
+		return nil
+	})
+	t.reindexLocked()
+	return t, err
+}
+
+// reindexLocked rebuilds the indexes from the categories. A name or alias
+// shared by several categories, such as Shirts under both Men and Women,
+// matches none of them.
+func (t *taxonomy) reindexLocked() {
+	t.byPlatform = make(map[string]string)
+	t.byName = make(map[string]string)
+	for id, category := range t.categories {
+		for platform, ids := range category.Mappings {
+			for _, platformID := range ids {
+				t.byPlatform[platform+"/"+platformID] = id
+			}
+		}
+		for _, name := range append([]string{category.Name}, category.Aliases...) {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if other, ok := t.byName[name]; ok && other != id {
+				t.byName[name] = ""
+				continue
+			}
+			t.byName[name] = id
+		}
+	}
+}
+
+// put creates or replaces a category. Its parent must exist and must not be
+// the category itself or below it, and a platform category can only map to
+// one canonical category.
+func (t *taxonomy) put(category models.Category) (models.Category, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.putLocked(category)
+}
+
+func (t *taxonomy) putLocked(category models.Category) (models.Category, error) {
+	category.Name = strings.TrimSpace(category.Name)
+	if category.ID == "" || category.Name == "" {
+		return category, fmt.Errorf("%w: id and name are required", errInvalidCategory)
+	}
+	for parent := category.ParentID; parent != ""; parent = t.categories[parent].ParentID {
+		if parent == category.ID {
+			return category, fmt.Errorf("%w: %s cannot be below itself", errInvalidCategory, category.ID)
+		}
+		if _, ok := t.categories[parent]; !ok {
+			return category, fmt.Errorf("%w: unknown parent %s", errInvalidCategory, parent)
+		}
+	}
+
+	var aliases []string
+	for _, alias := range category.Aliases {
+		if alias = strings.TrimSpace(alias); alias != "" && !slices.Contains(aliases, alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	category.Aliases = aliases
+
+	mappings := make(map[string][]string, len(category.Mappings))
+	for platform, ids := range category.Mappings {
+		if platform == "" || platform == editSource {
+			return category, fmt.Errorf("%w: invalid platform %q", errInvalidCategory, platform)
+		}
+		var mapped []string
+		for _, id := range ids {
+			if id = strings.TrimSpace(id); id == "" || slices.Contains(mapped, id) {
+				continue
+			}
+			if other, ok := t.byPlatform[platform+"/"+id]; ok && other != category.ID {
+				return category, fmt.Errorf("%w: %s category %s is mapped to %s", errInvalidCategory, platform, id, other)
+			}
+			mapped = append(mapped, id)
+		}
+		if len(mapped) > 0 {
+			mappings[platform] = mapped
+		}
+	}
+	category.Mappings = mappings
+	if len(mappings) == 0 {
+		category.Mappings = nil
+	}
+
+	category.Path = ""
+	category.UpdatedAt = time.Now()
+	if err := t.store.Put(category.ID, category); err != nil {
+		return category, err
+	}
+	t.categories[category.ID] = category
+	t.reindexLocked()
+	category.Path = t.pathLocked(category.ID)
+	return category, nil
+}
+
+// setMapping replaces the platform categories mapped to a category; no IDs
+// removes the mapping.
+func (t *taxonomy) setMapping(id, platform string, ids []string) (models.Category, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	category, ok := t.categories[id]
+	if !ok {
+		return category, errCategoryNotFound
+	}
+
+	mappings := make(map[string][]string, len(category.Mappings)+1)
+	for p, mapped := range category.Mappings {
+		mappings[p] = mapped
+	}
+	mappings[platform] = ids
+	category.Mappings = mappings
+	return t.putLocked(category)
+}
+
+// remove deletes a category. Categories with subcategories cannot be
+// removed.
+func (t *taxonomy) remove(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.categories[id]; !ok {
+		return errCategoryNotFound
+	}
+	for _, category := range t.categories {
+		if category.ParentID == id {
+			return errCategoryInUse
+		}
+	}
+	if err := t.store.Delete(id); err != nil {
+		return err
+	}
+	delete(t.categories, id)
+	t.reindexLocked()
+	return nil
+}
+
+func (t *taxonomy) get(id string) (models.Category, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	category, ok := t.categories[id]
+	if !ok {
+		return category, errCategoryNotFound
+	}
+	category.Path = t.pathLocked(id)
+	return category, nil
+}
+
+// list returns every category in the order of their paths, so each
+// category follows its parent.
+func (t *taxonomy) list() []models.Category {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	categories := make([]models.Category, 0, len(t.categories))
+	for id, category := range t.categories {
+		category.Path = t.pathLocked(id)
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Path != categories[j].Path {
+			return categories[i].Path < categories[j].Path
+		}
+		return categories[i].ID < categories[j].ID
+	})
+	return categories
+}
+
+// pathLocked is the names of the category and its ancestors from the root
+// down, such as "Apparel > Men > Shirts".
+func (t *taxonomy) pathLocked(id string) string {
+	var names []string
+	for id != "" {
+		category := t.categories[id]
+		names = append([]string{category.Name}, names...)
+		id = category.ParentID
+	}
+	return strings.Join(names, " > ")
+}
+
+// mappings returns the canonical category each of the platform's
+// categories maps to, by platform category ID.
+func (t *taxonomy) mappings(platform string) map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	mapped := make(map[string]string)
+	for key, id := range t.byPlatform {
+		if p, platformID, _ := strings.Cut(key, "/"); p == platform {
+			mapped[platformID] = id
+		}
+	}
+	return mapped
+}
+
+// exists reports whether id is a category.
+func (t *taxonomy) exists(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.categories[id]
+	return ok
+}
+
+// resolve maps the categories a platform files a product under to
+// canonical categories: by the platform category ID when it is mapped,
+// otherwise by name or alias, ignoring case. Categories matching neither
+// are left out.
+func (t *taxonomy) resolve(platform string, refs []models.CategoryRef) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var ids []string
+	for _, ref := range refs {
+		id, ok := t.byPlatform[platform+"/"+ref.ID]
+		if !ok || ref.ID == "" {
+			id = t.byName[strings.ToLower(strings.TrimSpace(ref.Name))]
+		}
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// platformCategories returns, for each platform, its categories mapped to
+// the canonical categories.
+func (t *taxonomy) platformCategories(categories []string, platforms map[string]string) map[string][]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var mapped map[string][]string
+	for platform := range platforms {
+		var ids []string
+		for _, id := range categories {
+			for _, platformID := range t.categories[id].Mappings[platform] {
+				if !slices.Contains(ids, platformID) {
+					ids = append(ids, platformID)
+				}
+			}
+		}
+		if len(ids) > 0 {
+			if mapped == nil {
+				mapped = make(map[string][]string)
+			}
+			mapped[platform] = ids
+		}
+	}
+	return mapped
+}
+
+// descendants returns the category and every category below it.
+func (t *taxonomy) descendants(id string) ([]string, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if _, ok := t.categories[id]; !ok {
+		return nil, errCategoryNotFound
+	}
+	ids := []string{id}
+	for i := 0; i < len(ids); i++ {
+		for child, category := range t.categories {
+			if category.ParentID == ids[i] {
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// mergeCategories files the product under canonical categories: those set
+// through the API when there are any, otherwise those the categories of
+// every platform selling it map to. CategoryIDs lists, per platform, the
+// platform's categories to file the product under when pushing it.
+func (c *catalog) mergeCategories(product *models.CatalogProduct, ranked func(field string) []string) {
+	product.Categories = nil
+	if edits, ok := product.Sources[editSource]; ok && edits.Categories != nil {
+		for _, ref := range edits.Categories {
+			if c.taxonomy.exists(ref.ID) && !slices.Contains(product.Categories, ref.ID) {
+				product.Categories = append(product.Categories, ref.ID)
+			}
+		}
+	} else {
+		for _, platform := range ranked("name") {
+			for _, id := range c.taxonomy.resolve(platform, product.Sources[platform].Categories) {
+				if !slices.Contains(product.Categories, id) {
+					product.Categories = append(product.Categories, id)
+				}
+			}
+		}
+	}
+	product.CategoryIDs = c.taxonomy.platformCategories(product.Categories, product.PlatformIDs)
+}
+
+// recategorize files every product again after categories or their
+// mappings changed, and returns the number of products that moved.
+func (c *catalog) recategorize() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var keys []string
+	err := c.products.ForEach(func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, key := range keys {
+		var product models.CatalogProduct
+		found, err := c.products.Get(key, &product)
+		if err != nil {
+			return moved, err
+		}
+		if !found {
+			continue
+		}
+		previous := product
+		c.merge(&product)
+		if reflect.DeepEqual(previous.Categories, product.Categories) && reflect.DeepEqual(previous.CategoryIDs, product.CategoryIDs) {
+			continue
+		}
+		if err := c.products.Put(key, product); err != nil {
+			return moved, err
+		}
+		c.indexLocked(&previous, product)
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/config"
+)
+
+// runCommand runs the import or export command against a running
+// catalog-service through its API:
+//
+//	catalog-service import [-url URL] [-format csv|jsonl] [-platform NAME] [-map column=field]... [-dry-run] FILE
+//	catalog-service export [-url URL] [-format csv|jsonl|google-xml|google-tsv] [-platform NAME] [-o FILE]
+func runCommand(cfg config.Config, name string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	baseURL := flags.String("url", "http://localhost:"+cfg.HTTPPort, "catalog-service URL")
+	format := flags.String("format", "", "file format")
+	platform := flags.String("platform", "", "platform the products are recorded under, or exported from")
+
+	if name == "export" {
+		output := flags.String("o", "", "file to write instead of stdout")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		return runExport(*baseURL, *format, *platform, *output)
+	}
+
+	var mapping []string
+	flags.Func("map", "column=field naming the field a column holds; repeatable", func(value string) error {
+		mapping = append(mapping, value)
+		return nil
+	})
+	dryRun := flags.Bool("dry-run", false, "validate the file without importing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: catalog-service import [flags] FILE")
+	}
+	return runImport(*baseURL, flags.Arg(0), *format, *platform, mapping, *dryRun)
+}
+
+// runImport uploads the file, waits for its job to finish and prints the
+// job's report. It fails when any product could not be imported.
+func runImport(baseURL, path, format, platform string, mapping []string, dryRun bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "" {
+		format = formatCSV
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".jsonl" || ext == ".ndjson" {
+			format = formatJSONL
+		}
+	}
+	query := url.Values{"format": {format}, "map": mapping}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	resp, err := http.Post(baseURL+"/imports?"+query.Encode(), "application/octet-stream", file)
+	if err != nil {
+		return err
+	}
+	var job importJob
+	if err := decodeResponse(resp, http.StatusAccepted, &job); err != nil {
+		return err
+	}
+
+	for job.Status == importQueued || job.Status == importRunning {
+		time.Sleep(time.Second)
+		resp, err := http.Get(baseURL + "/imports/" + url.PathEscape(job.ID))
+		if err != nil {
+			return err
+		}
+		if err := decodeResponse(resp, http.StatusOK, &job); err != nil {
+			return err
+		}
+	}
+
+	report, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(report))
+
+	switch {
+	case job.Status == importFailed:
+		return errors.New(job.Error)
+	case job.Invalid > 0 || job.Failed > 0:
+		return fmt.Errorf("%d invalid and %d failed products", job.Invalid, job.Failed)
+	}
+	return nil
+}
+
+// runExport writes the exported catalog to output, or stdout when empty.
+func runExport(baseURL, format, platform, output string) error {
+	query := url.Values{}
+	if format != "" {
+		query.Set("format", format)
+	}
+	if platform != "" {
+		query.Set("platform", platform)
+	}
+
+	resp, err := http.Get(baseURL + "/products/export?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func decodeResponse(resp *http.Response, status int, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
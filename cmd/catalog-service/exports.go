@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ecommerce-platform/internal/models"
+)
+
+// Google Merchant feed formats, exported besides the import formats.
+const (
+	formatGoogleXML = "google-xml"
+	formatGoogleTSV = "google-tsv"
+)
+
+// feedConfig fills the Google Merchant attributes the catalog has no data
+// for. Links are templates in which {id} and {sku} stand for the product ID
+// and the SKU of the item.
+type feedConfig struct {
+	Link      string
+	ImageLink string
+	Currency  string
+}
+
+// export returns the products, only those sold on platform when set, in
+// key order and without the platform records they are merged from.
+func (c *catalog) export(platform string) ([]models.CatalogProduct, error) {
+	var products []models.CatalogProduct
+	err := c.products.ForEach(func(key string, value []byte) error {
+		var product models.CatalogProduct
+		if err := json.Unmarshal(value, &product); err != nil {
+			return err
+		}
+		if _, ok := product.PlatformIDs[platform]; platform != "" && !ok {
+			return nil
+		}
+		product.Sources = nil
+		products = append(products, product)
+		return nil
+	})
+	return products, err
+}
+
+// writeCSVExport writes the products in the columns a CSV import reads: a
+// row per simple product or bundle, and a row per variant of a configurable
+// product. There is no id column, as the IDs platforms know products by
+// differ; importing the file back matches products by SKU, using the first
+// variant's for products without one. The price and stock of a configurable
+// product come from its variants and are left empty, so importing the file
+// back does not pin them.
+func writeCSVExport(w io.Writer, products []models.CatalogProduct) error {
+	var options []string
+	for _, product := range products {
+		for _, option := range product.Options {
+			if !slices.Contains(options, option.Name) {
+				options = append(options, option.Name)
+			}
+		}
+	}
+
+	header := []string{"sku", "name", "description", "price", "cost", "stock",
+		"variant_sku", "variant_title", "variant_price", "variant_cost", "variant_stock"}
+	for _, name := range options {
+		header = append(header, optionColumn+name)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, product := range products {
+		sku := product.SKU
+		if sku == "" && len(product.Variants) > 0 {
+			sku = product.Variants[0].SKU
+		}
+		row := []string{sku, product.Name, product.Description}
+		if len(product.Variants) == 0 {
+			row = append(row, formatNumber(product.Price), formatNumber(product.Cost), strconv.Itoa(product.Stock))
+			row = append(row, make([]string, 5+len(options))...)
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+			continue
+		}
+		row = append(row, "", formatNumber(product.Cost), "")
+		for _, variant := range product.Variants {
+			variantRow := append(append([]string(nil), row...),
+				variant.SKU, variant.Title, formatNumber(variant.Price), formatNumber(variant.Cost), strconv.Itoa(variant.Stock))
+			for _, name := range options {
+				variantRow = append(variantRow, variant.Options[name])
+			}
+			if err := writer.Write(variantRow); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJSONLExport writes a product per line, in the form a JSON Lines
+// import reads.
+func writeJSONLExport(w io.Writer, products []models.CatalogProduct) error {
+	encoder := json.NewEncoder(w)
+	for _, product := range products {
+		if err := encoder.Encode(product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// feedItem is a product as Google Merchant lists it. Configurable products
+// are listed as one item per variant, grouped by the product ID.
+type feedItem struct {
+	ID           string `xml:"g:id"`
+	Title        string `xml:"title"`
+	Description  string `xml:"description"`
+	Link         string `xml:"link,omitempty"`
+	ImageLink    string `xml:"g:image_link,omitempty"`
+	Availability string `xml:"g:availability"`
+	Price        string `xml:"g:price"`
+	Condition    string `xml:"g:condition"`
+	MPN          string `xml:"g:mpn"`
+	ItemGroupID  string `xml:"g:item_group_id,omitempty"`
+}
+
+func feedItems(products []models.CatalogProduct, feed feedConfig) []feedItem {
+	var items []feedItem
+	add := func(product models.CatalogProduct, sku, title string, price float64, stock int, group string) {
+		description := product.Description
+		if description == "" {
+			description = title
+		}
+		availability := "out_of_stock"
+		if stock > 0 {
+			availability = "in_stock"
+		}
+		items = append(items, feedItem{
+			ID:           sku,
+			Title:        title,
+			Description:  description,
+			Link:         expandLink(feed.Link, product.ID, sku),
+			ImageLink:    expandLink(feed.ImageLink, product.ID, sku),
+			Availability: availability,
+			Price:        fmt.Sprintf("%.2f %s", price, feed.Currency),
+			Condition:    "new",
+			MPN:          sku,
+			ItemGroupID:  group,
+		})
+	}
+
+	for _, product := range products {
+		if len(product.Variants) == 0 {
+			sku := product.SKU
+			if sku == "" {
+				sku = product.ID
+			}
+			add(product, sku, product.Name, product.Price, product.Stock, "")
+			continue
+		}
+		for _, variant := range product.Variants {
+			title := product.Name
+			if label := variantLabel(product, variant); label != "" {
+				title += " - " + label
+			}
+			add(product, variant.SKU, title, variant.Price, variant.Stock, product.ID)
+		}
+	}
+	return items
+}
+
+// variantLabel is the variant's title, or its option values in the order
+// of the product's options.
+func variantLabel(product models.CatalogProduct, variant models.ProductVariant) string {
+	if variant.Title != "" {
+		return variant.Title
+	}
+	var values []string
+	for _, option := range product.Options {
+		if value := variant.Options[option.Name]; value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " / ")
+}
+
+func expandLink(template, id, sku string) string {
+	if template == "" {
+		return ""
+	}
+	return strings.NewReplacer("{id}", url.PathEscape(id), "{sku}", url.PathEscape(sku)).Replace(template)
+}
+
+type feedRSS struct {
+	XMLName   xml.Name    `xml:"rss"`
+	Version   string      `xml:"version,attr"`
+	Namespace string      `xml:"xmlns:g,attr"`
+	Channel   feedChannel `xml:"channel"`
+}
+
+type feedChannel struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Items       []feedItem `xml:"item"`
+}
+
+// writeGoogleXML writes the products as a Google Merchant RSS 2.0 feed.
+func writeGoogleXML(w io.Writer, products []models.CatalogProduct, feed feedConfig) error {
+	var site string
+	if link, err := url.Parse(feed.Link); err == nil && link.Host != "" {
+		site = link.Scheme + "://" + link.Host
+	}
+	rss := feedRSS{
+		Version:   "2.0",
+		Namespace: "http://base.google.com/ns/1.0",
+		Channel: feedChannel{
+			Title:       "Product catalog",
+			Link:        site,
+			Description: "Products exported from the catalog",
+			Items:       feedItems(products, feed),
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(rss); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeGoogleTSV writes the products as a Google Merchant tab-separated
+// feed. Tabs and line breaks inside values are replaced by spaces, as the
+// format has no quoting.
+func writeGoogleTSV(w io.Writer, products []models.CatalogProduct, feed feedConfig) error {
+	clean := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
+	lines := []string{"id\ttitle\tdescription\tlink\timage_link\tavailability\tprice\tcondition\tmpn\titem_group_id"}
+	for _, item := range feedItems(products, feed) {
+		values := []string{item.ID, item.Title, item.Description, item.Link, item.ImageLink,
+			item.Availability, item.Price, item.Condition, item.MPN, item.ItemGroupID}
+		for i, value := range values {
+			values[i] = clean.Replace(value)
+		}
+		lines = append(lines, strings.Join(values, "\t"))
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+var errInvalidImport = errors.New("invalid import")
+
+// Import file formats.
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// Import job statuses.
+const (
+	importQueued    = "queued"
+	importRunning   = "running"
+	importCompleted = "completed"
+	importFailed    = "failed"
+)
+
+// importSource is the platform imported products are recorded under when
+// the import does not name one.
+const importSource = "import"
+
+const (
+	// maxImportSize bounds the files accepted for import.
+	maxImportSize = 256 << 20
+	// maxImportErrors bounds the row errors kept on a job; the counts still
+	// cover every invalid product.
+	maxImportErrors = 1000
+	// importProgressEvery is how many products are imported between saves
+	// of the job's progress.
+	importProgressEvery = 500
+)
+
+// csvFields are the columns a CSV import understands. The variant columns
+// describe one variant per row, and an option:<name> column holds the value
+// of that option for the row's variant. Rows sharing an id, or a sku when
+// there is no id, make up one product.
+var csvFields = []string{
+	"id", "sku", "name", "description", "price", "cost", "stock",
+	"variant_id", "variant_sku", "variant_title", "variant_price", "variant_cost", "variant_stock",
+}
+
+const optionColumn = "option:"
+
+// jsonlFields are the keys a JSON Lines import understands, the same as
+// those of the products exported as JSON Lines.
+var jsonlFields = []string{
+	"id", "sku", "name", "description", "price", "cost", "stock",
+	"options", "variants", "components", "platform_ids",
+}
+
+// importJob tracks an import running in the background. For a dry run
+// Created and Updated count the products the import would create and
+// update.
+type importJob struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Format     string        `json:"format"`
+	Platform   string        `json:"platform"`
+	DryRun     bool          `json:"dry_run"`
+	Rows       int           `json:"rows"`
+	Products   int           `json:"products"`
+	Processed  int           `json:"processed"`
+	Created    int           `json:"created"`
+	Updated    int           `json:"updated"`
+	Invalid    int           `json:"invalid"`
+	Failed     int           `json:"failed"`
+	Errors     []importError `json:"errors,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
+
+// importError is a problem with the product starting at Line of the file.
+type importError struct {
+	Line  int    `json:"line"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+func (job *importJob) addErrors(errs []importError) {
+	for _, e := range errs {
+		if len(job.Errors) >= maxImportErrors {
+			return
+		}
+		job.Errors = append(job.Errors, e)
+	}
+}
+
+// importRecord is one product read from an import file, as the record of
+// the importing platform.
+type importRecord struct {
+	Line   int
+	SKU    string
+	Source models.ProductSource
+	Errors []importError
+}
+
+func (rec *importRecord) fail(line int, format string, args ...interface{}) {
+	rec.Errors = append(rec.Errors, importError{Line: line, SKU: rec.SKU, Error: fmt.Sprintf(format, args...)})
+}
+
+// parseMapping reads entries written as column=field, naming the catalog
+// field a column of the file holds.
+func parseMapping(format string, entries []string) (map[string]string, error) {
+	fields := csvFields
+	if format == formatJSONL {
+		fields = jsonlFields
+	}
+	mapping := make(map[string]string)
+	for _, entry := range entries {
+		column, field, ok := strings.Cut(entry, "=")
+		option := format == formatCSV && strings.HasPrefix(field, optionColumn) && len(field) > len(optionColumn)
+		if !ok || column == "" || !slices.Contains(fields, field) && !option {
+			return nil, fmt.Errorf("%w: mapping %q", errInvalidImport, entry)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// csvField returns the field a CSV column holds: the mapped one, or the
+// column itself when named after a field. Other columns are ignored.
+func csvField(column string, mapping map[string]string) string {
+	if field, ok := mapping[column]; ok {
+		return field
+	}
+	if name := strings.ToLower(column); slices.Contains(csvFields, name) {
+		return name
+	}
+	if strings.HasPrefix(strings.ToLower(column), optionColumn) && len(column) > len(optionColumn) {
+		return optionColumn + column[len(optionColumn):]
+	}
+	return ""
+}
+
+// readCSV reads the products of a CSV file with a header row, returning
+// them with the number of rows read. Values that cannot be parsed are
+// recorded on their product rather than failing the file.
+func readCSV(r io.Reader, mapping map[string]string) ([]*importRecord, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: reading header: %v", errInvalidImport, err)
+	}
+	fields := make([]string, len(header))
+	for i, column := range header {
+		fields[i] = csvField(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")), mapping)
+	}
+	if !slices.Contains(fields, "id") && !slices.Contains(fields, "sku") {
+		return nil, 0, fmt.Errorf("%w: no id or sku column", errInvalidImport)
+	}
+
+	var records []*importRecord
+	byKey := make(map[string]*importRecord)
+	rows := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, rows, fmt.Errorf("%w: %v", errInvalidImport, err)
+		}
+		rows++
+		line, _ := reader.FieldPos(0)
+
+		values := make(map[string]string)
+		for i, value := range row {
+			if i < len(fields) && fields[i] != "" {
+				if value = strings.TrimSpace(value); value != "" {
+					values[fields[i]] = value
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		key := values["id"]
+		if key == "" {
+			key = models.CanonicalSKU(values["sku"])
+		}
+		if key == "" {
+			rec := &importRecord{Line: line}
+			rec.fail(line, "missing id or sku")
+			records = append(records, rec)
+			continue
+		}
+		rec, ok := byKey[key]
+		if !ok {
+			rec = &importRecord{Line: line, Source: models.ProductSource{ID: values["id"]}}
+			byKey[key] = rec
+			records = append(records, rec)
+		}
+		if rec.SKU == "" {
+			rec.SKU = values["sku"]
+		}
+		readProductColumns(rec, line, values)
+		if hasVariantColumns(values) {
+			readVariantColumns(rec, line, fields, values)
+		}
+	}
+	return records, rows, nil
+}
+
+// readProductColumns sets the product fields of rec the row has a value
+// for and the rows before did not.
+func readProductColumns(rec *importRecord, line int, values map[string]string) {
+	if name, ok := values["name"]; ok && rec.Source.Name == nil {
+		rec.Source.Name = &name
+	}
+	if description, ok := values["description"]; ok && rec.Source.Description == nil {
+		rec.Source.Description = &description
+	}
+	if rec.Source.Price == nil {
+		rec.Source.Price = parseNumberColumn(rec, line, values, "price")
+	}
+	if rec.Source.Cost == nil {
+		rec.Source.Cost = parseNumberColumn(rec, line, values, "cost")
+	}
+	if rec.Source.Stock == nil {
+		rec.Source.Stock = parseIntColumn(rec, line, values, "stock")
+	}
+}
+
+func hasVariantColumns(values map[string]string) bool {
+	for field := range values {
+		if strings.HasPrefix(field, "variant_") || strings.HasPrefix(field, optionColumn) {
+			return true
+		}
+	}
+	return false
+}
+
+// readVariantColumns adds the variant described by the row to rec. Option
+// columns are read in the order of the header.
+func readVariantColumns(rec *importRecord, line int, fields []string, values map[string]string) {
+	variant := models.SourceVariant{
+		ID:    values["variant_id"],
+		SKU:   values["variant_sku"],
+		Title: values["variant_title"],
+		Price: parseNumberColumn(rec, line, values, "variant_price"),
+		Cost:  parseNumberColumn(rec, line, values, "variant_cost"),
+		Stock: parseIntColumn(rec, line, values, "variant_stock"),
+	}
+	for _, field := range fields {
+		value, ok := values[field]
+		if !ok || !strings.HasPrefix(field, optionColumn) {
+			continue
+		}
+		name := strings.TrimPrefix(field, optionColumn)
+		if variant.Options == nil {
+			variant.Options = make(map[string]string)
+		}
+		variant.Options[name] = value
+		rec.Source.Options = addOption(rec.Source.Options, name)
+		rec.Source.Options = addOptionValue(rec.Source.Options, name, value)
+	}
+	rec.Source.Variants = append(rec.Source.Variants, variant)
+}
+
+func parseNumberColumn(rec *importRecord, line int, values map[string]string, field string) *float64 {
+	value, ok := values[field]
+	if !ok {
+		return nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		rec.fail(line, "invalid %s %q", field, value)
+		return nil
+	}
+	return &number
+}
+
+func parseIntColumn(rec *importRecord, line int, values map[string]string, field string) *int {
+	value, ok := values[field]
+	if !ok {
+		return nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		rec.fail(line, "invalid %s %q", field, value)
+		return nil
+	}
+	return &number
+}
+
+// importProduct is a product as written on a line of a JSON Lines import.
+// Products exported as JSON Lines read back as the same products.
+type importProduct struct {
+	ID          string                   `json:"id"`
+	SKU         string                   `json:"sku"`
+	Name        *string                  `json:"name"`
+	Description *string                  `json:"description"`
+	Price       *float64                 `json:"price"`
+	Cost        *float64                 `json:"cost"`
+	Stock       *int                     `json:"stock"`
+	Options     []models.ProductOption   `json:"options"`
+	Variants    []importVariant          `json:"variants"`
+	Components  []models.BundleComponent `json:"components"`
+	PlatformIDs map[string]string        `json:"platform_ids"`
+}
+
+type importVariant struct {
+	ID          string            `json:"id"`
+	SKU         string            `json:"sku"`
+	Title       string            `json:"title"`
+	Options     map[string]string `json:"options"`
+	Price       *float64          `json:"price"`
+	Cost        *float64          `json:"cost"`
+	Stock       *int              `json:"stock"`
+	PlatformIDs map[string]string `json:"platform_ids"`
+}
+
+// readJSONL reads one product per line, returning them with the number of
+// lines read. Lines that are not a product are recorded as invalid.
+func readJSONL(r io.Reader, platform string, mapping map[string]string) ([]*importRecord, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	var records []*importRecord
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var product importProduct
+		err := decodeMapped(text, mapping, &product)
+		if err != nil {
+			rec := &importRecord{Line: line}
+			rec.fail(line, "invalid product: %v", err)
+			records = append(records, rec)
+			continue
+		}
+		records = append(records, &importRecord{Line: line, SKU: product.SKU, Source: product.source(platform)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, line, fmt.Errorf("%w: %v", errInvalidImport, err)
+	}
+	return records, line, nil
+}
+
+// decodeMapped decodes a JSON object into v after renaming its keys by
+// mapping.
+func decodeMapped(data []byte, mapping map[string]string, v interface{}) error {
+	if len(mapping) == 0 {
+		return json.Unmarshal(data, v)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	renamed := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		if field, ok := mapping[key]; ok {
+			key = field
+		}
+		renamed[key] = value
+	}
+	data, err := json.Marshal(renamed)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// source converts the product to the record of platform. The IDs are the
+// ones platform knows the product and its variants by, if listed. A
+// product with variants takes its price and stock from them, as the
+// catalog derives them.
+func (p importProduct) source(platform string) models.ProductSource {
+	source := models.ProductSource{
+		ID:          p.PlatformIDs[platform],
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Cost:        p.Cost,
+		Stock:       p.Stock,
+		Options:     p.Options,
+		Components:  p.Components,
+	}
+	if source.ID == "" {
+		source.ID = p.ID
+	}
+	if len(p.Variants) > 0 {
+		source.Price, source.Stock = nil, nil
+	}
+	for _, v := range p.Variants {
+		variant := models.SourceVariant{
+			ID:      v.PlatformIDs[platform],
+			SKU:     v.SKU,
+			Title:   v.Title,
+			Options: v.Options,
+			Price:   v.Price,
+			Cost:    v.Cost,
+			Stock:   v.Stock,
+		}
+		if variant.ID == "" {
+			variant.ID = v.ID
+		}
+		source.Variants = append(source.Variants, variant)
+	}
+	return source
+}
+
+// validateRecord checks the values of a product read from an import. A
+// product the catalog does not have yet needs a name.
+func validateRecord(rec *importRecord, exists bool) {
+	source := rec.Source
+	if source.ID == "" {
+		rec.fail(rec.Line, "missing id or sku")
+	}
+	if !exists && (source.Name == nil || *source.Name == "") {
+		rec.fail(rec.Line, "name is required for new products")
+	}
+	if source.Price != nil && *source.Price < 0 {
+		rec.fail(rec.Line, "negative price")
+	}
+	if source.Cost != nil && *source.Cost < 0 {
+		rec.fail(rec.Line, "negative cost")
+	}
+	if source.Stock != nil && *source.Stock < 0 {
+		rec.fail(rec.Line, "negative stock")
+	}
+
+	skus := make(map[string]bool)
+	for i, variant := range source.Variants {
+		sku := models.CanonicalSKU(variant.SKU)
+		switch {
+		case sku == "":
+			rec.fail(rec.Line, "variant %d has no sku", i+1)
+		case skus[sku]:
+			rec.fail(rec.Line, "duplicate variant %s", variant.SKU)
+		}
+		skus[sku] = true
+		if variant.Price != nil && *variant.Price < 0 {
+			rec.fail(rec.Line, "variant %s has a negative price", variant.SKU)
+		}
+		if variant.Cost != nil && *variant.Cost < 0 {
+			rec.fail(rec.Line, "variant %s has a negative cost", variant.SKU)
+		}
+		if variant.Stock != nil && *variant.Stock < 0 {
+			rec.fail(rec.Line, "variant %s has a negative stock", variant.SKU)
+		}
+	}
+	for _, component := range source.Components {
+		if models.CanonicalSKU(component.SKU) == "" || component.Quantity <= 0 {
+			rec.fail(rec.Line, "invalid component %q", component.SKU)
+		}
+	}
+}
+
+// importer runs imports in the background and keeps their jobs in a
+// store, so their reports outlive the request that started them.
+type importer struct {
+	catalog *catalog
+	jobs    *state.Store
+	mu      sync.Mutex
+	lastID  int64
+}
+
+// newImporter marks the jobs a restart cut short as failed, as the files
+// they were importing are not kept.
+func newImporter(c *catalog, jobs *state.Store) (*importer, error) {
+	imp := &importer{catalog: c, jobs: jobs}
+
+	var interrupted []importJob
+	err := jobs.ForEach(func(key string, value []byte) error {
+		var job importJob
+		if err := json.Unmarshal(value, &job); err != nil {
+			return err
+		}
+		if id, err := strconv.ParseInt(job.ID, 10, 64); err == nil && id > imp.lastID {
+			imp.lastID = id
+		}
+		if job.Status == importQueued || job.Status == importRunning {
+			interrupted = append(interrupted, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range interrupted {
+		imp.finish(&job, errors.New("interrupted by a restart"))
+	}
+	return imp, nil
+}
+
+// start queues the import of data and returns its job.
+func (imp *importer) start(format, platform string, dryRun bool, mapping map[string]string, data []byte) (importJob, error) {
+	job := importJob{
+		ID:        imp.nextID(),
+		Status:    importQueued,
+		Format:    format,
+		Platform:  platform,
+		DryRun:    dryRun,
+		CreatedAt: time.Now(),
+	}
+	if err := imp.jobs.Put(job.ID, job); err != nil {
+		return job, err
+	}
+	go imp.run(job, mapping, data)
+	return job, nil
+}
+
+// nextID returns increasing IDs, so jobs are stored in the order they were
+// started.
+func (imp *importer) nextID() string {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	id := time.Now().UnixNano()
+	if id <= imp.lastID {
+		id = imp.lastID + 1
+	}
+	imp.lastID = id
+	return fmt.Sprintf("%020d", id)
+}
+
+// run reads the file and validates every product, then upserts the valid
+// ones as the platform's records unless the job is a dry run. Invalid
+// products are skipped and reported; they do not stop the others.
+func (imp *importer) run(job importJob, mapping map[string]string, data []byte) {
+	job.Status = importRunning
+	imp.save(job)
+
+	var records []*importRecord
+	var err error
+	switch job.Format {
+	case formatJSONL:
+		records, job.Rows, err = readJSONL(bytes.NewReader(data), job.Platform, mapping)
+	default:
+		records, job.Rows, err = readCSV(bytes.NewReader(data), mapping)
+	}
+	if err != nil {
+		imp.finish(&job, err)
+		return
+	}
+	job.Products = len(records)
+
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		job.Processed++
+		if job.Processed%importProgressEvery == 0 {
+			imp.save(job)
+		}
+
+		key, exists := "", false
+		if len(rec.Errors) == 0 {
+			var product models.CatalogProduct
+			if key, product, exists, err = imp.catalog.lookup(job.Platform, rec.SKU, rec.Source); err != nil {
+				imp.finish(&job, err)
+				return
+			}
+			resolveIDs(rec, job.Platform, product)
+			exists = exists || seen[key]
+			validateRecord(rec, exists)
+		}
+		if len(rec.Errors) > 0 {
+			job.Invalid++
+			job.addErrors(rec.Errors)
+			continue
+		}
+
+		if !job.DryRun {
+			rec.Source.UpdatedAt = time.Now()
+			if _, err := imp.catalog.upsert(job.Platform, rec.SKU, rec.Source); err != nil {
+				job.Failed++
+				job.addErrors([]importError{{Line: rec.Line, SKU: rec.SKU, Error: err.Error()}})
+				continue
+			}
+		}
+		seen[key] = true
+		if exists {
+			job.Updated++
+		} else {
+			job.Created++
+		}
+	}
+	imp.finish(&job, nil)
+}
+
+// resolveIDs fills in the IDs the file left out: those platform already
+// knows the product and its variants by, or else the SKU of the product or
+// of its first variant.
+func resolveIDs(rec *importRecord, platform string, product models.CatalogProduct) {
+	if rec.Source.ID == "" {
+		rec.Source.ID = product.PlatformIDs[platform]
+	}
+	if rec.Source.ID == "" {
+		rec.Source.ID = models.CanonicalSKU(rec.SKU)
+	}
+	if rec.Source.ID == "" && len(rec.Source.Variants) > 0 {
+		rec.Source.ID = models.CanonicalSKU(rec.Source.Variants[0].SKU)
+	}
+	for i, variant := range rec.Source.Variants {
+		if existing, ok := product.Variant(models.CanonicalSKU(variant.SKU)); ok && variant.ID == "" {
+			rec.Source.Variants[i].ID = existing.PlatformIDs[platform]
+		}
+	}
+}
+
+func (imp *importer) finish(job *importJob, err error) {
+	job.Status = importCompleted
+	if err != nil {
+		job.Status = importFailed
+		job.Error = err.Error()
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+	imp.save(*job)
+	log.Printf("[catalog-service] Import %s %s: %d created, %d updated, %d invalid, %d failed",
+		job.ID, job.Status, job.Created, job.Updated, job.Invalid, job.Failed)
+}
+
+func (imp *importer) save(job importJob) {
+	if err := imp.jobs.Put(job.ID, job); err != nil {
+		log.Printf("[catalog-service] Failed to save import %s: %v", job.ID, err)
+	}
+}
+
+func (imp *importer) get(id string) (importJob, error) {
+	var job importJob
+	found, err := imp.jobs.Get(id, &job)
+	if err == nil && !found {
+		err = errNotFound
+	}
+	return job, err
+}
+
+// list returns the jobs, most recent first.
+func (imp *importer) list() ([]importJob, error) {
+	jobs := []importJob{}
+	err := imp.jobs.ForEach(func(key string, value []byte) error {
+		var job importJob
+		if err := json.Unmarshal(value, &job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+		jobs[i], jobs[j] = jobs[j], jobs[i]
+	}
+	return jobs, err
+}
@@ -3,29 +3,160 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/auth"
+	"ecommerce-platform/internal/catalogsync"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
 )
 
 func main() {
 	cfg := config.Load()
 	cfg.ServiceName = "catalog-service"
 
+	if len(os.Args) > 1 && (os.Args[1] == "import" || os.Args[1] == "export") {
+		if err := runCommand(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("[%s] %s failed: %v", cfg.ServiceName, os.Args[1], err)
+		}
+		return
+	}
+
 	consumer := kafka.NewConsumer(cfg.KafkaBroker, cfg.KafkaTopic+"-enriched", "catalog-service-group")
 	defer consumer.Close()
 
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	precedence, err := parsePrecedence(cfg.CatalogPrecedence)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse catalog precedence: %v", cfg.ServiceName, err)
+	}
+
+	db, err := state.Open(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open state store: %v", cfg.ServiceName, err)
+	}
+	defer db.Close()
+
+	if err := kafka.EnsureCompactedTopic(cfg.KafkaBroker, "catalog"); err != nil {
+		log.Printf("[%s] Failed to ensure catalog topic: %v", cfg.ServiceName, err)
+	}
+	producer := kafka.NewCompactedProducer(cfg.KafkaBroker, "catalog")
+	defer producer.Close()
+
+	products, err := db.LoggedStore("products", producer)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open products: %v", cfg.ServiceName, err)
+	}
+	restored, err := products.Restore(ctx, kafka.TopicReader(cfg.KafkaBroker, "catalog"))
+	if err != nil {
+		log.Fatalf("[%s] Failed to restore catalog: %v", cfg.ServiceName, err)
+	}
+	log.Printf("[%s] Restored %d catalog entries", cfg.ServiceName, restored)
+
+	if err := kafka.EnsureCompactedTopic(cfg.KafkaBroker, "categories"); err != nil {
+		log.Printf("[%s] Failed to ensure categories topic: %v", cfg.ServiceName, err)
+	}
+	categoryProducer := kafka.NewCompactedProducer(cfg.KafkaBroker, "categories")
+	defer categoryProducer.Close()
+
+	categories, err := db.LoggedStore("categories", categoryProducer)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open categories: %v", cfg.ServiceName, err)
+	}
+	restored, err = categories.Restore(ctx, kafka.TopicReader(cfg.KafkaBroker, "categories"))
+	if err != nil {
+		log.Fatalf("[%s] Failed to restore categories: %v", cfg.ServiceName, err)
+	}
+	log.Printf("[%s] Restored %d categories", cfg.ServiceName, restored)
+	tree, err := newTaxonomy(categories)
+	if err != nil {
+		log.Fatalf("[%s] Failed to load categories: %v", cfg.ServiceName, err)
+	}
+
+	c, err := newCatalog(products, tree, precedence, cfg.CatalogEchoWindow)
+	if err != nil {
+		log.Fatalf("[%s] Failed to load catalog: %v", cfg.ServiceName, err)
+	}
+
+	jobs, err := db.Store("imports")
+	if err != nil {
+		log.Fatalf("[%s] Failed to open imports: %v", cfg.ServiceName, err)
+	}
+	imp, err := newImporter(c, jobs)
+	if err != nil {
+		log.Fatalf("[%s] Failed to load imports: %v", cfg.ServiceName, err)
+	}
+	if err := kafka.EnsureCompactedTopic(cfg.KafkaBroker, "price-lists"); err != nil {
+		log.Printf("[%s] Failed to ensure price-lists topic: %v", cfg.ServiceName, err)
+	}
+	priceListProducer := kafka.NewCompactedProducer(cfg.KafkaBroker, "price-lists")
+	defer priceListProducer.Close()
+
+	priceLists, err := db.LoggedStore("price-lists", priceListProducer)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open price lists: %v", cfg.ServiceName, err)
+	}
+	restored, err = priceLists.Restore(ctx, kafka.TopicReader(cfg.KafkaBroker, "price-lists"))
+	if err != nil {
+		log.Fatalf("[%s] Failed to restore price lists: %v", cfg.ServiceName, err)
+	}
+	log.Printf("[%s] Restored %d price lists", cfg.ServiceName, restored)
+	publishedPrices, err := db.Store("published-prices")
+	if err != nil {
+		log.Fatalf("[%s] Failed to open published prices: %v", cfg.ServiceName, err)
+	}
+	priceChanges := kafka.NewProducer(cfg.KafkaBroker, catalogsync.PriceChangesTopic)
+	defer priceChanges.Close()
+	prices := newPricing(priceLists, publishedPrices, c, priceChanges, cfg.BaseCurrency)
+
+	feed := feedConfig{Link: cfg.CatalogFeedLink, ImageLink: cfg.CatalogFeedImageLink, Currency: cfg.BaseCurrency}
+
+	clients, err := auth.ParseClients(cfg.CatalogClients)
+	if err != nil {
+		log.Fatalf("[%s] Invalid CATALOG_CLIENTS: %v", cfg.ServiceName, err)
+	}
+
 	router := mux.NewRouter()
-	router.HandleFunc("/products", listProducts).Methods("GET")
-	router.HandleFunc("/products/{id}", getProduct).Methods("GET")
+	router.HandleFunc("/products", listProducts(c)).Methods("GET")
+	router.HandleFunc("/products/export", exportProducts(c, feed)).Methods("GET")
+	router.HandleFunc("/products/by-sku/{sku}", getProductBySKU(c)).Methods("GET")
+	router.HandleFunc("/products/by-platform/{platform}/{platform_id}", getProductByPlatform(c)).Methods("GET")
+	router.HandleFunc("/products/{id}", getProduct(c)).Methods("GET")
+	router.HandleFunc("/products/{id}", clients.RequireGrant("edit", editProduct(c))).Methods("PATCH")
+	router.HandleFunc("/products/{id}/edits", clients.RequireGrant("edit", clearEdits(c))).Methods("DELETE")
+	router.HandleFunc("/products/{id}/prices", getProductPrices(c, prices)).Methods("GET")
+	router.HandleFunc("/price-lists", listPriceLists(prices)).Methods("GET")
+	router.HandleFunc("/price-lists/{id}", getPriceList(prices)).Methods("GET")
+	router.HandleFunc("/price-lists/{id}", clients.RequireGrant("price", putPriceList(prices))).Methods("PUT")
+	router.HandleFunc("/price-lists/{id}", clients.RequireGrant("price", deletePriceList(prices))).Methods("DELETE")
+	router.HandleFunc("/price-lists/{id}/prices/{sku}", clients.RequireGrant("price", setListPrice(prices))).Methods("PUT")
+	router.HandleFunc("/price-lists/{id}/prices/{sku}", clients.RequireGrant("price", deleteListPrice(prices))).Methods("DELETE")
+	router.HandleFunc("/categories", listCategories(tree)).Methods("GET")
+	router.HandleFunc("/categories/{id}", getCategory(tree)).Methods("GET")
+	router.HandleFunc("/categories/{id}", clients.RequireGrant("categorize", putCategory(c, tree))).Methods("PUT")
+	router.HandleFunc("/categories/{id}", clients.RequireGrant("categorize", deleteCategory(c, tree))).Methods("DELETE")
+	router.HandleFunc("/categories/{id}/mappings/{platform}", clients.RequireGrant("categorize", setCategoryMapping(c, tree))).Methods("PUT")
+	router.HandleFunc("/categories/{id}/mappings/{platform}", clients.RequireGrant("categorize", deleteCategoryMapping(c, tree))).Methods("DELETE")
+	router.HandleFunc("/category-mappings/{platform}", getCategoryMappings(tree)).Methods("GET")
+	router.HandleFunc("/imports", listImports(imp)).Methods("GET")
+	router.HandleFunc("/imports", clients.RequireGrant("import", importProducts(imp))).Methods("POST")
+	router.HandleFunc("/imports/{id}", getImport(imp)).Methods("GET")
 	router.HandleFunc("/health", healthCheck).Methods("GET")
 
 	server := &http.Server{
@@ -33,12 +164,15 @@ func main() {
 		Handler: router,
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
+	inventoryConsumer := kafka.NewTableConsumer(cfg.KafkaBroker, "inventory")
+	defer inventoryConsumer.Close()
+	pushConsumer := kafka.NewConsumer(cfg.KafkaBroker, catalogsync.PushesTopic, "catalog-service-pushes-group")
+	defer pushConsumer.Close()
 
-	products := make(map[string]models.CatalogProduct)
-
-	go processCatalog(ctx, consumer, products)
+	go processCatalog(ctx, consumer, c)
+	go materializeInventory(ctx, inventoryConsumer, c)
+	go processPushes(ctx, pushConsumer, c)
+	go prices.run(ctx, cfg.CatalogPriceInterval)
 
 	go func() {
 		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
@@ -52,7 +186,7 @@ func main() {
 	server.Shutdown(context.Background())
 }
 
-func processCatalog(ctx context.Context, consumer *kafka.Consumer, products map[string]models.CatalogProduct) {
+func processCatalog(ctx context.Context, consumer *kafka.Consumer, c *catalog) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -73,50 +207,608 @@ func processCatalog(ctx context.Context, consumer *kafka.Consumer, products map[
 				continue
 			}
 
-			if enriched.EventType == "product.created" || enriched.EventType == "product.updated" {
-				product := convertToProduct(enriched)
-				products[product.ID] = product
+			switch enriched.EventType {
+			case "product.created", "product.updated":
+				sku, source := convertToSource(enriched)
+				product, err := c.upsert(enriched.Platform, sku, source)
+				if err != nil {
+					log.Printf("[catalog-service] Failed to store product %s from %s: %v", source.ID, enriched.Platform, err)
+					continue
+				}
 				log.Printf("[catalog-service] Processed product: %s from %s", product.ID, enriched.Platform)
+
+			case "product.deleted":
+				id := platformProductID(enriched)
+				product, deleted, err := c.remove(enriched.Platform, id)
+				switch {
+				case errors.Is(err, errNotFound):
+					log.Printf("[catalog-service] Ignoring delete of unknown product %s from %s", id, enriched.Platform)
+				case err != nil:
+					log.Printf("[catalog-service] Failed to delete product %s from %s: %v", id, enriched.Platform, err)
+				case deleted:
+					log.Printf("[catalog-service] Deleted product: %s", product.ID)
+				default:
+					log.Printf("[catalog-service] Removed %s from product: %s", enriched.Platform, product.ID)
+				}
 			}
 		}
 	}
 }
 
-func convertToProduct(enriched models.EnrichedEvent) models.CatalogProduct {
-	product := models.CatalogProduct{
-		ID:        enriched.ID,
-		UpdatedAt: time.Now(),
-		PlatformIDs: make(map[string]string),
+// materializeInventory applies the available-to-sell of every SKU on the
+// compacted inventory topic to the catalog stock.
+func materializeInventory(ctx context.Context, consumer *kafka.Consumer, c *catalog) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			sku := string(msg.Key)
+			var available *int
+			if len(msg.Value) > 0 && string(msg.Value) != "null" {
+				var level models.InventoryLevel
+				if err := json.Unmarshal(msg.Value, &level); err != nil {
+					log.Printf("[catalog-service] Inventory unmarshal error: %v", err)
+					continue
+				}
+				available = &level.Available
+			}
+			if err := c.setAvailable(sku, available); err != nil {
+				log.Printf("[catalog-service] Failed to update stock of %s: %v", sku, err)
+			}
+		}
 	}
+}
 
+// processPushes records the updates connectors pushed to their platforms.
+func processPushes(ctx context.Context, consumer *kafka.Consumer, c *catalog) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var push catalogsync.Push
+			if err := json.Unmarshal(msg.Value, &push); err != nil {
+				log.Printf("[catalog-service] Push unmarshal error: %v", err)
+				continue
+			}
+			if err := c.recordPush(push); err != nil {
+				log.Printf("[catalog-service] Failed to record push of %s to %s: %v", push.ProductID, push.Platform, err)
+			}
+		}
+	}
+}
+
+// platformProductID returns the product ID native to the platform that sent
+// the webhook, falling back to the event ID.
+func platformProductID(enriched models.EnrichedEvent) string {
 	if payload, ok := enriched.Payload["product"].(map[string]interface{}); ok {
-		if name, ok := payload["name"].(string); ok {
-			product.Name = name
+		switch id := payload["id"].(type) {
+		case string:
+			return id
+		case float64:
+			return strconv.FormatFloat(id, 'f', -1, 64)
 		}
-		if sku, ok := payload["sku"].(string); ok {
-			product.SKU = sku
+	}
+	return enriched.ID
+}
+
+// listProducts searches the catalog. q matches words of the name, SKU and
+// description; platform, min_price, max_price and stock (in_stock or
+// out_of_stock) filter; limit and cursor page through the results.
+func listProducts(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		q := productQuery{
+			Text:     query.Get("q"),
+			Platform: query.Get("platform"),
+			Stock:    query.Get("stock"),
+			Cursor:   query.Get("cursor"),
 		}
-		if price, ok := payload["price"].(float64); ok {
-			product.Price = price
+
+		var err error
+		if q.MinPrice, err = parseFloatParam(query.Get("min_price")); err != nil {
+			http.Error(w, "Invalid min_price", http.StatusBadRequest)
+			return
 		}
+		if q.MaxPrice, err = parseFloatParam(query.Get("max_price")); err != nil {
+			http.Error(w, "Invalid max_price", http.StatusBadRequest)
+			return
+		}
+		if category := query.Get("category"); category != "" {
+			if q.Categories, err = c.taxonomy.descendants(category); err != nil {
+				http.Error(w, "Unknown category", http.StatusBadRequest)
+				return
+			}
+		}
+		if limit := query.Get("limit"); limit != "" {
+			if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		page, err := c.index.search(q)
+		switch {
+		case errors.Is(err, errInvalidQuery):
+			http.Error(w, "Invalid stock or cursor", http.StatusBadRequest)
+		case err != nil:
+			log.Printf("[catalog-service] Product search failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, page)
+		}
+	}
+}
+
+func getProduct(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		product, err := c.get(mux.Vars(r)["id"])
+		writeProduct(w, product, err)
 	}
+}
 
-	product.PlatformIDs[enriched.Platform] = enriched.ID
-	return product
+func getProductBySKU(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		product, err := c.bySKU(mux.Vars(r)["sku"])
+		writeProduct(w, product, err)
+	}
 }
 
-func listProducts(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Products list endpoint"})
+func getProductByPlatform(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		product, err := c.byPlatformID(vars["platform"], vars["platform_id"])
+		writeProduct(w, product, err)
+	}
+}
+
+// editProduct sets product fields by hand. The edits win over every
+// platform and are pushed to the platforms selling the product.
+func editProduct(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var changes models.ProductSource
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		product, err := c.edit(mux.Vars(r)["id"], changes)
+		writeProduct(w, product, err)
+	}
+}
+
+func clearEdits(c *catalog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		product, err := c.clearEdits(mux.Vars(r)["id"])
+		writeProduct(w, product, err)
+	}
 }
 
-func getProduct(w http.ResponseWriter, r *http.Request) {
+// importProducts starts importing the file in the request body and returns
+// its job. format is csv or jsonl; platform names the platform the products
+// are recorded as coming from; every map parameter, written column=field,
+// names the field a column holds; dry_run=true only validates the file.
+func importProducts(imp *importer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = formatCSV
+		}
+		if format != formatCSV && format != formatJSONL {
+			http.Error(w, "Invalid format", http.StatusBadRequest)
+			return
+		}
+		platform := query.Get("platform")
+		if platform == "" {
+			platform = importSource
+		}
+		if platform == editSource {
+			http.Error(w, "Invalid platform", http.StatusBadRequest)
+			return
+		}
+		mapping, err := parseMapping(format, query["map"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			http.Error(w, "Import file too large", http.StatusRequestEntityTooLarge)
+			return
+		case err != nil || len(data) == 0:
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		job, err := imp.start(format, platform, query.Get("dry_run") == "true", mapping, data)
+		if err != nil {
+			log.Printf("[catalog-service] Failed to start import: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func listImports(imp *importer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := imp.list()
+		if err != nil {
+			log.Printf("[catalog-service] List imports failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	}
+}
+
+func getImport(imp *importer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, err := imp.get(mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, "Import not found", http.StatusNotFound)
+		case err != nil:
+			log.Printf("[catalog-service] Get import failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, job)
+		}
+	}
+}
+
+// exportProducts writes the catalog as csv, jsonl, or a Google Merchant
+// feed in google-xml or google-tsv. platform limits it to the products sold
+// there.
+func exportProducts(c *catalog, feed feedConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = formatCSV
+		}
+
+		var contentType, filename string
+		var write func(w io.Writer, products []models.CatalogProduct) error
+		switch format {
+		case formatCSV:
+			contentType, filename, write = "text/csv", "catalog.csv", writeCSVExport
+		case formatJSONL:
+			contentType, filename, write = "application/x-ndjson", "catalog.jsonl", writeJSONLExport
+		case formatGoogleXML:
+			contentType, filename = "application/xml", "catalog.xml"
+			write = func(w io.Writer, products []models.CatalogProduct) error {
+				return writeGoogleXML(w, products, feed)
+			}
+		case formatGoogleTSV:
+			contentType, filename = "text/tab-separated-values", "catalog.tsv"
+			write = func(w io.Writer, products []models.CatalogProduct) error {
+				return writeGoogleTSV(w, products, feed)
+			}
+		default:
+			http.Error(w, "Invalid format", http.StatusBadRequest)
+			return
+		}
+
+		products, err := c.export(query.Get("platform"))
+		if err != nil {
+			log.Printf("[catalog-service] Export failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if err := write(w, products); err != nil {
+			log.Printf("[catalog-service] Export failed: %v", err)
+		}
+	}
+}
+
+// getProductPrices returns the prices a product and its variants sell at
+// for platform, channel, currency and customer_group, now or at the time
+// given by at.
+func getProductPrices(c *catalog, prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		at, err := parseTimeParam(query.Get("at"))
+		if err != nil {
+			http.Error(w, "Invalid at: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if at.IsZero() {
+			at = time.Now()
+		}
+
+		product, err := c.get(mux.Vars(r)["id"])
+		if err != nil {
+			writeProduct(w, product, err)
+			return
+		}
+		scope := priceScope{
+			Platform:      query.Get("platform"),
+			Channel:       query.Get("channel"),
+			Currency:      strings.ToUpper(query.Get("currency")),
+			CustomerGroup: query.Get("customer_group"),
+		}
+		effective, err := prices.resolve(product, scope, at)
+		if err != nil {
+			log.Printf("[catalog-service] Price lookup failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, effective)
+	}
+}
+
+func listPriceLists(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lists, err := prices.all()
+		if err != nil {
+			log.Printf("[catalog-service] List price lists failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, lists)
+	}
+}
+
+func getPriceList(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := prices.get(mux.Vars(r)["id"])
+		writePriceList(w, list, err)
+	}
+}
+
+// putPriceList creates or replaces a price list.
+func putPriceList(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var list models.PriceList
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		list.ID = mux.Vars(r)["id"]
+		list, err := prices.put(list)
+		writePriceList(w, list, err)
+	}
+}
+
+func deletePriceList(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := prices.remove(mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, errPriceListNotFound):
+			http.Error(w, "Price list not found", http.StatusNotFound)
+		case err != nil:
+			log.Printf("[catalog-service] Delete price list failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// setListPrice sets the price of one SKU in a price list.
+func setListPrice(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Price *float64 `json:"price"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Price == nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		vars := mux.Vars(r)
+		list, err := prices.setPrice(vars["id"], vars["sku"], *req.Price)
+		writePriceList(w, list, err)
+	}
+}
+
+func deleteListPrice(prices *pricing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		list, err := prices.removePrice(vars["id"], vars["sku"])
+		writePriceList(w, list, err)
+	}
+}
+
+func writePriceList(w http.ResponseWriter, list models.PriceList, err error) {
+	switch {
+	case errors.Is(err, errInvalidPriceList):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errPriceListNotFound):
+		http.Error(w, "Price list not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("[catalog-service] Price list update failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func listCategories(tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tree.list())
+	}
+}
+
+func getCategory(tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		category, err := tree.get(mux.Vars(r)["id"])
+		writeCategory(w, category, err)
+	}
+}
+
+// putCategory creates or replaces a category and files the products again,
+// as its name, aliases and mappings decide which products it holds.
+func putCategory(c *catalog, tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var category models.Category
+		if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		category.ID = mux.Vars(r)["id"]
+		category, err := tree.put(category)
+		if err == nil {
+			recategorize(c)
+		}
+		writeCategory(w, category, err)
+	}
+}
+
+func deleteCategory(c *catalog, tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := tree.remove(mux.Vars(r)["id"])
+		switch {
+		case errors.Is(err, errCategoryNotFound):
+			http.Error(w, "Category not found", http.StatusNotFound)
+		case errors.Is(err, errCategoryInUse):
+			http.Error(w, "Category has subcategories", http.StatusConflict)
+		case err != nil:
+			log.Printf("[catalog-service] Delete category failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			recategorize(c)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// setCategoryMapping sets the platform categories, such as Shopify
+// collection or Magento category IDs, that map to a category.
+func setCategoryMapping(c *catalog, tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		vars := mux.Vars(r)
+		category, err := tree.setMapping(vars["id"], vars["platform"], req.IDs)
+		if err == nil {
+			recategorize(c)
+		}
+		writeCategory(w, category, err)
+	}
+}
+
+func deleteCategoryMapping(c *catalog, tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		category, err := tree.setMapping(vars["id"], vars["platform"], nil)
+		if err == nil {
+			recategorize(c)
+		}
+		writeCategory(w, category, err)
+	}
+}
+
+// getCategoryMappings returns the canonical category of every category of
+// a platform that is mapped, by platform category ID.
+func getCategoryMappings(tree *taxonomy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tree.mappings(mux.Vars(r)["platform"]))
+	}
+}
+
+// recategorize files the products again after the category tree changed.
+// The change itself is saved either way, so a failure is only logged.
+func recategorize(c *catalog) {
+	moved, err := c.recategorize()
+	if err != nil {
+		log.Printf("[catalog-service] Recategorizing products failed: %v", err)
+		return
+	}
+	if moved > 0 {
+		log.Printf("[catalog-service] Recategorized %d products", moved)
+	}
+}
+
+func writeCategory(w http.ResponseWriter, category models.Category, err error) {
+	switch {
+	case errors.Is(err, errCategoryNotFound):
+		http.Error(w, "Category not found", http.StatusNotFound)
+	case errors.Is(err, errInvalidCategory):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		log.Printf("[catalog-service] Category request failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, category)
+	}
+}
+
+func writeProduct(w http.ResponseWriter, product models.CatalogProduct, err error) {
+	switch {
+	case errors.Is(err, errInvalidEdit):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	case err != nil:
+		log.Printf("[catalog-service] Lookup failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, product)
+	}
+}
+
+func parseFloatParam(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date")
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Get product endpoint"})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
-
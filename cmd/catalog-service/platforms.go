@@ -0,0 +1,387 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+// convertToSource reads the platform's record of a product from a product
+// webhook, leaving fields the payload does not carry unset. Variants,
+// options, bundle components and categories are read from each platform's
+// own shape.
+func convertToSource(enriched models.EnrichedEvent) (string, models.ProductSource) {
+	source := models.ProductSource{
+		ID:        platformProductID(enriched),
+		UpdatedAt: enriched.ReceivedAt,
+	}
+	if source.UpdatedAt.IsZero() {
+		source.UpdatedAt = time.Now()
+	}
+
+	payload, ok := enriched.Payload["product"].(map[string]interface{})
+	if !ok {
+		return "", source
+	}
+
+	if name := textField(payload, "name", "title"); name != "" {
+		source.Name = &name
+	}
+	if description, ok := firstString(payload, "description", "body_html"); ok {
+		source.Description = &description
+	}
+	source.Price = numberField(payload, "price")
+	source.Cost = numberField(payload, "cost", "cost_price")
+	source.Stock = intField(payload, "stock", "inventory_quantity", "inventory_level", "qty")
+
+	switch enriched.Platform {
+	case "shopify":
+		source.Options, source.Variants = shopifyVariants(payload)
+	case "bigcommerce":
+		source.Options, source.Variants = bigCommerceVariants(payload)
+	case "magento":
+		source.Options, source.Variants = magentoVariants(payload)
+		source.Components = magentoBundle(payload)
+	default:
+		source.Options, source.Variants = genericVariants(payload)
+	}
+	if source.Components == nil {
+		source.Components = bundleComponents(payload)
+	}
+	source.Categories = sourceCategories(enriched.Platform, payload)
+
+	return textField(payload, "sku"), source
+}
+
+// shopifyVariants maps Shopify variants, whose option values sit in
+// option1..option3 in the order of the product's options.
+func shopifyVariants(payload map[string]interface{}) ([]models.ProductOption, []models.SourceVariant) {
+	options := parseOptions(payload["options"])
+
+	var variants []models.SourceVariant
+	for _, fields := range objects(payload["variants"]) {
+		variant := models.SourceVariant{
+			ID:    textField(fields, "id"),
+			SKU:   textField(fields, "sku"),
+			Title: textField(fields, "title"),
+			Price: numberField(fields, "price"),
+			Stock: intField(fields, "inventory_quantity"),
+		}
+		for i, option := range options {
+			if value := textField(fields, "option"+strconv.Itoa(i+1)); value != "" {
+				if variant.Options == nil {
+					variant.Options = make(map[string]string)
+				}
+				variant.Options[option.Name] = value
+			}
+		}
+		variants = append(variants, variant)
+	}
+	return options, variants
+}
+
+// bigCommerceVariants maps BigCommerce variants. Options are only named on
+// the variants' option_values, so they are collected from there.
+func bigCommerceVariants(payload map[string]interface{}) ([]models.ProductOption, []models.SourceVariant) {
+	var options []models.ProductOption
+	var variants []models.SourceVariant
+	for _, fields := range objects(payload["variants"]) {
+		variant := models.SourceVariant{
+			ID:    textField(fields, "id"),
+			SKU:   textField(fields, "sku"),
+			Price: numberField(fields, "price", "calculated_price"),
+			Cost:  numberField(fields, "cost_price"),
+			Stock: intField(fields, "inventory_level"),
+		}
+		for _, value := range objects(fields["option_values"]) {
+			name, label := textField(value, "option_display_name"), textField(value, "label")
+			if name == "" || label == "" {
+				continue
+			}
+			if variant.Options == nil {
+				variant.Options = make(map[string]string)
+			}
+			variant.Options[name] = label
+			options = addOptionValue(options, name, label)
+		}
+		variants = append(variants, variant)
+	}
+	return options, variants
+}
+
+// magentoVariants maps a Magento configurable product. Its children are
+// simple products listed under children, each carrying the value of every
+// configurable attribute by attribute label.
+func magentoVariants(payload map[string]interface{}) ([]models.ProductOption, []models.SourceVariant) {
+	var labels []string
+	if attributes, ok := payload["extension_attributes"].(map[string]interface{}); ok {
+		for _, option := range objects(attributes["configurable_product_options"]) {
+			if label := textField(option, "label"); label != "" {
+				labels = append(labels, label)
+			}
+		}
+	}
+
+	var options []models.ProductOption
+	for _, label := range labels {
+		options = append(options, models.ProductOption{Name: label})
+	}
+
+	var variants []models.SourceVariant
+	for _, fields := range objects(payload["children"]) {
+		variant := models.SourceVariant{
+			ID:    textField(fields, "id"),
+			SKU:   textField(fields, "sku"),
+			Title: textField(fields, "name"),
+			Price: numberField(fields, "price"),
+			Stock: intField(fields, "qty"),
+		}
+		values, _ := fields["options"].(map[string]interface{})
+		for _, label := range labels {
+			if value := textField(values, label); value != "" {
+				if variant.Options == nil {
+					variant.Options = make(map[string]string)
+				}
+				variant.Options[label] = value
+				options = addOptionValue(options, label, value)
+			}
+		}
+		variants = append(variants, variant)
+	}
+	return options, variants
+}
+
+// magentoBundle reads the links of every option of a Magento bundle
+// product.
+func magentoBundle(payload map[string]interface{}) []models.BundleComponent {
+	attributes, ok := payload["extension_attributes"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var components []models.BundleComponent
+	for _, option := range objects(attributes["bundle_product_options"]) {
+		for _, link := range objects(option["product_links"]) {
+			components = appendComponent(components, textField(link, "sku"), intField(link, "qty", "quantity"))
+		}
+	}
+	return components
+}
+
+// genericVariants reads variants in the platform-neutral shape used by
+// internal platforms: options as on Shopify and variants with an options
+// map.
+func genericVariants(payload map[string]interface{}) ([]models.ProductOption, []models.SourceVariant) {
+	options := parseOptions(payload["options"])
+
+	var variants []models.SourceVariant
+	for _, fields := range objects(payload["variants"]) {
+		variant := models.SourceVariant{
+			ID:    textField(fields, "id"),
+			SKU:   textField(fields, "sku"),
+			Title: textField(fields, "title", "name"),
+			Price: numberField(fields, "price"),
+			Cost:  numberField(fields, "cost"),
+			Stock: intField(fields, "stock", "inventory_quantity"),
+		}
+		if values, ok := fields["options"].(map[string]interface{}); ok {
+			variant.Options = make(map[string]string, len(values))
+			for name := range values {
+				if value := textField(values, name); value != "" {
+					variant.Options[name] = value
+					options = addOptionValue(options, name, value)
+				}
+			}
+		}
+		variants = append(variants, variant)
+	}
+	return options, variants
+}
+
+// sourceCategories reads the categories a platform files the product
+// under: Shopify collections and product type, BigCommerce category IDs,
+// Magento category links, and categories or category in the shape used by
+// internal platforms. It returns nil when the payload carries none of them,
+// so the categories received before are kept.
+func sourceCategories(platform string, payload map[string]interface{}) []models.CategoryRef {
+	var refs []models.CategoryRef
+	var found bool
+	add := func(value interface{}, idKey string, nameKeys ...string) {
+		list, ok := value.([]interface{})
+		if !ok {
+			return
+		}
+		found = true
+		for _, entry := range list {
+			var ref models.CategoryRef
+			switch v := entry.(type) {
+			case string:
+				ref = models.CategoryRef{ID: v, Name: v}
+			case float64:
+				ref.ID = strconv.FormatFloat(v, 'f', -1, 64)
+			case map[string]interface{}:
+				ref.ID = textField(v, idKey)
+				if len(nameKeys) > 0 {
+					ref.Name = textField(v, nameKeys...)
+				}
+			}
+			if ref.ID != "" || ref.Name != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	addName := func(name string) {
+		if name != "" {
+			found = true
+			refs = append(refs, models.CategoryRef{Name: name})
+		}
+	}
+
+	switch platform {
+	case "shopify":
+		add(payload["collections"], "id", "title")
+		add(payload["collection_ids"], "id")
+		addName(textField(payload, "product_type"))
+	case "bigcommerce":
+		add(payload["categories"], "id", "name")
+	case "magento":
+		if attributes, ok := payload["extension_attributes"].(map[string]interface{}); ok {
+			add(attributes["category_links"], "category_id")
+		}
+	default:
+		add(payload["categories"], "id", "name")
+		addName(textField(payload, "category"))
+	}
+	if found && refs == nil {
+		refs = []models.CategoryRef{}
+	}
+	return refs
+}
+
+// bundleComponents reads bundle contents such as Kidzania ticket bundles
+// from components or bundle_items.
+func bundleComponents(payload map[string]interface{}) []models.BundleComponent {
+	var components []models.BundleComponent
+	for _, key := range []string{"components", "bundle_items"} {
+		for _, fields := range objects(payload[key]) {
+			components = appendComponent(components, textField(fields, "sku"), intField(fields, "quantity", "qty"))
+		}
+	}
+	return components
+}
+
+func appendComponent(components []models.BundleComponent, sku string, quantity *int) []models.BundleComponent {
+	if sku == "" {
+		return components
+	}
+	component := models.BundleComponent{SKU: models.CanonicalSKU(sku), Quantity: 1}
+	if quantity != nil && *quantity > 0 {
+		component.Quantity = *quantity
+	}
+	return append(components, component)
+}
+
+func parseOptions(value interface{}) []models.ProductOption {
+	var options []models.ProductOption
+	for _, fields := range objects(value) {
+		name := textField(fields, "name")
+		if name == "" {
+			continue
+		}
+		option := models.ProductOption{Name: name}
+		if values, ok := fields["values"].([]interface{}); ok {
+			for _, value := range values {
+				if s, ok := value.(string); ok && s != "" {
+					option.Values = append(option.Values, s)
+				}
+			}
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+func addOption(options []models.ProductOption, name string) []models.ProductOption {
+	for _, option := range options {
+		if option.Name == name {
+			return options
+		}
+	}
+	return append(options, models.ProductOption{Name: name})
+}
+
+// addOptionValue adds value to the named option, adding the option if it
+// is new.
+func addOptionValue(options []models.ProductOption, name, value string) []models.ProductOption {
+	options = addOption(options, name)
+	for i := range options {
+		if options[i].Name == name && !slices.Contains(options[i].Values, value) {
+			options[i].Values = append(options[i].Values, value)
+		}
+	}
+	return options
+}
+
+func objects(value interface{}) []map[string]interface{} {
+	raw, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	list := make([]map[string]interface{}, 0, len(raw))
+	for _, entry := range raw {
+		if fields, ok := entry.(map[string]interface{}); ok {
+			list = append(list, fields)
+		}
+	}
+	return list
+}
+
+// textField returns the first of keys that holds a string or a number, as
+// platforms send IDs either way.
+func textField(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+func firstString(fields map[string]interface{}, keys ...string) (string, bool) {
+	for _, key := range keys {
+		if value, ok := fields[key].(string); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// numberField returns the first of keys that holds a number. Shopify sends
+// prices as strings, so numeric strings count too.
+func numberField(fields map[string]interface{}, keys ...string) *float64 {
+	for _, key := range keys {
+		switch value := fields[key].(type) {
+		case float64:
+			return &value
+		case string:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return &f
+			}
+		}
+	}
+	return nil
+}
+
+func intField(fields map[string]interface{}, keys ...string) *int {
+	if f := numberField(fields, keys...); f != nil {
+		n := int(*f)
+		return &n
+	}
+	return nil
+}
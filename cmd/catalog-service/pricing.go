@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/catalogsync"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+var (
+	errPriceListNotFound = errors.New("price list not found")
+	errInvalidPriceList  = errors.New("invalid price list")
+)
+
+// priceScope is the sales a price applies to.
+type priceScope struct {
+	Platform      string
+	Channel       string
+	Currency      string
+	CustomerGroup string
+}
+
+// matches reports whether list applies to sales in scope. Channels and
+// customer groups match in any case.
+func (s priceScope) matches(list models.PriceList) bool {
+	return (list.Platform == "" || list.Platform == s.Platform) &&
+		(list.Channel == "" || strings.EqualFold(list.Channel, s.Channel)) &&
+		(list.CustomerGroup == "" || strings.EqualFold(list.CustomerGroup, s.CustomerGroup)) &&
+		list.Currency == s.Currency
+}
+
+// specificity counts the scope fields a list narrows down.
+func specificity(list models.PriceList) int {
+	n := 0
+	for _, field := range []string{list.Platform, list.Channel, list.CustomerGroup} {
+		if field != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// bestPrice picks the price of sku for scope at t among lists: the
+// highest priority first, then the narrowest scope, then the list started
+// last.
+func bestPrice(lists []models.PriceList, scope priceScope, sku string, t time.Time) (models.PriceList, float64, bool) {
+	var best models.PriceList
+	var bestPrice float64
+	found := false
+	for _, list := range lists {
+		if !list.ActiveAt(t) || !scope.matches(list) {
+			continue
+		}
+		price, ok := list.Price(sku)
+		if !ok {
+			continue
+		}
+		if !found || outranks(list, best) {
+			best, bestPrice, found = list, price, true
+		}
+	}
+	return best, bestPrice, found
+}
+
+func outranks(a, b models.PriceList) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if sa, sb := specificity(a), specificity(b); sa != sb {
+		return sa > sb
+	}
+	if sa, sb := startOf(a), startOf(b); !sa.Equal(sb) {
+		return sa.After(sb)
+	}
+	return a.ID < b.ID
+}
+
+func startOf(list models.PriceList) time.Time {
+	if list.StartsAt == nil {
+		return time.Time{}
+	}
+	return *list.StartsAt
+}
+
+// pricing keeps the price lists, in a store logged to the compacted
+// price-lists topic, and publishes the prices they set as they start and
+// end. The prices last published are kept so that only changes are sent.
+type pricing struct {
+	mu        sync.Mutex
+	lists     *state.Store
+	published *state.Store
+	catalog   *catalog
+	changes   *kafka.Producer
+	currency  string
+	wake      chan struct{}
+}
+
+func newPricing(lists, published *state.Store, c *catalog, changes *kafka.Producer, currency string) *pricing {
+	return &pricing{
+		lists:     lists,
+		published: published,
+		catalog:   c,
+		changes:   changes,
+		currency:  currency,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// put creates or replaces a price list.
+func (p *pricing) put(list models.PriceList) (models.PriceList, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.putLocked(list)
+}
+
+func (p *pricing) putLocked(list models.PriceList) (models.PriceList, error) {
+	if err := p.normalize(&list); err != nil {
+		return list, err
+	}
+	list.UpdatedAt = time.Now()
+	if err := p.lists.Put(list.ID, list); err != nil {
+		return list, err
+	}
+	p.schedule()
+	return list, nil
+}
+
+// normalize checks a price list and puts SKUs in canonical form and prices
+// in SKU order. Lists without a currency are in the base currency.
+func (p *pricing) normalize(list *models.PriceList) error {
+	if list.ID == "" {
+		return fmt.Errorf("%w: missing id", errInvalidPriceList)
+	}
+	if list.Platform == editSource || list.Platform == importSource {
+		return fmt.Errorf("%w: platform %s", errInvalidPriceList, list.Platform)
+	}
+	list.Currency = strings.ToUpper(strings.TrimSpace(list.Currency))
+	if list.Currency == "" {
+		list.Currency = p.currency
+	}
+	if len(list.Currency) != 3 {
+		return fmt.Errorf("%w: currency %q", errInvalidPriceList, list.Currency)
+	}
+	if list.StartsAt != nil && list.EndsAt != nil && !list.EndsAt.After(*list.StartsAt) {
+		return fmt.Errorf("%w: ends_at is not after starts_at", errInvalidPriceList)
+	}
+
+	seen := make(map[string]bool)
+	for i, entry := range list.Prices {
+		sku := models.CanonicalSKU(entry.SKU)
+		switch {
+		case sku == "":
+			return fmt.Errorf("%w: price %d has no sku", errInvalidPriceList, i+1)
+		case seen[sku]:
+			return fmt.Errorf("%w: duplicate sku %s", errInvalidPriceList, entry.SKU)
+		case entry.Price < 0:
+			return fmt.Errorf("%w: negative price for %s", errInvalidPriceList, entry.SKU)
+		}
+		seen[sku] = true
+		list.Prices[i].SKU = sku
+	}
+	if list.Prices == nil {
+		list.Prices = []models.PriceListPrice{}
+	}
+	sort.Slice(list.Prices, func(i, j int) bool { return list.Prices[i].SKU < list.Prices[j].SKU })
+	return nil
+}
+
+// setPrice sets the price of one SKU in a list.
+func (p *pricing) setPrice(id, sku string, price float64) (models.PriceList, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list, err := p.get(id)
+	if err != nil {
+		return list, err
+	}
+	sku = models.CanonicalSKU(sku)
+	i := 0
+	for i < len(list.Prices) && list.Prices[i].SKU != sku {
+		i++
+	}
+	if i == len(list.Prices) {
+		list.Prices = append(list.Prices, models.PriceListPrice{SKU: sku})
+	}
+	list.Prices[i].Price = price
+	return p.putLocked(list)
+}
+
+// removePrice drops one SKU from a list.
+func (p *pricing) removePrice(id, sku string) (models.PriceList, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list, err := p.get(id)
+	if err != nil {
+		return list, err
+	}
+	sku = models.CanonicalSKU(sku)
+	prices := list.Prices[:0]
+	for _, entry := range list.Prices {
+		if entry.SKU != sku {
+			prices = append(prices, entry)
+		}
+	}
+	list.Prices = prices
+	return p.putLocked(list)
+}
+
+func (p *pricing) get(id string) (models.PriceList, error) {
+	var list models.PriceList
+	found, err := p.lists.Get(id, &list)
+	if err == nil && !found {
+		err = errPriceListNotFound
+	}
+	return list, err
+}
+
+func (p *pricing) remove(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.get(id); err != nil {
+		return err
+	}
+	if err := p.lists.Delete(id); err != nil {
+		return err
+	}
+	p.schedule()
+	return nil
+}
+
+func (p *pricing) all() ([]models.PriceList, error) {
+	lists := []models.PriceList{}
+	err := p.lists.ForEach(func(key string, value []byte) error {
+		var list models.PriceList
+		if err := json.Unmarshal(value, &list); err != nil {
+			return err
+		}
+		lists = append(lists, list)
+		return nil
+	})
+	return lists, err
+}
+
+// effectivePrice is the price a SKU sells at in a scope.
+type effectivePrice struct {
+	SKU          string   `json:"sku"`
+	Price        *float64 `json:"price"`
+	Currency     string   `json:"currency"`
+	RegularPrice float64  `json:"regular_price"`
+	PriceListID  string   `json:"price_list_id,omitempty"`
+}
+
+// resolve returns the prices of a product and its variants in scope at t.
+// Without a price list the regular price applies in the base currency, and
+// there is no price in other currencies.
+func (p *pricing) resolve(product models.CatalogProduct, scope priceScope, t time.Time) ([]effectivePrice, error) {
+	if scope.Currency == "" {
+		scope.Currency = p.currency
+	}
+	lists, err := p.all()
+	if err != nil {
+		return nil, err
+	}
+
+	price := func(sku string, regular float64) effectivePrice {
+		effective := effectivePrice{SKU: sku, Currency: scope.Currency, RegularPrice: regular}
+		if list, price, ok := bestPrice(lists, scope, sku, t); ok {
+			effective.Price, effective.PriceListID = &price, list.ID
+		} else if scope.Currency == p.currency {
+			effective.Price = &regular
+		}
+		return effective
+	}
+
+	var prices []effectivePrice
+	if product.SKU != "" {
+		prices = append(prices, price(product.SKU, product.Price))
+	}
+	for _, variant := range product.Variants {
+		prices = append(prices, price(variant.SKU, variant.Price))
+	}
+	return prices, nil
+}
+
+// schedule asks the scheduler to publish prices now, after a list changed.
+func (p *pricing) schedule() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes price changes whenever a list starts or ends, changes, or
+// interval passes, which picks up products newly sold on a platform.
+func (p *pricing) run(ctx context.Context, interval time.Duration) {
+	for {
+		next, err := p.publish(ctx)
+		if err != nil {
+			log.Printf("[catalog-service] Failed to publish prices: %v", err)
+		}
+
+		wait := interval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-p.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// publish works out the price every list entry should have now on each
+// platform selling the SKU, and publishes those that differ from what was
+// published before. Prices no list sets any more are published without a
+// price. It returns when the next list starts or ends.
+func (p *pricing) publish(ctx context.Context) (time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	lists, err := p.all()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+	wanted := make(map[string]catalogsync.PriceChange)
+	for _, list := range lists {
+		for _, t := range []*time.Time{list.StartsAt, list.EndsAt} {
+			if t != nil && t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = *t
+			}
+		}
+		for _, entry := range list.Prices {
+			product, err := p.catalog.bySKU(entry.SKU)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return next, err
+			}
+			for _, change := range priceTargets(product, entry.SKU, list) {
+				if _, ok := wanted[change.Key()]; ok {
+					continue
+				}
+				scope := priceScope{Platform: change.Platform, Channel: change.Channel, Currency: change.Currency, CustomerGroup: change.CustomerGroup}
+				if best, price, ok := bestPrice(lists, scope, entry.SKU, now); ok {
+					change.Price, change.PriceListID = &price, best.ID
+				}
+				wanted[change.Key()] = change
+			}
+		}
+	}
+
+	published := make(map[string]catalogsync.PriceChange)
+	err = p.published.ForEach(func(key string, value []byte) error {
+		var change catalogsync.PriceChange
+		if err := json.Unmarshal(value, &change); err != nil {
+			return err
+		}
+		published[key] = change
+		return nil
+	})
+	if err != nil {
+		return next, err
+	}
+
+	for key, change := range wanted {
+		previous, ok := published[key]
+		if change.Price == nil && !ok || ok && samePriceChange(previous, change) {
+			continue
+		}
+		change.EffectiveAt = now
+		if err := p.send(ctx, change); err != nil {
+			return next, err
+		}
+	}
+	for key, previous := range published {
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		previous.Price, previous.PriceListID, previous.EffectiveAt = nil, "", now
+		if err := p.send(ctx, previous); err != nil {
+			return next, err
+		}
+	}
+	return next, nil
+}
+
+// priceTargets lists the platforms a list entry may set the price of sku
+// on: those selling the product, or the variant, within the list's scope.
+func priceTargets(product models.CatalogProduct, sku string, list models.PriceList) []catalogsync.PriceChange {
+	variant, isVariant := product.Variant(sku)
+	regular := product.Price
+	if isVariant {
+		regular = variant.Price
+	}
+
+	var targets []catalogsync.PriceChange
+	for platform, id := range product.PlatformIDs {
+		// Imported products are not on any platform to push prices to.
+		if platform == importSource || list.Platform != "" && list.Platform != platform {
+			continue
+		}
+		change := catalogsync.PriceChange{
+			Platform:      platform,
+			ProductID:     product.ID,
+			PlatformID:    id,
+			SKU:           sku,
+			Channel:       list.Channel,
+			Currency:      list.Currency,
+			CustomerGroup: list.CustomerGroup,
+			RegularPrice:  regular,
+		}
+		if isVariant {
+			if change.VariantID = variant.PlatformIDs[platform]; change.VariantID == "" {
+				continue
+			}
+		}
+		targets = append(targets, change)
+	}
+	return targets
+}
+
+func samePriceChange(a, b catalogsync.PriceChange) bool {
+	if (a.Price == nil) != (b.Price == nil) || a.Price != nil && !models.SamePrice(*a.Price, *b.Price) {
+		return false
+	}
+	return a.PriceListID == b.PriceListID && a.PlatformID == b.PlatformID && a.VariantID == b.VariantID
+}
+
+// send publishes a price change and records it, so a change that fails to
+// publish is sent again on the next run.
+func (p *pricing) send(ctx context.Context, change catalogsync.PriceChange) error {
+	if err := p.changes.Send(ctx, change.Key(), change); err != nil {
+		return err
+	}
+	if change.Price == nil {
+		return p.published.Delete(change.Key())
+	}
+	return p.published.Put(change.Key(), change)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"ecommerce-platform/internal/models"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errInvalidQuery = errors.New("invalid query")
+
+// Term weights: a match in the SKU ranks above one in the name, which ranks
+// above one in the description.
+const (
+	skuWeight         = 4
+	nameWeight        = 2
+	descriptionWeight = 1
+)
+
+// productQuery filters and pages GET /products.
+// Categories, when set, are the category searched and those below it.
+type productQuery struct {
+	Text       string
+	Platform   string
+	Categories []string
+	MinPrice   *float64
+	MaxPrice   *float64
+	Stock      string
+	Limit      int
+	Cursor     string
+}
+
+type productPage struct {
+	Products   []models.CatalogProduct `json:"products"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// productCursor is the position after the last product of a page. Results
+// are ordered by score, highest first, and then by ID.
+type productCursor struct {
+	Score int    `json:"score"`
+	ID    string `json:"id"`
+}
+
+// searchIndex is an in-memory inverted index over the catalog, rebuilt
+// from the product store on start and kept current on every write.
+type searchIndex struct {
+	mu       sync.RWMutex
+	products map[string]models.CatalogProduct
+	terms    map[string]map[string]int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		products: make(map[string]models.CatalogProduct),
+		terms:    make(map[string]map[string]int),
+	}
+}
+
+func (s *searchIndex) put(product models.CatalogProduct) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeLocked(product.ID)
+	s.products[product.ID] = product
+	for term, weight := range productTerms(product) {
+		if s.terms[term] == nil {
+			s.terms[term] = make(map[string]int)
+		}
+		s.terms[term][product.ID] = weight
+	}
+}
+
+func (s *searchIndex) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(id)
+}
+
+func (s *searchIndex) removeLocked(id string) {
+	old, ok := s.products[id]
+	if !ok {
+		return
+	}
+	for term := range productTerms(old) {
+		delete(s.terms[term], id)
+		if len(s.terms[term]) == 0 {
+			delete(s.terms, term)
+		}
+	}
+	delete(s.products, id)
+}
+
+// search returns one page of products matching every query word, either
+// as a whole term or as a prefix of one, and every filter.
+func (s *searchIndex) search(q productQuery) (productPage, error) {
+	page := productPage{Products: []models.CatalogProduct{}}
+	if q.Limit <= 0 {
+		q.Limit = defaultPageSize
+	}
+	if q.Limit > maxPageSize {
+		q.Limit = maxPageSize
+	}
+	if q.Stock != "" && q.Stock != "in_stock" && q.Stock != "out_of_stock" {
+		return page, errInvalidQuery
+	}
+	var after *productCursor
+	if q.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return page, errInvalidQuery
+		}
+		after = &productCursor{}
+		if err := json.Unmarshal(data, after); err != nil {
+			return page, errInvalidQuery
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	scores := s.scoreLocked(tokenize(q.Text))
+	type hit struct {
+		product models.CatalogProduct
+		score   int
+	}
+	var hits []hit
+	for id, score := range scores {
+		product := s.products[id]
+		if !q.matches(product) {
+			continue
+		}
+		if after != nil && (score > after.Score || score == after.Score && id <= after.ID) {
+			continue
+		}
+		hits = append(hits, hit{product, score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].product.ID < hits[j].product.ID
+	})
+
+	if len(hits) > q.Limit {
+		last := hits[q.Limit-1]
+		data, _ := json.Marshal(productCursor{Score: last.score, ID: last.product.ID})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+		hits = hits[:q.Limit]
+	}
+	for _, h := range hits {
+		page.Products = append(page.Products, h.product)
+	}
+	return page, nil
+}
+
+// scoreLocked sums, per product, the best weight each query word matched
+// with. A prefix match counts half. Without words every product scores 0.
+func (s *searchIndex) scoreLocked(words []string) map[string]int {
+	scores := make(map[string]int)
+	if len(words) == 0 {
+		for id := range s.products {
+			scores[id] = 0
+		}
+		return scores
+	}
+
+	for i, word := range words {
+		best := make(map[string]int)
+		for term, postings := range s.terms {
+			if !strings.HasPrefix(term, word) {
+				continue
+			}
+			for id, weight := range postings {
+				if term != word {
+					weight /= 2
+					if weight == 0 {
+						weight = 1
+					}
+				}
+				if weight > best[id] {
+					best[id] = weight
+				}
+			}
+		}
+
+		if i == 0 {
+			scores = best
+			continue
+		}
+		for id := range scores {
+			if weight, ok := best[id]; ok {
+				scores[id] += weight
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+func (q productQuery) matches(product models.CatalogProduct) bool {
+	if q.Platform != "" {
+		if _, ok := product.PlatformIDs[q.Platform]; !ok {
+			return false
+		}
+	}
+	if q.Categories != nil && !inCategories(product, q.Categories) {
+		return false
+	}
+	if q.MinPrice != nil && product.Price < *q.MinPrice {
+		return false
+	}
+	if q.MaxPrice != nil && product.Price > *q.MaxPrice {
+		return false
+	}
+	switch q.Stock {
+	case "in_stock":
+		return product.Stock > 0
+	case "out_of_stock":
+		return product.Stock <= 0
+	}
+	return true
+}
+
+func inCategories(product models.CatalogProduct, categories []string) bool {
+	for _, id := range product.Categories {
+		if slices.Contains(categories, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// productTerms returns the weighted terms a product is found by, including
+// those of its variants. A whole SKU is a term as well as its parts, so
+// "sku-001" matches exactly.
+func productTerms(product models.CatalogProduct) map[string]int {
+	terms := make(map[string]int)
+	add := func(text string, weight int) {
+		for _, term := range tokenize(text) {
+			if weight > terms[term] {
+				terms[term] = weight
+			}
+		}
+	}
+	add(product.Description, descriptionWeight)
+	add(product.Name, nameWeight)
+	skus := []string{product.SKU}
+	for _, variant := range product.Variants {
+		add(variant.Title, nameWeight)
+		skus = append(skus, variant.SKU)
+	}
+	for _, sku := range skus {
+		add(sku, skuWeight)
+		if sku = strings.ToLower(sku); sku != "" {
+			terms[sku] = skuWeight
+		}
+	}
+	return terms
+}
+
+// tokenize lowercases text and splits it into words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
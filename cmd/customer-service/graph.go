@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/customer"
+	"ecommerce-platform/internal/state"
+)
+
+var (
+	errNotFound  = errors.New("customer not found")
+	errNotMerged = errors.New("customer is not merged into target")
+)
+
+// graph links identifiers to customers. Each identifier belongs to exactly
+// one customer; customers sharing an identifier are merged into the oldest
+// one unless they were explicitly split before.
+type graph struct {
+	mu          sync.Mutex
+	customers   *state.Store
+	identifiers *state.Store
+}
+
+func (g *graph) resolve(identifiers []customer.Identifier) (customer.ResolveResponse, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var resp customer.ResolveResponse
+	now := time.Now()
+
+	var roots []customer.Customer
+	seen := make(map[string]bool)
+	var unmapped []customer.Identifier
+	for _, identifier := range identifiers {
+		var owner string
+		found, err := g.identifiers.Get(identifier.Key(), &owner)
+		if err != nil {
+			return resp, err
+		}
+		if !found {
+			unmapped = append(unmapped, identifier)
+			continue
+		}
+		root, err := g.rootLocked(owner)
+		if err != nil {
+			return resp, err
+		}
+		if !seen[root.ID] {
+			seen[root.ID] = true
+			roots = append(roots, root)
+		}
+	}
+
+	var target customer.Customer
+	if len(roots) == 0 {
+		id, err := newCustomerID()
+		if err != nil {
+			return resp, err
+		}
+		target = customer.Customer{ID: id, CreatedAt: now}
+		resp.Created = true
+	} else {
+		sort.Slice(roots, func(i, j int) bool {
+			return roots[i].CreatedAt.Before(roots[j].CreatedAt)
+		})
+		target = roots[0]
+		for _, other := range roots[1:] {
+			if slices.Contains(target.Split, other.ID) {
+				continue
+			}
+			if err := g.mergeLocked(&target, other, now); err != nil {
+				return resp, err
+			}
+			resp.Merged = append(resp.Merged, other.ID)
+		}
+	}
+
+	for _, identifier := range unmapped {
+		if err := g.identifiers.Put(identifier.Key(), target.ID); err != nil {
+			return resp, err
+		}
+		target.Identifiers = append(target.Identifiers, identifier)
+	}
+	target.UpdatedAt = now
+	if err := g.customers.Put(target.ID, target); err != nil {
+		return resp, err
+	}
+
+	resp.CustomerID = target.ID
+	return resp, nil
+}
+
+func (g *graph) lookup(identifier customer.Identifier) (customer.Customer, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var owner string
+	found, err := g.identifiers.Get(identifier.Key(), &owner)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	if !found {
+		return customer.Customer{}, errNotFound
+	}
+	root, err := g.rootLocked(owner)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	return g.profileLocked(root)
+}
+
+func (g *graph) profile(id string) (customer.Customer, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	root, err := g.rootLocked(id)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	return g.profileLocked(root)
+}
+
+// merge folds source into target. Both are resolved to their roots first.
+func (g *graph) merge(targetID, sourceID string) (customer.Customer, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	target, err := g.rootLocked(targetID)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	source, err := g.rootLocked(sourceID)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	if target.ID == source.ID {
+		return g.profileLocked(target)
+	}
+
+	now := time.Now()
+	target.Split = remove(target.Split, source.ID)
+	source.Split = remove(source.Split, target.ID)
+	if err := g.mergeLocked(&target, source, now); err != nil {
+		return customer.Customer{}, err
+	}
+	target.UpdatedAt = now
+	if err := g.customers.Put(target.ID, target); err != nil {
+		return customer.Customer{}, err
+	}
+	return g.profileLocked(target)
+}
+
+// unmerge detaches source from target and records the split so later
+// webhooks sharing an identifier do not merge them again.
+func (g *graph) unmerge(targetID, sourceID string) (customer.Customer, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	target, err := g.getLocked(targetID)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	source, err := g.getLocked(sourceID)
+	if err != nil {
+		return customer.Customer{}, err
+	}
+	if source.MergedInto != target.ID {
+		return customer.Customer{}, errNotMerged
+	}
+
+	now := time.Now()
+	source.MergedInto = ""
+	source.Split = append(remove(source.Split, target.ID), target.ID)
+	source.UpdatedAt = now
+	target.Merged = remove(target.Merged, source.ID)
+	target.Split = append(remove(target.Split, source.ID), source.ID)
+	target.UpdatedAt = now
+
+	if err := g.customers.Put(source.ID, source); err != nil {
+		return customer.Customer{}, err
+	}
+	if err := g.customers.Put(target.ID, target); err != nil {
+		return customer.Customer{}, err
+	}
+	return g.profileLocked(target)
+}
+
+func (g *graph) mergeLocked(target *customer.Customer, source customer.Customer, now time.Time) error {
+	source.MergedInto = target.ID
+	source.UpdatedAt = now
+	target.Merged = append(target.Merged, source.ID)
+	return g.customers.Put(source.ID, source)
+}
+
+func (g *graph) getLocked(id string) (customer.Customer, error) {
+	var c customer.Customer
+	found, err := g.customers.Get(id, &c)
+	if err != nil {
+		return c, err
+	}
+	if !found {
+		return c, errNotFound
+	}
+	return c, nil
+}
+
+func (g *graph) rootLocked(id string) (customer.Customer, error) {
+	c, err := g.getLocked(id)
+	for err == nil && c.MergedInto != "" {
+		c, err = g.getLocked(c.MergedInto)
+	}
+	return c, err
+}
+
+// profileLocked returns the customer with the identifiers of every customer
+// merged into it.
+func (g *graph) profileLocked(root customer.Customer) (customer.Customer, error) {
+	profile := root
+	profile.Identifiers = append([]customer.Identifier(nil), root.Identifiers...)
+
+	queue := append([]string(nil), root.Merged...)
+	for len(queue) > 0 {
+		child, err := g.getLocked(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			return profile, err
+		}
+		profile.Identifiers = append(profile.Identifiers, child.Identifiers...)
+		queue = append(queue, child.Merged...)
+	}
+	return profile, nil
+}
+
+func newCustomerID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "cus_" + hex.EncodeToString(b), nil
+}
+
+func remove(list []string, value string) []string {
+	kept := list[:0]
+	for _, entry := range list {
+		if entry != value {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/auth"
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/customer"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/state"
+)
+
+func main() {
+	cfg := config.Load()
+	cfg.ServiceName = "customer-service"
+
+	db, err := state.Open(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open state store: %v", cfg.ServiceName, err)
+	}
+	defer db.Close()
+
+	customers, err := db.Store("customers")
+	if err != nil {
+		log.Fatalf("[%s] Failed to open customers: %v", cfg.ServiceName, err)
+	}
+	identifiers, err := db.Store("identifiers")
+	if err != nil {
+		log.Fatalf("[%s] Failed to open identifiers: %v", cfg.ServiceName, err)
+	}
+	g := &graph{customers: customers, identifiers: identifiers}
+
+	clients, err := auth.ParseClients(cfg.CustomerClients)
+	if err != nil {
+		log.Fatalf("[%s] Invalid CUSTOMER_CLIENTS: %v", cfg.ServiceName, err)
+	}
+
+	var tokenizer pii.Tokenizer
+	if cfg.VaultURL != "" {
+		tokenizer = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/customers/resolve", clients.RequireGrant("resolve", resolveCustomer(g))).Methods("POST")
+	router.HandleFunc("/customers/lookup", lookupCustomer(g, tokenizer)).Methods("GET")
+	router.HandleFunc("/customers/{id}", getCustomer(g)).Methods("GET")
+	router.HandleFunc("/customers/{id}/merge", clients.RequireGrant("merge", mergeCustomer(g))).Methods("POST")
+	router.HandleFunc("/customers/{id}/unmerge", clients.RequireGrant("merge", unmergeCustomer(g))).Methods("POST")
+	router.HandleFunc("/health", healthCheck).Methods("GET")
+
+	server := &http.Server{
+		Addr:    ":" + cfg.HTTPPort,
+		Handler: router,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go func() {
+		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("[%s] Shutting down...", cfg.ServiceName)
+	server.Shutdown(context.Background())
+}
+
+func resolveCustomer(g *graph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req customer.ResolveRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Identifiers) == 0 {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := g.resolve(req.Identifiers)
+		if err != nil {
+			log.Printf("[customer-service] Resolve failed: %v", err)
+			http.Error(w, "Failed to resolve customer", http.StatusInternalServerError)
+			return
+		}
+		if len(resp.Merged) > 0 {
+			log.Printf("[customer-service] Merged %v into %s", resp.Merged, resp.CustomerID)
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// lookupCustomer finds a customer by one identifier: email, phone, or
+// platform and platform_id. Raw emails and phones are tokenized first.
+func lookupCustomer(g *graph, tokenizer pii.Tokenizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var identifier customer.Identifier
+		switch {
+		case query.Get("email") != "":
+			identifier = customer.Identifier{Type: "email", Value: query.Get("email")}
+		case query.Get("phone") != "":
+			identifier = customer.Identifier{Type: "phone", Value: query.Get("phone")}
+		case query.Get("platform") != "" && query.Get("platform_id") != "":
+			identifier = customer.Identifier{Type: query.Get("platform"), Value: query.Get("platform_id")}
+		default:
+			http.Error(w, "email, phone or platform and platform_id required", http.StatusBadRequest)
+			return
+		}
+
+		if (identifier.Type == "email" || identifier.Type == "phone") && !pii.IsToken(identifier.Value) && tokenizer != nil {
+			tokens, err := tokenizer.Tokenize(r.Context(), []pii.Value{{Kind: identifier.Type, Value: identifier.Value}})
+			if err != nil {
+				http.Error(w, "Failed to tokenize identifier", http.StatusServiceUnavailable)
+				return
+			}
+			identifier.Value = tokens[0]
+		}
+
+		c, err := g.lookup(identifier)
+		writeCustomer(w, c, err)
+	}
+}
+
+func getCustomer(g *graph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := g.profile(mux.Vars(r)["id"])
+		writeCustomer(w, c, err)
+	}
+}
+
+type mergeRequest struct {
+	SourceID string `json:"source_id"`
+}
+
+func mergeCustomer(g *graph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mergeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SourceID == "" {
+			http.Error(w, "source_id required", http.StatusBadRequest)
+			return
+		}
+
+		c, err := g.merge(mux.Vars(r)["id"], req.SourceID)
+		if err == nil {
+			log.Printf("[customer-service] Merged %s into %s", req.SourceID, c.ID)
+		}
+		writeCustomer(w, c, err)
+	}
+}
+
+func unmergeCustomer(g *graph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mergeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SourceID == "" {
+			http.Error(w, "source_id required", http.StatusBadRequest)
+			return
+		}
+
+		c, err := g.unmerge(mux.Vars(r)["id"], req.SourceID)
+		if err == nil {
+			log.Printf("[customer-service] Unmerged %s from %s", req.SourceID, c.ID)
+		}
+		writeCustomer(w, c, err)
+	}
+}
+
+func writeCustomer(w http.ResponseWriter, c customer.Customer, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		http.Error(w, "Customer not found", http.StatusNotFound)
+	case errors.Is(err, errNotMerged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		log.Printf("[customer-service] Request failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+}
@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"ecommerce-platform/internal/catalogsync"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/saga"
 )
 
 func main() {
@@ -24,8 +29,27 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var vault *pii.VaultClient
+	if cfg.VaultURL != "" && cfg.VaultClientKey != "" {
+		vault = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
 	log.Printf("[%s] Starting connector to NetSuite", cfg.ServiceName)
 
+	for _, topic := range []string{"refunds", "returns", "fulfillments", "shipments"} {
+		go consumeOrderEvents(ctx, cfg, topic)
+	}
+	go saga.Serve(ctx, cfg.KafkaBroker, "netsuite", "dragonfly-saga-group", func(ctx context.Context, cmd saga.Command) (string, error) {
+		return handleSagaCommand(ctx, vault, cmd)
+	})
+	go catalogsync.Serve(ctx, cfg.KafkaBroker, "netsuite", "dragonfly-catalog-group", syncToNetSuite)
+	go catalogsync.ServePrices(ctx, cfg.KafkaBroker, "netsuite", "dragonfly-prices-group", setPriceInNetSuite)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -47,7 +71,14 @@ func main() {
 				continue
 			}
 
-			if order.Platform == "netsuite" {
+			// Orders placed through the saga arrive as saga commands instead.
+			if order.RoutesTo("netsuite") && !steps.Drives(order, "netsuite") {
+				if vault != nil {
+					if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+						log.Printf("[%s] Failed to detokenize order %s: %v", cfg.ServiceName, order.ID, err)
+						continue
+					}
+				}
 				if err := sendToNetSuite(order); err != nil {
 					log.Printf("[%s] Failed to send to NetSuite: %v", cfg.ServiceName, err)
 					continue
@@ -59,8 +90,102 @@ func main() {
 }
 
 func sendToNetSuite(order models.Order) error {
-	log.Printf("[dragonfly] Sending order to NetSuite API: %+v", order)
+	log.Printf("[dragonfly] Sending order %s to NetSuite API: %d items, total %.2f", order.ID, len(order.Items), order.Total)
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
 
+// handleSagaCommand runs one step of an order placement saga against
+// NetSuite. Status updates resend the order like a regular update.
+func handleSagaCommand(ctx context.Context, vault *pii.VaultClient, cmd saga.Command) (string, error) {
+	order := cmd.Order
+	if vault != nil {
+		if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch cmd.Action {
+	case "place", "update_status":
+		err = sendToNetSuite(order)
+	case "cancel":
+		err = cancelInNetSuite(order)
+	default:
+		err = fmt.Errorf("unsupported action %s", cmd.Action)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[dragonfly] Ran saga step %s for order %s in NetSuite", cmd.Action, order.ID)
+	return order.ID, nil
+}
+
+func cancelInNetSuite(order models.Order) error {
+	log.Printf("[dragonfly] Cancelling order %s in NetSuite API", order.ID)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// syncToNetSuite updates the changed fields of the item record in
+// NetSuite, with variants as matrix child items.
+func syncToNetSuite(ctx context.Context, product models.CatalogProduct, changes models.ProductSource) error {
+	log.Printf("[dragonfly] Updating product %s in NetSuite API: %s", changes.ID, strings.Join(catalogsync.Fields(changes), ", "))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// setPriceInNetSuite sets the price level of an item for the currency in
+// NetSuite, or removes it when the change has none.
+func setPriceInNetSuite(ctx context.Context, change catalogsync.PriceChange) error {
+	if change.Price == nil {
+		log.Printf("[dragonfly] Restoring price of %s in NetSuite API for %s", change.SKU, change.Key())
+	} else {
+		log.Printf("[dragonfly] Setting price of %s to %.2f %s in NetSuite API for %s", change.SKU, *change.Price, change.Currency, change.Key())
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// consumeOrderEvents forwards refunds, returns, fulfillments and shipments of
+// orders routed to NetSuite from their dedicated topic.
+func consumeOrderEvents(ctx context.Context, cfg config.Config, topic string) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, "dragonfly-"+topic+"-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ref models.OrderEventRef
+			if err := json.Unmarshal(msg.Value, &ref); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			if ref.RoutesTo("netsuite") {
+				if err := sendEventToNetSuite(topic, ref, msg.Value); err != nil {
+					log.Printf("[%s] Failed to send %s %s to NetSuite: %v", cfg.ServiceName, topic, ref.ID, err)
+					continue
+				}
+				log.Printf("[%s] Sent %s %s for order %s to NetSuite", cfg.ServiceName, topic, ref.ID, ref.OrderID)
+			}
+		}
+	}
+}
+
+func sendEventToNetSuite(topic string, ref models.OrderEventRef, body []byte) error {
+	log.Printf("[dragonfly] Sending %s %s to NetSuite API (%d bytes)", topic, ref.ID, len(body))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
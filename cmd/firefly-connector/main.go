@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -12,6 +13,8 @@ import (
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/saga"
 )
 
 func main() {
@@ -24,8 +27,25 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var vault *pii.VaultClient
+	if cfg.VaultURL != "" && cfg.VaultClientKey != "" {
+		vault = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
 	log.Printf("[%s] Starting connector to Core (MSI)", cfg.ServiceName)
 
+	for _, topic := range []string{"refunds", "returns", "fulfillments", "shipments"} {
+		go consumeOrderEvents(ctx, cfg, topic)
+	}
+	go saga.Serve(ctx, cfg.KafkaBroker, "msi", "firefly-saga-group", func(ctx context.Context, cmd saga.Command) (string, error) {
+		return handleSagaCommand(ctx, vault, cmd)
+	})
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -47,7 +67,14 @@ func main() {
 				continue
 			}
 
-			if order.Platform == "msi" || shouldRouteToMSI(order) {
+			// Orders placed through the saga arrive as saga commands instead.
+			if order.RoutesTo("msi") && !steps.Drives(order, "msi") {
+				if vault != nil {
+					if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+						log.Printf("[%s] Failed to detokenize order %s: %v", cfg.ServiceName, order.ID, err)
+						continue
+					}
+				}
 				if err := sendToMSI(order); err != nil {
 					log.Printf("[%s] Failed to send to MSI: %v", cfg.ServiceName, err)
 					continue
@@ -58,14 +85,84 @@ func main() {
 	}
 }
 
-func shouldRouteToMSI(order models.Order) bool {
-	// Routing logic for MSI
-	return false
-}
-
 func sendToMSI(order models.Order) error {
 	// Simulate API call to MSI
-	log.Printf("[firefly] Sending order to MSI API: %+v", order)
+	log.Printf("[firefly] Sending order %s to MSI API: %d items, total %.2f", order.ID, len(order.Items), order.Total)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// handleSagaCommand runs one step of an order placement saga against
+// MSI. Status updates resend the order like a regular update.
+func handleSagaCommand(ctx context.Context, vault *pii.VaultClient, cmd saga.Command) (string, error) {
+	order := cmd.Order
+	if vault != nil {
+		if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch cmd.Action {
+	case "place", "update_status":
+		err = sendToMSI(order)
+	case "cancel":
+		err = cancelInMSI(order)
+	default:
+		err = fmt.Errorf("unsupported action %s", cmd.Action)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[firefly] Ran saga step %s for order %s in MSI", cmd.Action, order.ID)
+	return order.ID, nil
+}
+
+func cancelInMSI(order models.Order) error {
+	log.Printf("[firefly] Cancelling order %s in MSI API", order.ID)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// consumeOrderEvents forwards refunds, returns, fulfillments and shipments of
+// orders routed to MSI from their dedicated topic.
+func consumeOrderEvents(ctx context.Context, cfg config.Config, topic string) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, "firefly-"+topic+"-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ref models.OrderEventRef
+			if err := json.Unmarshal(msg.Value, &ref); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			if ref.RoutesTo("msi") {
+				if err := sendEventToMSI(topic, ref, msg.Value); err != nil {
+					log.Printf("[%s] Failed to send %s %s to MSI: %v", cfg.ServiceName, topic, ref.ID, err)
+					continue
+				}
+				log.Printf("[%s] Sent %s %s for order %s to MSI", cfg.ServiceName, topic, ref.ID, ref.OrderID)
+			}
+		}
+	}
+}
+
+func sendEventToMSI(topic string, ref models.OrderEventRef, body []byte) error {
+	log.Printf("[firefly] Sending %s %s to MSI API (%d bytes)", topic, ref.ID, len(body))
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"ecommerce-platform/internal/catalogsync"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/saga"
 )
 
 func main() {
@@ -24,8 +29,27 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var vault *pii.VaultClient
+	if cfg.VaultURL != "" && cfg.VaultClientKey != "" {
+		vault = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
 	log.Printf("[%s] Starting connector to Shopify", cfg.ServiceName)
 
+	for _, topic := range []string{"refunds", "returns", "fulfillments", "shipments"} {
+		go consumeOrderEvents(ctx, cfg, topic)
+	}
+	go saga.Serve(ctx, cfg.KafkaBroker, "shopify", "hermes-saga-group", func(ctx context.Context, cmd saga.Command) (string, error) {
+		return handleSagaCommand(ctx, vault, cmd)
+	})
+	go catalogsync.Serve(ctx, cfg.KafkaBroker, "shopify", "hermes-catalog-group", syncToShopify)
+	go catalogsync.ServePrices(ctx, cfg.KafkaBroker, "shopify", "hermes-prices-group", setPriceInShopify)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -47,7 +71,14 @@ func main() {
 				continue
 			}
 
-			if order.Platform == "shopify" {
+			// Orders placed through the saga arrive as saga commands instead.
+			if order.RoutesTo("shopify") && !steps.Drives(order, "shopify") {
+				if vault != nil {
+					if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+						log.Printf("[%s] Failed to detokenize order %s: %v", cfg.ServiceName, order.ID, err)
+						continue
+					}
+				}
 				if err := sendToShopify(order); err != nil {
 					log.Printf("[%s] Failed to send to Shopify: %v", cfg.ServiceName, err)
 					continue
@@ -59,7 +90,102 @@ func main() {
 }
 
 func sendToShopify(order models.Order) error {
-	log.Printf("[hermes] Sending order to Shopify API: %+v", order)
+	log.Printf("[hermes] Sending order %s to Shopify API: %d items, total %.2f", order.ID, len(order.Items), order.Total)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// handleSagaCommand runs one step of an order placement saga against
+// Shopify. Status updates resend the order like a regular update.
+func handleSagaCommand(ctx context.Context, vault *pii.VaultClient, cmd saga.Command) (string, error) {
+	order := cmd.Order
+	if vault != nil {
+		if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch cmd.Action {
+	case "place", "update_status":
+		err = sendToShopify(order)
+	case "cancel":
+		err = cancelInShopify(order)
+	default:
+		err = fmt.Errorf("unsupported action %s", cmd.Action)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[hermes] Ran saga step %s for order %s in Shopify", cmd.Action, order.ID)
+	return order.ID, nil
+}
+
+func cancelInShopify(order models.Order) error {
+	log.Printf("[hermes] Cancelling order %s in Shopify API", order.ID)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// syncToShopify updates the changed fields of a product in Shopify. Prices
+// and stock of a configurable product are set on its variants.
+func syncToShopify(ctx context.Context, product models.CatalogProduct, changes models.ProductSource) error {
+	log.Printf("[hermes] Updating product %s in Shopify API: %s", changes.ID, strings.Join(catalogsync.Fields(changes), ", "))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// setPriceInShopify sets the price of a product or variant for a market
+// and customer group in Shopify, or removes it when the change has none.
+func setPriceInShopify(ctx context.Context, change catalogsync.PriceChange) error {
+	if change.Price == nil {
+		log.Printf("[hermes] Restoring price of %s in Shopify API for %s", change.SKU, change.Key())
+	} else {
+		log.Printf("[hermes] Setting price of %s to %.2f %s in Shopify API for %s", change.SKU, *change.Price, change.Currency, change.Key())
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// consumeOrderEvents forwards refunds, returns, fulfillments and shipments of
+// orders routed to Shopify from their dedicated topic.
+func consumeOrderEvents(ctx context.Context, cfg config.Config, topic string) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, "hermes-"+topic+"-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ref models.OrderEventRef
+			if err := json.Unmarshal(msg.Value, &ref); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			if ref.RoutesTo("shopify") {
+				if err := sendEventToShopify(topic, ref, msg.Value); err != nil {
+					log.Printf("[%s] Failed to send %s %s to Shopify: %v", cfg.ServiceName, topic, ref.ID, err)
+					continue
+				}
+				log.Printf("[%s] Sent %s %s for order %s to Shopify", cfg.ServiceName, topic, ref.ID, ref.OrderID)
+			}
+		}
+	}
+}
+
+func sendEventToShopify(topic string, ref models.OrderEventRef, body []byte) error {
+	log.Printf("[hermes] Sending %s %s to Shopify API (%d bytes)", topic, ref.ID, len(body))
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+)
+
+// bundleIndex is the components of every bundle in the compacted catalog
+// topic, by bundle SKU. Bundles have no stock of their own; ordering one
+// reserves and ships its components.
+type bundleIndex struct {
+	mu         sync.RWMutex
+	skus       map[string]string
+	components map[string][]models.BundleComponent
+}
+
+func newBundleIndex() *bundleIndex {
+	return &bundleIndex{skus: make(map[string]string), components: make(map[string][]models.BundleComponent)}
+}
+
+// expand returns the SKUs and quantities one unit of sku is made of: its
+// components when it is a bundle, otherwise sku itself.
+func (b *bundleIndex) expand(sku string) []models.BundleComponent {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if components, ok := b.components[sku]; ok {
+		return components
+	}
+	return []models.BundleComponent{{SKU: sku, Quantity: 1}}
+}
+
+func (b *bundleIndex) put(key string, product models.CatalogProduct) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(key)
+
+	var components []models.BundleComponent
+	for _, component := range product.Components {
+		if sku := models.CanonicalSKU(component.SKU); sku != "" && component.Quantity > 0 {
+			components = append(components, models.BundleComponent{SKU: sku, Quantity: component.Quantity})
+		}
+	}
+	if product.Type != models.ProductBundle || product.SKU == "" || len(components) == 0 {
+		return
+	}
+	b.skus[key] = product.SKU
+	b.components[product.SKU] = components
+}
+
+func (b *bundleIndex) remove(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(key)
+}
+
+func (b *bundleIndex) removeLocked(key string) {
+	if sku, ok := b.skus[key]; ok {
+		delete(b.components, sku)
+		delete(b.skus, key)
+	}
+}
+
+func (b *bundleIndex) apply(key, value []byte) {
+	if len(value) == 0 || string(value) == "null" {
+		b.remove(string(key))
+		return
+	}
+	var product models.CatalogProduct
+	if err := json.Unmarshal(value, &product); err != nil {
+		log.Printf("[inventory-service] Catalog unmarshal error: %v", err)
+		return
+	}
+	b.put(string(key), product)
+}
+
+// materializeBundles keeps the index up to date with the catalog topic.
+func materializeBundles(ctx context.Context, consumer *kafka.Consumer, index *bundleIndex) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			index.apply(msg.Key, msg.Value)
+		}
+	}
+}
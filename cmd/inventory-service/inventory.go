@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+var (
+	errNotFound          = errors.New("not found")
+	errInvalidAdjustment = errors.New("invalid adjustment")
+)
+
+const (
+	reservationOpen      = "open"
+	reservationReleased  = "released"
+	reservationFulfilled = "fulfilled"
+)
+
+// reservation holds stock for the unfulfilled lines of one order. It is
+// released when the order is cancelled or refunded, or when the order is
+// split or merged, as its child orders then reserve the stock themselves.
+type reservation struct {
+	OrderID      string            `json:"order_id"`
+	LocationID   string            `json:"location_id,omitempty"`
+	Status       string            `json:"status"`
+	Lines        []reservationLine `json:"lines"`
+	Fulfillments []string          `json:"fulfillments,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// reservationLine reserves the SKU of an order line item or, when the item
+// is a bundle, one of the bundle's components, PerBundle units for every
+// bundle ordered.
+type reservationLine struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	SKU       string `json:"sku"`
+	Bundle    string `json:"bundle,omitempty"`
+	PerBundle int    `json:"per_bundle,omitempty"`
+	Quantity  int    `json:"quantity"`
+	Fulfilled int    `json:"fulfilled"`
+}
+
+func (l reservationLine) outstanding() int {
+	return l.Quantity - l.Fulfilled
+}
+
+// units is how many units of the line's SKU quantity items of the order
+// line take.
+func (l reservationLine) units(quantity int) int {
+	if l.Bundle == "" {
+		return quantity
+	}
+	return quantity * l.PerBundle
+}
+
+// updateStatus marks an open reservation fulfilled once no line is
+// outstanding.
+func (r *reservation) updateStatus() {
+	if r.Status != reservationOpen {
+		return
+	}
+	for _, line := range r.Lines {
+		if line.outstanding() > 0 {
+			return
+		}
+	}
+	r.Status = reservationFulfilled
+}
+
+// inventory keeps the stock of every SKU per location. Levels are kept in
+// a store logged to the compacted inventory topic, so the topic both
+// publishes available-to-sell and restores the store. Every change of
+// on-hand stock is appended to the ledger first. The ledger, reservations
+// and applied fulfillments are logged to topics of their own, so that
+// restored levels never hold reservations that nothing can release.
+type inventory struct {
+	mu              sync.Mutex
+	levels          *state.Store
+	ledger          *state.Store
+	reservations    *state.Store
+	fulfillments    *state.Store
+	bundles         *bundleIndex
+	defaultLocation string
+	lastEntry       int64
+}
+
+func newInventory(levels, ledger, reservations, fulfillments *state.Store, bundles *bundleIndex, defaultLocation string) (*inventory, error) {
+	inv := &inventory{
+		levels:          levels,
+		ledger:          ledger,
+		reservations:    reservations,
+		fulfillments:    fulfillments,
+		bundles:         bundles,
+		defaultLocation: defaultLocation,
+	}
+	err := ledger.ForEach(func(key string, value []byte) error {
+		var entry models.InventoryAdjustment
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		if seq := entry.CreatedAt.UnixNano(); seq > inv.lastEntry {
+			inv.lastEntry = seq
+		}
+		return nil
+	})
+	return inv, err
+}
+
+// adjust changes the on-hand stock of sku at location by delta.
+func (inv *inventory) adjust(sku, location string, delta int, reason, reference string) (models.InventoryLevel, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if sku = models.CanonicalSKU(sku); sku == "" || delta == 0 {
+		return models.InventoryLevel{}, errInvalidAdjustment
+	}
+	level, err := inv.levelLocked(sku)
+	if err != nil {
+		return level, err
+	}
+	if err := inv.applyLocked(&level, location, delta, reason, reference); err != nil {
+		return level, err
+	}
+	return level, inv.saveLocked(&level)
+}
+
+// count sets the on-hand stock of sku at location, recording the
+// difference to the previous stock in the ledger.
+func (inv *inventory) count(sku, location string, onHand int, reason, reference string) (models.InventoryLevel, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if sku = models.CanonicalSKU(sku); sku == "" || location == "" || onHand < 0 {
+		return models.InventoryLevel{}, errInvalidAdjustment
+	}
+	level, err := inv.levelLocked(sku)
+	if err != nil {
+		return level, err
+	}
+	delta := onHand - level.Locations[location].OnHand
+	if delta == 0 {
+		return level, nil
+	}
+	if err := inv.applyLocked(&level, location, delta, reason, reference); err != nil {
+		return level, err
+	}
+	return level, inv.saveLocked(&level)
+}
+
+// applyLocked appends a ledger entry and applies it to level.
+func (inv *inventory) applyLocked(level *models.InventoryLevel, location string, delta int, reason, reference string) error {
+	if location == "" {
+		location = inv.defaultLocation
+	}
+	stock := level.Locations[location]
+	stock.OnHand += delta
+
+	now := time.Now()
+	seq := now.UnixNano()
+	if seq <= inv.lastEntry {
+		seq = inv.lastEntry + 1
+	}
+	inv.lastEntry = seq
+	entry := models.InventoryAdjustment{
+		ID:         fmt.Sprintf("%020d", seq),
+		SKU:        level.SKU,
+		LocationID: location,
+		Delta:      delta,
+		OnHand:     stock.OnHand,
+		Reason:     reason,
+		Reference:  reference,
+		CreatedAt:  time.Unix(0, seq),
+	}
+	if err := inv.ledger.Put(entry.ID, entry); err != nil {
+		return err
+	}
+
+	level.Locations[location] = stock
+	return nil
+}
+
+// reserveLocked changes the reserved stock of sku by quantity, counting it
+// at location when the order has one.
+func (inv *inventory) reserveLocked(sku, location string, quantity int) error {
+	level, err := inv.levelLocked(sku)
+	if err != nil {
+		return err
+	}
+	level.Reserved += quantity
+	if location != "" {
+		stock := level.Locations[location]
+		stock.Reserved += quantity
+		level.Locations[location] = stock
+	}
+	return inv.saveLocked(&level)
+}
+
+func (inv *inventory) levelLocked(sku string) (models.InventoryLevel, error) {
+	var level models.InventoryLevel
+	found, err := inv.levels.Get(sku, &level)
+	if err != nil {
+		return level, err
+	}
+	if !found {
+		level = models.InventoryLevel{SKU: sku}
+	}
+	if level.Locations == nil {
+		level.Locations = make(map[string]models.LocationStock)
+	}
+	return level, nil
+}
+
+// saveLocked recomputes the totals and available-to-sell of level and
+// stores it, which publishes it on the inventory topic. Available never
+// drops below zero, even when more is reserved than is on hand.
+func (inv *inventory) saveLocked(level *models.InventoryLevel) error {
+	level.OnHand = 0
+	for location, stock := range level.Locations {
+		stock.Available = max(stock.OnHand-stock.Reserved, 0)
+		level.Locations[location] = stock
+		level.OnHand += stock.OnHand
+	}
+	level.Available = max(level.OnHand-level.Reserved, 0)
+	level.UpdatedAt = time.Now()
+	return inv.levels.Put(level.SKU, level)
+}
+
+// handleOrder reserves stock for a new order and releases it once the
+// order no longer needs it. Bundles reserve their components. Lines
+// without a SKU cannot be reserved.
+func (inv *inventory) handleOrder(order models.Order) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	var res reservation
+	found, err := inv.reservations.Get(order.ID, &res)
+	if err != nil {
+		return err
+	}
+	done := order.Status == "cancelled" || order.Status == "refunded" || len(order.ChildIDs) > 0
+
+	if !found {
+		if done {
+			return nil
+		}
+		res = reservation{OrderID: order.ID, LocationID: order.LocationID, Status: reservationOpen, CreatedAt: time.Now()}
+		for _, item := range order.Items {
+			sku := models.CanonicalSKU(item.SKU)
+			if sku == "" {
+				log.Printf("[inventory-service] Order %s: item %s has no SKU, not reserved", order.ID, item.Key())
+				continue
+			}
+			for _, part := range inv.bundles.expand(sku) {
+				line := reservationLine{ProductID: item.ProductID, VariantID: item.VariantID, SKU: part.SKU}
+				if part.SKU != sku {
+					line.Bundle = sku
+					line.PerBundle = part.Quantity
+				}
+				line.Quantity = line.units(item.Quantity)
+				line.Fulfilled = line.units(min(item.Fulfilled, item.Quantity))
+				if err := inv.reserveLocked(line.SKU, res.LocationID, line.outstanding()); err != nil {
+					return err
+				}
+				res.Lines = append(res.Lines, line)
+			}
+		}
+		if len(res.Lines) == 0 {
+			return nil
+		}
+		res.updateStatus()
+		log.Printf("[inventory-service] Reserved stock for order %s", order.ID)
+		return inv.saveReservationLocked(&res)
+	}
+
+	if res.Status != reservationOpen {
+		return nil
+	}
+	if done {
+		log.Printf("[inventory-service] Releasing stock of order %s (%s)", order.ID, order.Status)
+		return inv.releaseLocked(&res)
+	}
+	if order.LocationID != res.LocationID {
+		if err := inv.relocateLocked(&res, order.LocationID); err != nil {
+			return err
+		}
+	}
+	return inv.syncFulfilledLocked(&res, order.Items)
+}
+
+// syncFulfilledLocked releases the reservation of units the order reports
+// as fulfilled, in case the order update arrives before the fulfillment.
+// Stock on hand only changes with the fulfillment itself.
+func (inv *inventory) syncFulfilledLocked(res *reservation, items []models.Item) error {
+	changed := false
+	for _, item := range items {
+		for i := range res.Lines {
+			line := &res.Lines[i]
+			if line.ProductID != item.ProductID || line.VariantID != item.VariantID {
+				continue
+			}
+			n := min(line.units(item.Fulfilled), line.Quantity) - line.Fulfilled
+			if n <= 0 {
+				continue
+			}
+			line.Fulfilled += n
+			changed = true
+			if err := inv.reserveLocked(line.SKU, res.LocationID, -n); err != nil {
+				return err
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	res.updateStatus()
+	return inv.saveReservationLocked(res)
+}
+
+// releaseLocked gives back the stock still reserved for the order.
+func (inv *inventory) releaseLocked(res *reservation) error {
+	for _, line := range res.Lines {
+		if err := inv.reserveLocked(line.SKU, res.LocationID, -line.outstanding()); err != nil {
+			return err
+		}
+	}
+	res.Status = reservationReleased
+	return inv.saveReservationLocked(res)
+}
+
+// relocateLocked moves the outstanding reservation to the location the
+// order was assigned to.
+func (inv *inventory) relocateLocked(res *reservation, location string) error {
+	for _, line := range res.Lines {
+		if err := inv.reserveLocked(line.SKU, res.LocationID, -line.outstanding()); err != nil {
+			return err
+		}
+		if err := inv.reserveLocked(line.SKU, location, line.outstanding()); err != nil {
+			return err
+		}
+	}
+	res.LocationID = location
+	return inv.saveReservationLocked(res)
+}
+
+// handleFulfillment takes shipped units off the stock of the location
+// they left from and releases their reservation. A fulfillment without
+// items covers every open line of the order. Each fulfillment is applied
+// once, whether or not its order has a reservation.
+func (inv *inventory) handleFulfillment(fulfillment models.Fulfillment) error {
+	if fulfillment.Status == "cancelled" || fulfillment.Status == "failure" {
+		return nil
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	key := fulfillment.OrderID + "/" + fulfillment.ID
+	var appliedAt time.Time
+	applied, err := inv.fulfillments.Get(key, &appliedAt)
+	if err != nil || applied {
+		return err
+	}
+
+	var res reservation
+	found, err := inv.reservations.Get(fulfillment.OrderID, &res)
+	if err != nil {
+		return err
+	}
+
+	location := fulfillment.LocationID
+	if location == "" {
+		location = res.LocationID
+	}
+
+	type shipped struct {
+		sku      string
+		quantity int
+	}
+	var lines []shipped
+	if len(fulfillment.Items) == 0 {
+		for i := range res.Lines {
+			lines = append(lines, shipped{res.Lines[i].SKU, res.Lines[i].outstanding()})
+		}
+	}
+	for _, item := range fulfillment.Items {
+		sku := models.CanonicalSKU(item.SKU)
+		matched := make(map[string]bool)
+		for _, line := range res.Lines {
+			if matched[line.SKU] {
+				continue
+			}
+			if item.ProductID != "" && line.ProductID == item.ProductID && (item.VariantID == "" || line.VariantID == item.VariantID) ||
+				sku != "" && (line.SKU == sku || line.Bundle == sku) {
+				matched[line.SKU] = true
+				lines = append(lines, shipped{line.SKU, line.units(item.Quantity)})
+			}
+		}
+		if len(matched) > 0 {
+			continue
+		}
+		if sku == "" {
+			log.Printf("[inventory-service] Fulfillment %s: item %s has no SKU, stock not changed", fulfillment.ID, item.Key())
+			continue
+		}
+		for _, part := range inv.bundles.expand(sku) {
+			lines = append(lines, shipped{part.SKU, item.Quantity * part.Quantity})
+		}
+	}
+
+	for _, line := range lines {
+		if line.quantity <= 0 {
+			continue
+		}
+		if err := inv.consumeLocked(&res, line.sku, line.quantity); err != nil {
+			return err
+		}
+		level, err := inv.levelLocked(line.sku)
+		if err != nil {
+			return err
+		}
+		if err := inv.applyLocked(&level, location, -line.quantity, models.AdjustmentFulfillment, fulfillment.ID); err != nil {
+			return err
+		}
+		if err := inv.saveLocked(&level); err != nil {
+			return err
+		}
+	}
+
+	if err := inv.fulfillments.Put(key, time.Now()); err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	res.Fulfillments = append(res.Fulfillments, fulfillment.ID)
+	res.updateStatus()
+	return inv.saveReservationLocked(&res)
+}
+
+// consumeLocked marks up to quantity units of the open lines for sku as
+// fulfilled and releases what they reserved.
+func (inv *inventory) consumeLocked(res *reservation, sku string, quantity int) error {
+	if res.Status != reservationOpen {
+		return nil
+	}
+	for i := range res.Lines {
+		line := &res.Lines[i]
+		if line.SKU != sku || quantity == 0 {
+			continue
+		}
+		n := min(line.outstanding(), quantity)
+		if n <= 0 {
+			continue
+		}
+		line.Fulfilled += n
+		quantity -= n
+		if err := inv.reserveLocked(sku, res.LocationID, -n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *inventory) saveReservationLocked(res *reservation) error {
+	res.UpdatedAt = time.Now()
+	return inv.reservations.Put(res.OrderID, res)
+}
+
+func (inv *inventory) level(sku string) (models.InventoryLevel, error) {
+	var level models.InventoryLevel
+	found, err := inv.levels.Get(models.CanonicalSKU(sku), &level)
+	if err == nil && !found {
+		err = errNotFound
+	}
+	return level, err
+}
+
+// list returns the levels of all SKUs, or of those stocked at location
+// when it is set.
+func (inv *inventory) list(location string) ([]models.InventoryLevel, error) {
+	levels := []models.InventoryLevel{}
+	err := inv.levels.ForEach(func(key string, value []byte) error {
+		var level models.InventoryLevel
+		if err := json.Unmarshal(value, &level); err != nil {
+			return err
+		}
+		if _, ok := level.Locations[location]; location == "" || ok {
+			levels = append(levels, level)
+		}
+		return nil
+	})
+	return levels, err
+}
+
+// history returns the ledger entries of sku, oldest first as entries are
+// keyed by time.
+func (inv *inventory) history(sku string) ([]models.InventoryAdjustment, error) {
+	sku = models.CanonicalSKU(sku)
+	entries := []models.InventoryAdjustment{}
+	err := inv.ledger.ForEach(func(key string, value []byte) error {
+		var entry models.InventoryAdjustment
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		if entry.SKU == sku {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (inv *inventory) reservation(orderID string) (reservation, error) {
+	var res reservation
+	found, err := inv.reservations.Get(orderID, &res)
+	if err == nil && !found {
+		err = errNotFound
+	}
+	return res, err
+}
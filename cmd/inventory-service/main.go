@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/auth"
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+// changelogTopics are the compacted topics the stores are logged to. The
+// inventory topic of the levels is also read by other services.
+var changelogTopics = map[string]string{
+	"levels":       "inventory",
+	"ledger":       "inventory-ledger",
+	"reservations": "inventory-reservations",
+	"fulfillments": "inventory-fulfillments",
+}
+
+func main() {
+	cfg := config.Load()
+	cfg.ServiceName = "inventory-service"
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	db, err := state.Open(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open state store: %v", cfg.ServiceName, err)
+	}
+	defer db.Close()
+
+	// Levels, the ledger, reservations and applied fulfillments are all
+	// logged and restored together, so that they agree after a restore.
+	stores := make(map[string]*state.Store)
+	for _, name := range []string{"levels", "ledger", "reservations", "fulfillments"} {
+		topic := changelogTopics[name]
+		if err := kafka.EnsureCompactedTopic(cfg.KafkaBroker, topic); err != nil {
+			log.Printf("[%s] Failed to ensure %s topic: %v", cfg.ServiceName, topic, err)
+		}
+		producer := kafka.NewCompactedProducer(cfg.KafkaBroker, topic)
+		defer producer.Close()
+
+		store, err := db.LoggedStore(name, producer)
+		if err != nil {
+			log.Fatalf("[%s] Failed to open %s: %v", cfg.ServiceName, name, err)
+		}
+		restored, err := store.Restore(ctx, kafka.TopicReader(cfg.KafkaBroker, topic))
+		if err != nil {
+			log.Fatalf("[%s] Failed to restore %s: %v", cfg.ServiceName, name, err)
+		}
+		log.Printf("[%s] Restored %d %s entries", cfg.ServiceName, restored, name)
+		stores[name] = store
+	}
+
+	// Bundles are loaded before any order is reserved, then kept current.
+	bundles := newBundleIndex()
+	err = kafka.ReadAll(ctx, cfg.KafkaBroker, "catalog", func(key, value []byte) error {
+		bundles.apply(key, value)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[%s] Failed to load bundles from catalog: %v", cfg.ServiceName, err)
+	}
+	catalogConsumer := kafka.NewTableConsumer(cfg.KafkaBroker, "catalog")
+	defer catalogConsumer.Close()
+	go materializeBundles(ctx, catalogConsumer, bundles)
+
+	inv, err := newInventory(stores["levels"], stores["ledger"], stores["reservations"], stores["fulfillments"], bundles, cfg.InventoryDefaultLocation)
+	if err != nil {
+		log.Fatalf("[%s] Failed to load inventory: %v", cfg.ServiceName, err)
+	}
+
+	clients, err := auth.ParseClients(cfg.InventoryClients)
+	if err != nil {
+		log.Fatalf("[%s] Invalid INVENTORY_CLIENTS: %v", cfg.ServiceName, err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/inventory", listLevels(inv)).Methods("GET")
+	router.HandleFunc("/inventory/{sku}", getLevel(inv)).Methods("GET")
+	router.HandleFunc("/inventory/{sku}/ledger", getLedger(inv)).Methods("GET")
+	router.HandleFunc("/inventory/{sku}/adjustments", clients.RequireGrant("adjust", adjustStock(inv))).Methods("POST")
+	router.HandleFunc("/inventory/{sku}/locations/{location_id}", clients.RequireGrant("count", countStock(inv))).Methods("PUT")
+	router.HandleFunc("/reservations/{order_id}", getReservation(inv)).Methods("GET")
+	router.HandleFunc("/health", healthCheck).Methods("GET")
+
+	server := &http.Server{
+		Addr:    ":" + cfg.HTTPPort,
+		Handler: router,
+	}
+
+	go func() {
+		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	go consumeOrders(ctx, cfg, inv)
+	go consumeFulfillments(ctx, cfg, inv)
+	go consumeSourceItems(ctx, cfg, inv)
+
+	<-ctx.Done()
+	log.Printf("[%s] Shutting down...", cfg.ServiceName)
+	server.Shutdown(context.Background())
+}
+
+// consume reads topic until ctx is done, passing every message to handle.
+func consume(ctx context.Context, cfg config.Config, topic, groupID string, handle func(value []byte)) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, groupID)
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			handle(msg.Value)
+		}
+	}
+}
+
+func consumeOrders(ctx context.Context, cfg config.Config, inv *inventory) {
+	consume(ctx, cfg, "orders", "inventory-service-group", func(value []byte) {
+		var order models.Order
+		if err := json.Unmarshal(value, &order); err != nil {
+			log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+			return
+		}
+		if err := inv.handleOrder(order); err != nil {
+			log.Printf("[%s] Failed to handle order %s: %v", cfg.ServiceName, order.ID, err)
+		}
+	})
+}
+
+func consumeFulfillments(ctx context.Context, cfg config.Config, inv *inventory) {
+	consume(ctx, cfg, "fulfillments", "inventory-service-fulfillments-group", func(value []byte) {
+		var fulfillment models.Fulfillment
+		if err := json.Unmarshal(value, &fulfillment); err != nil {
+			log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+			return
+		}
+		if err := inv.handleFulfillment(fulfillment); err != nil {
+			log.Printf("[%s] Failed to handle fulfillment %s: %v", cfg.ServiceName, fulfillment.ID, err)
+		}
+	})
+}
+
+// consumeSourceItems takes the on-hand stock per MSI source from
+// inventory.updated webhooks sent by MSI.
+func consumeSourceItems(ctx context.Context, cfg config.Config, inv *inventory) {
+	consume(ctx, cfg, cfg.KafkaTopic+"-enriched", "inventory-service-enriched-group", func(value []byte) {
+		var enriched models.EnrichedEvent
+		if err := json.Unmarshal(value, &enriched); err != nil {
+			log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+			return
+		}
+		if enriched.Platform != "msi" || enriched.EventType != "inventory.updated" {
+			return
+		}
+		for _, item := range sourceItems(enriched.Payload) {
+			if _, err := inv.count(item.SKU, item.SourceCode, item.Quantity, models.AdjustmentSourceSync, enriched.ID); err != nil {
+				log.Printf("[%s] Failed to sync %s at %s: %v", cfg.ServiceName, item.SKU, item.SourceCode, err)
+			}
+		}
+	})
+}
+
+// sourceItem is the stock of a SKU at one MSI source.
+type sourceItem struct {
+	SKU        string
+	SourceCode string
+	Quantity   int
+}
+
+// sourceItems reads the source items of an MSI payload, either a list
+// under source_items or a single item at the top level.
+func sourceItems(payload map[string]interface{}) []sourceItem {
+	entries := []interface{}{payload}
+	if list, ok := payload["source_items"].([]interface{}); ok {
+		entries = list
+	}
+
+	var items []sourceItem
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		sku, _ := fields["sku"].(string)
+		source, _ := fields["source_code"].(string)
+		quantity, ok := fields["quantity"].(float64)
+		if sku == "" || source == "" || !ok {
+			continue
+		}
+		items = append(items, sourceItem{SKU: sku, SourceCode: source, Quantity: int(quantity)})
+	}
+	return items
+}
+
+func listLevels(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		levels, err := inv.list(r.URL.Query().Get("location"))
+		if err != nil {
+			log.Printf("[inventory-service] List failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, levels)
+	}
+}
+
+func getLevel(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		level, err := inv.level(mux.Vars(r)["sku"])
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, "SKU not found", http.StatusNotFound)
+		case err != nil:
+			log.Printf("[inventory-service] Get failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, level)
+		}
+	}
+}
+
+func getLedger(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, err := inv.history(mux.Vars(r)["sku"])
+		if err != nil {
+			log.Printf("[inventory-service] Ledger failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// adjustStock records a manual change of stock, such as a delivery or a
+// write-off.
+func adjustStock(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			LocationID string `json:"location_id"`
+			Delta      int    `json:"delta"`
+			Reference  string `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		level, err := inv.adjust(mux.Vars(r)["sku"], req.LocationID, req.Delta, models.AdjustmentManual, req.Reference)
+		writeLevel(w, level, err)
+	}
+}
+
+// countStock sets the stock of a location from a stock count.
+func countStock(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			OnHand    *int   `json:"on_hand"`
+			Reference string `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OnHand == nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		vars := mux.Vars(r)
+		level, err := inv.count(vars["sku"], vars["location_id"], *req.OnHand, models.AdjustmentCount, req.Reference)
+		writeLevel(w, level, err)
+	}
+}
+
+func writeLevel(w http.ResponseWriter, level models.InventoryLevel, err error) {
+	switch {
+	case errors.Is(err, errInvalidAdjustment):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		log.Printf("[inventory-service] Adjustment failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, level)
+	}
+}
+
+func getReservation(inv *inventory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := inv.reservation(mux.Vars(r)["order_id"])
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, "Reservation not found", http.StatusNotFound)
+		case err != nil:
+			log.Printf("[inventory-service] Get failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, res)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+}
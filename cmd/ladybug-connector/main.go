@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"ecommerce-platform/internal/catalogsync"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/saga"
 )
 
 func main() {
@@ -24,8 +29,27 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var vault *pii.VaultClient
+	if cfg.VaultURL != "" && cfg.VaultClientKey != "" {
+		vault = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
 	log.Printf("[%s] Starting connector to Magento", cfg.ServiceName)
 
+	for _, topic := range []string{"refunds", "returns", "fulfillments", "shipments"} {
+		go consumeOrderEvents(ctx, cfg, topic)
+	}
+	go saga.Serve(ctx, cfg.KafkaBroker, "magento", "ladybug-saga-group", func(ctx context.Context, cmd saga.Command) (string, error) {
+		return handleSagaCommand(ctx, vault, cmd)
+	})
+	go catalogsync.Serve(ctx, cfg.KafkaBroker, "magento", "ladybug-catalog-group", syncToMagento)
+	go catalogsync.ServePrices(ctx, cfg.KafkaBroker, "magento", "ladybug-prices-group", setPriceInMagento)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -47,7 +71,14 @@ func main() {
 				continue
 			}
 
-			if order.Platform == "magento" {
+			// Orders placed through the saga arrive as saga commands instead.
+			if order.RoutesTo("magento") && !steps.Drives(order, "magento") {
+				if vault != nil {
+					if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+						log.Printf("[%s] Failed to detokenize order %s: %v", cfg.ServiceName, order.ID, err)
+						continue
+					}
+				}
 				if err := sendToMagento(order); err != nil {
 					log.Printf("[%s] Failed to send to Magento: %v", cfg.ServiceName, err)
 					continue
@@ -59,8 +90,102 @@ func main() {
 }
 
 func sendToMagento(order models.Order) error {
-	log.Printf("[ladybug] Sending order to Magento API: %+v", order)
+	log.Printf("[ladybug] Sending order %s to Magento API: %d items, total %.2f", order.ID, len(order.Items), order.Total)
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
 
+// handleSagaCommand runs one step of an order placement saga against
+// Magento. Status updates resend the order like a regular update.
+func handleSagaCommand(ctx context.Context, vault *pii.VaultClient, cmd saga.Command) (string, error) {
+	order := cmd.Order
+	if vault != nil {
+		if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch cmd.Action {
+	case "place", "update_status":
+		err = sendToMagento(order)
+	case "cancel":
+		err = cancelInMagento(order)
+	default:
+		err = fmt.Errorf("unsupported action %s", cmd.Action)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[ladybug] Ran saga step %s for order %s in Magento", cmd.Action, order.ID)
+	return order.ID, nil
+}
+
+func cancelInMagento(order models.Order) error {
+	log.Printf("[ladybug] Cancelling order %s in Magento API", order.ID)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// syncToMagento updates the changed fields of a product in Magento. Child
+// products of a configurable product are updated by their own SKU.
+func syncToMagento(ctx context.Context, product models.CatalogProduct, changes models.ProductSource) error {
+	log.Printf("[ladybug] Updating product %s in Magento API: %s", changes.ID, strings.Join(catalogsync.Fields(changes), ", "))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// setPriceInMagento sets the special or customer group price of a product
+// in Magento, or removes it when the change has none.
+func setPriceInMagento(ctx context.Context, change catalogsync.PriceChange) error {
+	if change.Price == nil {
+		log.Printf("[ladybug] Restoring price of %s in Magento API for %s", change.SKU, change.Key())
+	} else {
+		log.Printf("[ladybug] Setting price of %s to %.2f %s in Magento API for %s", change.SKU, *change.Price, change.Currency, change.Key())
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// consumeOrderEvents forwards refunds, returns, fulfillments and shipments of
+// orders routed to Magento from their dedicated topic.
+func consumeOrderEvents(ctx context.Context, cfg config.Config, topic string) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, "ladybug-"+topic+"-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ref models.OrderEventRef
+			if err := json.Unmarshal(msg.Value, &ref); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			if ref.RoutesTo("magento") {
+				if err := sendEventToMagento(topic, ref, msg.Value); err != nil {
+					log.Printf("[%s] Failed to send %s %s to Magento: %v", cfg.ServiceName, topic, ref.ID, err)
+					continue
+				}
+				log.Printf("[%s] Sent %s %s for order %s to Magento", cfg.ServiceName, topic, ref.ID, ref.OrderID)
+			}
+		}
+	}
+}
+
+func sendEventToMagento(topic string, ref models.OrderEventRef, body []byte) error {
+	log.Printf("[ladybug] Sending %s %s to Magento API (%d bytes)", topic, ref.ID, len(body))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
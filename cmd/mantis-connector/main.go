@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"ecommerce-platform/internal/catalogsync"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/saga"
 )
 
 func main() {
@@ -24,8 +29,27 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var vault *pii.VaultClient
+	if cfg.VaultURL != "" && cfg.VaultClientKey != "" {
+		vault = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
 	log.Printf("[%s] Starting connector to BigCommerce", cfg.ServiceName)
 
+	for _, topic := range []string{"refunds", "returns", "fulfillments", "shipments"} {
+		go consumeOrderEvents(ctx, cfg, topic)
+	}
+	go saga.Serve(ctx, cfg.KafkaBroker, "bigcommerce", "mantis-saga-group", func(ctx context.Context, cmd saga.Command) (string, error) {
+		return handleSagaCommand(ctx, vault, cmd)
+	})
+	go catalogsync.Serve(ctx, cfg.KafkaBroker, "bigcommerce", "mantis-catalog-group", syncToBigCommerce)
+	go catalogsync.ServePrices(ctx, cfg.KafkaBroker, "bigcommerce", "mantis-prices-group", setPriceInBigCommerce)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -47,7 +71,14 @@ func main() {
 				continue
 			}
 
-			if order.Platform == "bigcommerce" {
+			// Orders placed through the saga arrive as saga commands instead.
+			if order.RoutesTo("bigcommerce") && !steps.Drives(order, "bigcommerce") {
+				if vault != nil {
+					if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+						log.Printf("[%s] Failed to detokenize order %s: %v", cfg.ServiceName, order.ID, err)
+						continue
+					}
+				}
 				if err := sendToBigCommerce(order); err != nil {
 					log.Printf("[%s] Failed to send to BigCommerce: %v", cfg.ServiceName, err)
 					continue
@@ -59,8 +90,104 @@ func main() {
 }
 
 func sendToBigCommerce(order models.Order) error {
-	log.Printf("[mantis] Sending order to BigCommerce API: %+v", order)
+	log.Printf("[mantis] Sending order %s to BigCommerce API: %d items, total %.2f", order.ID, len(order.Items), order.Total)
 	time.Sleep(100 * time.Millisecond)
 	return nil
 }
 
+// handleSagaCommand runs one step of an order placement saga against
+// BigCommerce. Status updates resend the order like a regular update.
+func handleSagaCommand(ctx context.Context, vault *pii.VaultClient, cmd saga.Command) (string, error) {
+	order := cmd.Order
+	if vault != nil {
+		if err := vault.DetokenizeOrder(ctx, &order); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch cmd.Action {
+	case "place", "update_status":
+		err = sendToBigCommerce(order)
+	case "cancel":
+		err = cancelInBigCommerce(order)
+	default:
+		err = fmt.Errorf("unsupported action %s", cmd.Action)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[mantis] Ran saga step %s for order %s in BigCommerce", cmd.Action, order.ID)
+	return order.ID, nil
+}
+
+func cancelInBigCommerce(order models.Order) error {
+	log.Printf("[mantis] Cancelling order %s in BigCommerce API", order.ID)
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// syncToBigCommerce updates the changed fields of a product in
+// BigCommerce, sending variant prices and inventory levels in the same
+// call.
+func syncToBigCommerce(ctx context.Context, product models.CatalogProduct, changes models.ProductSource) error {
+	log.Printf("[mantis] Updating product %s in BigCommerce API: %s", changes.ID, strings.Join(catalogsync.Fields(changes), ", "))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// setPriceInBigCommerce sets the price of a product or variant in the
+// BigCommerce price list assigned to the channel and customer group, or
+// removes it when the change has none.
+func setPriceInBigCommerce(ctx context.Context, change catalogsync.PriceChange) error {
+	if change.Price == nil {
+		log.Printf("[mantis] Restoring price of %s in BigCommerce API for %s", change.SKU, change.Key())
+	} else {
+		log.Printf("[mantis] Setting price of %s to %.2f %s in BigCommerce API for %s", change.SKU, *change.Price, change.Currency, change.Key())
+	}
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+// consumeOrderEvents forwards refunds, returns, fulfillments and shipments of
+// orders routed to BigCommerce from their dedicated topic.
+func consumeOrderEvents(ctx context.Context, cfg config.Config, topic string) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, topic, "mantis-"+topic+"-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ref models.OrderEventRef
+			if err := json.Unmarshal(msg.Value, &ref); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			if ref.RoutesTo("bigcommerce") {
+				if err := sendEventToBigCommerce(topic, ref, msg.Value); err != nil {
+					log.Printf("[%s] Failed to send %s %s to BigCommerce: %v", cfg.ServiceName, topic, ref.ID, err)
+					continue
+				}
+				log.Printf("[%s] Sent %s %s for order %s to BigCommerce", cfg.ServiceName, topic, ref.ID, ref.OrderID)
+			}
+		}
+	}
+}
+
+func sendEventToBigCommerce(topic string, ref models.OrderEventRef, body []byte) error {
+	log.Printf("[mantis] Sending %s %s to BigCommerce API (%d bytes)", topic, ref.ID, len(body))
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+)
+
+// apiError is the body of every non-2xx response.
+type apiError struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// listOrders serves GET /orders. Filters: platform, status, customer_id,
+// created_after, created_before, min_total, max_total. sort is one of
+// created_at, updated_at or total, prefixed with "-" for descending order.
+// Pages are chained through the next_cursor of the previous response.
+func listOrders(store orderstore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseOrderQuery(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+			return
+		}
+
+		page, err := store.List(r.Context(), query)
+		if errors.Is(err, orderstore.ErrInvalidQuery) {
+			writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[order-service] List orders failed: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal", "Failed to list orders")
+			return
+		}
+		if page.Orders == nil {
+			page.Orders = []models.Order{}
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// getOrder serves GET /orders/{id}. The ETag is the order version, so a
+// client revalidating with If-None-Match gets 304 until the order changes.
+// With as_of the order is rebuilt from its history as it stood at that time.
+func getOrder(store orderstore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		asOf, err := parseTimeParam(r.URL.Query().Get("as_of"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "as_of: "+err.Error())
+			return
+		}
+
+		var order models.Order
+		if asOf.IsZero() {
+			order, err = store.Get(r.Context(), id)
+		} else {
+			order, err = orderstore.AsOf(r.Context(), store, id, asOf)
+		}
+		if errors.Is(err, orderstore.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("Order %s not found", id))
+			return
+		}
+		if err != nil {
+			log.Printf("[order-service] Get order %s failed: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "internal", "Failed to load order")
+			return
+		}
+
+		etag := orderETag(order)
+		w.Header().Set("ETag", etag)
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+// historyEntry is a history record without the full order snapshot, which
+// GET /orders/{id}?as_of= serves instead.
+type historyEntry struct {
+	Version int64 `json:"version"`
+	orderstore.Change
+	Changes []orderstore.FieldChange `json:"changes"`
+}
+
+// getOrderHistory serves GET /orders/{id}/history: every write to the order
+// in order, with the event or API call behind it and the fields it changed.
+func getOrderHistory(store orderstore.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		entries, err := store.History(r.Context(), id)
+		if errors.Is(err, orderstore.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("Order %s not found", id))
+			return
+		}
+		if err != nil {
+			log.Printf("[order-service] History of order %s failed: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "internal", "Failed to load order history")
+			return
+		}
+
+		history := make([]historyEntry, 0, len(entries))
+		for _, entry := range entries {
+			history = append(history, historyEntry{Version: entry.Version, Change: entry.Change, Changes: entry.Changes})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"order_id": id, "history": history})
+	}
+}
+
+func parseOrderQuery(r *http.Request) (orderstore.Query, error) {
+	values := r.URL.Query()
+	query := orderstore.Query{
+		Platform:   values.Get("platform"),
+		Status:     values.Get("status"),
+		CustomerID: values.Get("customer_id"),
+		Cursor:     values.Get("cursor"),
+	}
+
+	var err error
+	if query.CreatedAfter, err = parseTimeParam(values.Get("created_after")); err != nil {
+		return query, fmt.Errorf("created_after: %w", err)
+	}
+	if query.CreatedBefore, err = parseTimeParam(values.Get("created_before")); err != nil {
+		return query, fmt.Errorf("created_before: %w", err)
+	}
+	if query.MinTotal, err = parseFloatParam(values.Get("min_total")); err != nil {
+		return query, fmt.Errorf("min_total: %w", err)
+	}
+	if query.MaxTotal, err = parseFloatParam(values.Get("max_total")); err != nil {
+		return query, fmt.Errorf("max_total: %w", err)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+
+	query.SortBy = strings.TrimPrefix(values.Get("sort"), "-")
+	query.Descending = strings.HasPrefix(values.Get("sort"), "-")
+	return query, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or plain dates.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 timestamp or YYYY-MM-DD date")
+	}
+	return t, nil
+}
+
+func parseFloatParam(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("expected a number")
+	}
+	return &f, nil
+}
+
+func orderETag(order models.Order) string {
+	return fmt.Sprintf("\"%s-%d\"", order.ID, order.Version)
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: errorDetail{Code: code, Message: message}})
+}
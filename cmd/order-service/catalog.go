@@ -0,0 +1,43 @@
+package main
+
+import "ecommerce-platform/internal/models"
+
+// attachCatalogRefs copies the canonical SKU, name and cost resolved by the
+// catalog enrichment stage onto the order line items. Items bought as a
+// variant get the variant's SKU and title.
+func attachCatalogRefs(items []models.Item, enrichedData map[string]interface{}) {
+	catalog, ok := enrichedData["catalog"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	refs, ok := catalog["items"].([]interface{})
+	if !ok {
+		return
+	}
+
+	byProduct := make(map[string]map[string]interface{})
+	for _, entry := range refs {
+		ref, ok := entry.(map[string]interface{})
+		if !ok || ref["known"] != true {
+			continue
+		}
+		if productID, ok := ref["product_id"].(string); ok {
+			line := models.Item{ProductID: productID, VariantID: stringField(ref, "variant_id", "")}
+			byProduct[line.Key()] = ref
+		}
+	}
+
+	for i := range items {
+		ref, ok := byProduct[items[i].Key()]
+		if !ok {
+			continue
+		}
+		items[i].CatalogID = stringField(ref, "catalog_id", "")
+		items[i].SKU = stringField(ref, "sku", "")
+		items[i].Name = stringField(ref, "name", "")
+		items[i].Variant = stringField(ref, "variant", "")
+		if cost, ok := ref["cost"].(float64); ok {
+			items[i].Cost = cost
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+
+	"ecommerce-platform/internal/models"
+)
+
+// parseCustomer reads the buyer of an order payload. Personal fields arrive
+// already tokenized by the PII redaction stage.
+func parseCustomer(payload map[string]interface{}) *models.Customer {
+	customer := &models.Customer{
+		Email: stringField(payload, "email", ""),
+		Phone: stringField(payload, "phone", ""),
+	}
+
+	if fields, ok := payload["customer"].(map[string]interface{}); ok {
+		customer.ID = idField(fields, "id")
+		customer.Email = stringField(fields, "email", customer.Email)
+		customer.Phone = stringField(fields, "phone", customer.Phone)
+		customer.Name = personName(fields)
+	}
+	customer.ShippingAddress = parseAddress(payload["shipping_address"])
+	customer.BillingAddress = parseAddress(payload["billing_address"])
+
+	if *customer == (models.Customer{}) {
+		return nil
+	}
+	return customer
+}
+
+func parseAddress(value interface{}) *models.Address {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return &models.Address{
+		Name:     personName(fields),
+		Address1: stringField(fields, "address1", ""),
+		Address2: stringField(fields, "address2", ""),
+		City:     stringField(fields, "city", ""),
+		Province: stringField(fields, "province", ""),
+		Zip:      stringField(fields, "zip", ""),
+		Country:  stringField(fields, "country", ""),
+		Phone:    stringField(fields, "phone", ""),
+	}
+}
+
+func personName(fields map[string]interface{}) string {
+	if name := stringField(fields, "name", ""); name != "" {
+		return name
+	}
+	first := stringField(fields, "first_name", "")
+	last := stringField(fields, "last_name", "")
+	return strings.TrimSpace(first + " " + last)
+}
+
+// canonicalCustomerID returns the cross-platform customer ID assigned by the
+// identity enrichment stage.
+func canonicalCustomerID(enrichedData map[string]interface{}) string {
+	if identity, ok := enrichedData["identity"].(map[string]interface{}); ok {
+		return stringField(identity, "customer_id", "")
+	}
+	return ""
+}
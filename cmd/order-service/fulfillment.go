@@ -0,0 +1,342 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+func convertToRefund(enriched models.EnrichedEvent) models.Refund {
+	refund := models.Refund{
+		ID:        enriched.ID,
+		Platform:  enriched.Platform,
+		CreatedAt: enriched.ReceivedAt,
+	}
+
+	if payload, ok := enriched.Payload["refund"].(map[string]interface{}); ok {
+		if id := idField(payload, "id"); id != "" {
+			refund.ID = id
+		}
+		refund.OrderID = idField(payload, "order_id")
+		refund.Reason = stringField(payload, "reason", "")
+		if amount, ok := payload["amount"].(float64); ok {
+			refund.Amount = amount
+		}
+		refund.Items = parseItems(payload["items"])
+	}
+
+	return refund
+}
+
+func convertToReturn(enriched models.EnrichedEvent) models.Return {
+	ret := models.Return{
+		ID:        enriched.ID,
+		Platform:  enriched.Platform,
+		Status:    "requested",
+		CreatedAt: enriched.ReceivedAt,
+	}
+
+	if payload, ok := enriched.Payload["return"].(map[string]interface{}); ok {
+		if id := idField(payload, "id"); id != "" {
+			ret.ID = id
+		}
+		ret.OrderID = idField(payload, "order_id")
+		ret.Status = stringField(payload, "status", ret.Status)
+		ret.Reason = stringField(payload, "reason", "")
+		ret.Items = parseItems(payload["items"])
+	}
+
+	return ret
+}
+
+func convertToFulfillment(enriched models.EnrichedEvent) models.Fulfillment {
+	fulfillment := models.Fulfillment{
+		ID:        enriched.ID,
+		Platform:  enriched.Platform,
+		Status:    "success",
+		CreatedAt: enriched.ReceivedAt,
+	}
+
+	if payload, ok := enriched.Payload["fulfillment"].(map[string]interface{}); ok {
+		if id := idField(payload, "id"); id != "" {
+			fulfillment.ID = id
+		}
+		fulfillment.OrderID = idField(payload, "order_id")
+		fulfillment.Status = stringField(payload, "status", fulfillment.Status)
+		fulfillment.LocationID = stringField(payload, "location_id", "")
+		fulfillment.Items = parseItems(payload["items"])
+	}
+
+	return fulfillment
+}
+
+func convertToShipment(enriched models.EnrichedEvent) models.Shipment {
+	shipment := models.Shipment{
+		ID:        enriched.ID,
+		Platform:  enriched.Platform,
+		Status:    "in_transit",
+		UpdatedAt: enriched.ReceivedAt,
+	}
+
+	if payload, ok := enriched.Payload["shipment"].(map[string]interface{}); ok {
+		if id := idField(payload, "id"); id != "" {
+			shipment.ID = id
+		}
+		shipment.OrderID = idField(payload, "order_id")
+		shipment.FulfillmentID = idField(payload, "fulfillment_id")
+		shipment.Carrier = stringField(payload, "carrier", "")
+		shipment.TrackingNumber = stringField(payload, "tracking_number", "")
+		shipment.TrackingURL = stringField(payload, "tracking_url", "")
+		shipment.Status = stringField(payload, "status", shipment.Status)
+		shipment.ShippedAt = timeField(payload, "shipped_at")
+		shipment.DeliveredAt = timeField(payload, "delivered_at")
+	}
+
+	return shipment
+}
+
+// applyRefund records the refunded amount by refund ID and moves the order
+// to refunded once nothing is left to pay back. A refund seen again, from
+// refund.updated or a redelivery, replaces its amount instead of adding to
+// it. Refunds recorded before they were kept by ID stay under the empty ID.
+func applyRefund(order *models.Order, refund models.Refund) {
+	if order.Refunds == nil {
+		order.Refunds = make(map[string]float64)
+		if order.RefundedTotal != 0 {
+			order.Refunds[""] = order.RefundedTotal
+		}
+	}
+	order.Refunds[refund.ID] = refund.Amount
+	order.RefundedTotal = 0
+	for _, amount := range order.Refunds {
+		order.RefundedTotal += amount
+	}
+	order.Balance = order.Total - order.RefundedTotal
+
+	if order.RefundedTotal >= order.Total {
+		order.Status = "refunded"
+	} else {
+		order.Status = "partially_refunded"
+	}
+}
+
+// applyFulfillment records the quantities a fulfillment covers by
+// fulfillment ID and item key, and recomputes the fulfilled quantity of each
+// line item from them. A fulfillment seen again, from fulfillment.updated or
+// a redelivery, replaces what it covered instead of adding to it. A
+// fulfillment without items covers the whole order.
+func applyFulfillment(order *models.Order, fulfillment models.Fulfillment) {
+	if fulfillment.Status == "cancelled" || fulfillment.Status == "failure" {
+		return
+	}
+
+	if order.Fulfillments == nil {
+		order.Fulfillments = unkeyedFulfillments(*order)
+	}
+	delete(order.Fulfillments, fulfillment.ID)
+	covered := make(map[string]int)
+	if len(fulfillment.Items) == 0 {
+		for _, item := range order.Items {
+			covered[item.Key()] += item.Quantity
+		}
+	}
+	for _, fulfilled := range fulfillment.Items {
+		if key := fulfilledLine(*order, fulfilled, covered); key != "" {
+			covered[key] += fulfilled.Quantity
+		}
+	}
+	order.Fulfillments[fulfillment.ID] = covered
+	setFulfilled(order)
+
+	if order.Status == "refunded" || order.Status == "cancelled" {
+		return
+	}
+	if isFullyFulfilled(*order) {
+		order.Status = "fulfilled"
+	} else {
+		order.Status = "partially_fulfilled"
+	}
+}
+
+// fulfilledLine returns the key of the one line item a fulfillment line
+// covers: the first line of the same product, and variant when the platform
+// sent one, that still has units left to fulfill, otherwise the first such
+// line. covered is what the fulfillment has already assigned.
+func fulfilledLine(order models.Order, fulfilled models.Item, covered map[string]int) string {
+	open := make(map[string]int)
+	for _, item := range order.Items {
+		open[item.Key()] += item.Quantity
+	}
+	for _, quantities := range order.Fulfillments {
+		for key, quantity := range quantities {
+			open[key] -= quantity
+		}
+	}
+	for key, quantity := range covered {
+		open[key] -= quantity
+	}
+
+	match := ""
+	for _, item := range order.Items {
+		if item.ProductID != fulfilled.ProductID ||
+			fulfilled.VariantID != "" && item.VariantID != fulfilled.VariantID {
+			continue
+		}
+		if open[item.Key()] > 0 {
+			return item.Key()
+		}
+		if match == "" {
+			match = item.Key()
+		}
+	}
+	return match
+}
+
+// unkeyedFulfillments keeps the fulfilled quantities of an order recorded
+// before fulfillments were kept by ID under the empty ID.
+func unkeyedFulfillments(order models.Order) map[string]map[string]int {
+	fulfillments := make(map[string]map[string]int)
+	for _, item := range order.Items {
+		if item.Fulfilled > 0 {
+			if fulfillments[""] == nil {
+				fulfillments[""] = make(map[string]int)
+			}
+			fulfillments[""][item.Key()] += item.Fulfilled
+		}
+	}
+	return fulfillments
+}
+
+// setFulfilled sets the fulfilled quantity of each line item from the
+// recorded fulfillments. Lines sharing a key are filled in order.
+func setFulfilled(order *models.Order) {
+	left := make(map[string]int)
+	for _, quantities := range order.Fulfillments {
+		for key, quantity := range quantities {
+			left[key] += quantity
+		}
+	}
+	for i := range order.Items {
+		key := order.Items[i].Key()
+		order.Items[i].Fulfilled = max(min(left[key], order.Items[i].Quantity), 0)
+		left[key] -= order.Items[i].Fulfilled
+	}
+}
+
+// applyShipment completes a fulfilled order once its shipment is delivered.
+func applyShipment(order *models.Order, shipment models.Shipment) {
+	if shipment.Status == "delivered" && order.Status == "fulfilled" {
+		order.Status = "completed"
+	}
+}
+
+func isFullyFulfilled(order models.Order) bool {
+	for _, item := range order.Items {
+		if item.Fulfilled < item.Quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// carryOverProgress keeps refunds, fulfilled quantities, the risk score, the
+// canonical customer, notes and split/merge links recorded on the stored
+// order when an order.updated webhook replaces it.
+func carryOverProgress(order *models.Order, existing models.Order) {
+	order.Notes = existing.Notes
+	order.ParentIDs = existing.ParentIDs
+	order.ChildIDs = existing.ChildIDs
+	order.Relation = existing.Relation
+	order.LocationID = existing.LocationID
+	order.RefundedTotal = existing.RefundedTotal
+	order.Refunds = existing.Refunds
+	order.Fulfillments = existing.Fulfillments
+	order.Balance = order.Total - order.RefundedTotal
+	if order.RiskScore == 0 {
+		order.RiskScore = existing.RiskScore
+		order.RiskReasons = existing.RiskReasons
+	}
+	if order.UserID == "" {
+		order.UserID = existing.UserID
+	}
+
+	if order.Fulfillments == nil {
+		order.Fulfillments = unkeyedFulfillments(existing)
+	}
+	setFulfilled(order)
+}
+
+func parseItems(value interface{}) []models.Item {
+	raw, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	items := make([]models.Item, 0, len(raw))
+	for _, entry := range raw {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		item := models.Item{
+			ProductID: idField(fields, "product_id"),
+			VariantID: idField(fields, "variant_id"),
+			SKU:       stringField(fields, "sku", ""),
+		}
+		if quantity, ok := fields["quantity"].(float64); ok {
+			item.Quantity = int(quantity)
+		}
+		if price, ok := fields["price"].(float64); ok {
+			item.Price = price
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func stringField(payload map[string]interface{}, key, defaultValue string) string {
+	if value, ok := payload[key].(string); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// floatField returns the first of keys that holds a number.
+func floatField(payload map[string]interface{}, keys ...string) float64 {
+	for _, key := range keys {
+		switch value := payload[key].(type) {
+		case float64:
+			return value
+		case string:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return f
+			}
+		}
+	}
+	return 0
+}
+
+// idField reads an identifier that platforms send either as a string or as a
+// number.
+func idField(payload map[string]interface{}, key string) string {
+	switch value := payload[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+func timeField(payload map[string]interface{}, key string) *time.Time {
+	value, ok := payload[key].(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
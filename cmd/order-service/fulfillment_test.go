@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"ecommerce-platform/internal/models"
+)
+
+func TestFulfillmentAppliedOnce(t *testing.T) {
+	order := models.Order{ID: "O-1", Status: "paid", Items: []models.Item{
+		{ProductID: "a", VariantID: "red", Quantity: 2},
+		{ProductID: "a", VariantID: "blue", Quantity: 2},
+		{ProductID: "b", Quantity: 1},
+	}}
+	first := models.Fulfillment{ID: "F-1", Status: "success", Items: []models.Item{{ProductID: "a", Quantity: 1}}}
+
+	applyFulfillment(&order, first)
+	applyFulfillment(&order, first)
+	if order.Items[0].Fulfilled != 1 || order.Items[1].Fulfilled != 0 {
+		t.Fatalf("fulfilled after redelivery = %d and %d, want 1 and 0", order.Items[0].Fulfilled, order.Items[1].Fulfilled)
+	}
+
+	first.Items[0].Quantity = 2
+	applyFulfillment(&order, first)
+	if order.Items[0].Fulfilled != 2 || order.Items[1].Fulfilled != 0 || order.Status != "partially_fulfilled" {
+		t.Fatalf("after update = %d and %d, %s; want 2 and 0, partially_fulfilled", order.Items[0].Fulfilled, order.Items[1].Fulfilled, order.Status)
+	}
+
+	applyFulfillment(&order, models.Fulfillment{ID: "F-2", Status: "success", Items: []models.Item{
+		{ProductID: "a", Quantity: 2},
+		{ProductID: "b", Quantity: 1},
+	}})
+	if !isFullyFulfilled(order) || order.Status != "fulfilled" {
+		t.Fatalf("order after the second fulfillment = %+v, want fulfilled", order.Items)
+	}
+}
+
+func TestCarryOverFulfillments(t *testing.T) {
+	existing := models.Order{ID: "O-1", Status: "paid", Items: []models.Item{{ProductID: "a", Quantity: 2, Fulfilled: 1}}}
+	fulfillment := models.Fulfillment{ID: "F-1", Status: "success", Items: []models.Item{{ProductID: "a", Quantity: 1}}}
+	applyFulfillment(&existing, fulfillment)
+
+	updated := models.Order{ID: "O-1", Status: "paid", Items: []models.Item{{ProductID: "a", Quantity: 2}}}
+	carryOverProgress(&updated, existing)
+	applyFulfillment(&updated, fulfillment)
+	if updated.Items[0].Fulfilled != 2 {
+		t.Fatalf("fulfilled after update and redelivery = %d, want 2", updated.Items[0].Fulfilled)
+	}
+}
@@ -3,34 +3,94 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"ecommerce-platform/internal/auth"
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+	"ecommerce-platform/internal/pii"
 )
 
 func main() {
 	cfg := config.Load()
 	cfg.ServiceName = "order-service"
 
+	store, err := orderstore.Open(cfg.OrderStore, cfg.OrderDBPath, cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open order store: %v", cfg.ServiceName, err)
+	}
+	defer store.Close()
+
+	rules, err := loadRoutingRules(cfg.RoutingRulesFile)
+	if err != nil {
+		log.Fatalf("[%s] Invalid routing rules: %v", cfg.ServiceName, err)
+	}
+	rt := &router{rules: rules, stock: newStockIndex()}
+
+	if len(os.Args) > 1 && os.Args[1] == "rebuild" {
+		force := len(os.Args) > 2 && os.Args[2] == "-force"
+		if err := rebuild(cfg, store, rt, force); err != nil {
+			log.Fatalf("[%s] Rebuild failed: %v", cfg.ServiceName, err)
+		}
+		return
+	}
+
 	consumer := kafka.NewConsumer(cfg.KafkaBroker, cfg.KafkaTopic+"-enriched", "order-service-group")
 	defer consumer.Close()
 
-	producer := kafka.NewProducer(cfg.KafkaBroker, "orders")
-	defer producer.Close()
+	producers := make(map[string]*kafka.Producer)
+	for _, topic := range []string{"orders", "order-status", "refunds", "returns", "fulfillments", "shipments"} {
+		producer := kafka.NewProducer(cfg.KafkaBroker, topic)
+		defer producer.Close()
+		producers[topic] = producer
+	}
+
+	clients, err := auth.ParseClients(cfg.OrderAPIClients)
+	if err != nil {
+		log.Fatalf("[%s] Invalid ORDER_API_CLIENTS: %v", cfg.ServiceName, err)
+	}
+
+	var tokenizer pii.Tokenizer
+	if cfg.VaultURL != "" {
+		tokenizer = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+	redactor, err := pii.NewRedactor(cfg.PIIFields, cfg.PIIDetectors, tokenizer)
+	if err != nil {
+		log.Fatalf("[%s] Invalid PII configuration: %v", cfg.ServiceName, err)
+	}
+	m := &mutator{store: store, redactor: redactor, router: rt}
 
 	router := mux.NewRouter()
-	router.HandleFunc("/orders", listOrders).Methods("GET")
-	router.HandleFunc("/orders/{id}", getOrder).Methods("GET")
+	router.HandleFunc("/orders", listOrders(store)).Methods("GET")
+	router.HandleFunc("/orders", requireGrant(clients, "create", createOrder(m))).Methods("POST")
+	router.HandleFunc("/orders/{id}", getOrder(store)).Methods("GET")
+	router.HandleFunc("/orders/{id}/history", getOrderHistory(store)).Methods("GET")
+	router.HandleFunc("/orders/{id}/cancel", requireGrant(clients, "cancel", changeStatus(m, "cancel"))).Methods("POST")
+	router.HandleFunc("/orders/{id}/hold", requireGrant(clients, "hold", changeStatus(m, "hold"))).Methods("POST")
+	router.HandleFunc("/orders/{id}/release", requireGrant(clients, "release", changeStatus(m, "release"))).Methods("POST")
+	router.HandleFunc("/orders/{id}/notes", requireGrant(clients, "annotate", annotateOrder(m))).Methods("POST")
+	router.HandleFunc("/orders/{id}/split", requireGrant(clients, "split", splitOrder(m))).Methods("POST")
+	router.HandleFunc("/orders/merge", requireGrant(clients, "merge", mergeOrders(m))).Methods("POST")
+	router.HandleFunc("/outbox", getOutboxStats(store, cfg.OutboxStuckAfter)).Methods("GET")
 	router.HandleFunc("/health", healthCheck).Methods("GET")
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "Route not found")
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	})
 
 	server := &http.Server{
 		Addr:    ":" + cfg.HTTPPort,
@@ -40,9 +100,13 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	orders := make(map[string]models.Order)
+	catalogConsumer := kafka.NewTableConsumer(cfg.KafkaBroker, "catalog")
+	defer catalogConsumer.Close()
+	go materializeStock(ctx, catalogConsumer, rt.stock)
 
-	go processOrders(ctx, consumer, producer, orders)
+	go processOrders(ctx, consumer, store, rt, cfg.RiskHoldThreshold)
+	go relayOutbox(ctx, store, producers)
+	go monitorOutbox(ctx, store, cfg.OutboxStuckAfter)
 
 	go func() {
 		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
@@ -56,7 +120,7 @@ func main() {
 	server.Shutdown(context.Background())
 }
 
-func processOrders(ctx context.Context, consumer *kafka.Consumer, producer *kafka.Producer, orders map[string]models.Order) {
+func processOrders(ctx context.Context, consumer *kafka.Consumer, store orderstore.Store, rt *router, holdThreshold float64) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -77,18 +141,197 @@ func processOrders(ctx context.Context, consumer *kafka.Consumer, producer *kafk
 				continue
 			}
 
-			if enriched.EventType == "order.created" || enriched.EventType == "order.updated" {
-				order := convertToOrder(enriched)
-				orders[order.ID] = order
+			handleEnriched(ctx, store, rt, holdThreshold, enriched)
+		}
+	}
+}
+
+// handleEnriched applies one enriched webhook event to the order store. The
+// messages it causes go to the outbox in the same write.
+func handleEnriched(ctx context.Context, store orderstore.Store, rt *router, holdThreshold float64, enriched models.EnrichedEvent) {
+	switch {
+	case enriched.EventType == "order.created" || enriched.EventType == "order.updated":
+		incoming := convertToOrder(enriched)
+		source := "webhook:" + enriched.EventType
+		var changes []models.StatusTransition
+		order, err := orderstore.Update(ctx, store, incoming.ID, webhookChange(enriched), func(order *models.Order) ([]orderstore.Message, error) {
+			changes = nil
+			existing := *order
+			known := existing.Version > 0
+			*order = incoming
+			order.Version = existing.Version
+			order.Status = existing.Status
+			order.HeldFrom = existing.HeldFrom
+			order.Transitions = existing.Transitions
+			if known {
+				order.CreatedAt = existing.CreatedAt
+				carryOverProgress(order, existing)
+			}
+			// Destinations are decided once so that an update never moves an
+			// order to a system that has not seen its creation.
+			if len(existing.Destinations) > 0 {
+				order.Destinations = existing.Destinations
+			} else {
+				order.Destinations = rt.route(*order)
+			}
 
-				if err := producer.Send(ctx, order.ID, order); err != nil {
-					log.Printf("[order-service] Failed to send order: %v", err)
-					continue
+			// Webhooks never release a hold; only the mutation API does.
+			if order.Status != "on_hold" && incoming.Status != "" {
+				change, err := transition(order, incoming.Status, source, enriched.ID, "", enriched.ReceivedAt)
+				if err != nil {
+					log.Printf("[order-service] Ignoring status of order %s from %s: %v", order.ID, enriched.ID, err)
+				} else if change != nil {
+					changes = append(changes, *change)
+				}
+			}
+			if order.Status == "" {
+				change, _ := transition(order, "pending", source, enriched.ID, "", enriched.ReceivedAt)
+				changes = append(changes, *change)
+			}
+			if !known && order.RiskScore >= holdThreshold {
+				if change, err := transition(order, "on_hold", "risk", enriched.ID, fmt.Sprintf("risk score %.0f", order.RiskScore), enriched.ReceivedAt); err == nil && change != nil {
+					changes = append(changes, *change)
 				}
+			}
 
-				log.Printf("[order-service] Processed order: %s from %s", order.ID, order.Platform)
+			outbox := transitionMessages(changes)
+			if order.Status != "on_hold" {
+				outbox = append(outbox, orderstore.OrderMessage("orders"))
 			}
+			return outbox, nil
+		})
+		if err != nil {
+			log.Printf("[order-service] Failed to store order %s: %v", incoming.ID, err)
+			return
 		}
+		logTransitions(changes)
+
+		if order.Status == "on_hold" {
+			log.Printf("[order-service] Holding order %s from %s (risk %.0f: %v)", order.ID, order.Platform, order.RiskScore, order.RiskReasons)
+			return
+		}
+
+		log.Printf("[order-service] Processed order: %s from %s", order.ID, order.Platform)
+	case strings.HasPrefix(enriched.EventType, "refund."):
+		refund := convertToRefund(enriched)
+		refund.Destinations = orderDestinations(ctx, store, refund.OrderID)
+		handleOrderEvent(ctx, store, enriched, "refunds", refund.ID, refund.OrderID, refund, func(order *models.Order) {
+			applyRefund(order, refund)
+		})
+	case strings.HasPrefix(enriched.EventType, "return."):
+		ret := convertToReturn(enriched)
+		ret.Destinations = orderDestinations(ctx, store, ret.OrderID)
+		handleOrderEvent(ctx, store, enriched, "returns", ret.ID, ret.OrderID, ret, nil)
+	case strings.HasPrefix(enriched.EventType, "fulfillment."):
+		fulfillment := convertToFulfillment(enriched)
+		fulfillment.Destinations = orderDestinations(ctx, store, fulfillment.OrderID)
+		handleOrderEvent(ctx, store, enriched, "fulfillments", fulfillment.ID, fulfillment.OrderID, fulfillment, func(order *models.Order) {
+			applyFulfillment(order, fulfillment)
+		})
+	case strings.HasPrefix(enriched.EventType, "shipment."):
+		shipment := convertToShipment(enriched)
+		shipment.Destinations = orderDestinations(ctx, store, shipment.OrderID)
+		handleOrderEvent(ctx, store, enriched, "shipments", shipment.ID, shipment.OrderID, shipment, func(order *models.Order) {
+			applyShipment(order, shipment)
+		})
+	}
+}
+
+// handleOrderEvent forwards a refund, return, fulfillment or shipment to its
+// topic and, when apply is set, republishes the affected order with the
+// updated status and balance. The status apply asks for goes through the
+// state machine; a held order keeps its status until it is released.
+func handleOrderEvent(ctx context.Context, store orderstore.Store, enriched models.EnrichedEvent, topic, id, orderID string, value interface{}, apply func(*models.Order)) {
+	event := orderstore.NewMessage(topic, orderID, value)
+	if apply == nil {
+		if err := store.EnqueueOutbox(ctx, []orderstore.Message{event}); err != nil {
+			log.Printf("[order-service] Failed to queue %s %s: %v", topic, id, err)
+			return
+		}
+		log.Printf("[order-service] Processed %s %s for order %s", topic, id, orderID)
+		return
+	}
+
+	var changes []models.StatusTransition
+	order, err := orderstore.Update(ctx, store, orderID, webhookChange(enriched), func(order *models.Order) ([]orderstore.Message, error) {
+		changes = nil
+		if order.Version == 0 {
+			return nil, orderstore.ErrNotFound
+		}
+
+		from := order.Status
+		apply(order)
+		to := order.Status
+		order.Status = from
+		if from == "on_hold" {
+			return []orderstore.Message{event}, nil
+		}
+
+		change, err := transition(order, to, "webhook:"+enriched.EventType, enriched.ID, "", enriched.ReceivedAt)
+		if err != nil {
+			log.Printf("[order-service] Ignoring status change of order %s from %s %s: %v", orderID, topic, id, err)
+		} else if change != nil {
+			changes = append(changes, *change)
+		}
+
+		outbox := append([]orderstore.Message{event}, transitionMessages(changes)...)
+		return append(outbox, orderstore.OrderMessage("orders")), nil
+	})
+	if errors.Is(err, orderstore.ErrNotFound) {
+		log.Printf("[order-service] Unknown order %s for %s %s", orderID, topic, id)
+		if err := store.EnqueueOutbox(ctx, []orderstore.Message{event}); err != nil {
+			log.Printf("[order-service] Failed to queue %s %s: %v", topic, id, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("[order-service] Failed to store order %s: %v", orderID, err)
+		return
+	}
+	log.Printf("[order-service] Processed %s %s for order %s", topic, id, orderID)
+	logTransitions(changes)
+	if len(order.ParentIDs) > 0 {
+		rollUp(ctx, store, order, webhookChange(enriched))
+	}
+
+	if order.Status != "on_hold" {
+		log.Printf("[order-service] Order %s is now %s (balance %.2f)", order.ID, order.Status, order.Balance)
+	}
+}
+
+// orderDestinations returns where an order was routed so that its refunds,
+// returns, fulfillments and shipments follow it. It returns nil for unknown
+// orders, which RoutesTo then only routes to their origin platform.
+func orderDestinations(ctx context.Context, store orderstore.Store, orderID string) []string {
+	order, err := store.Get(ctx, orderID)
+	if err != nil {
+		return nil
+	}
+	return order.Destinations
+}
+
+func webhookChange(enriched models.EnrichedEvent) orderstore.Change {
+	return orderstore.Change{
+		EventID:   enriched.ID,
+		EventType: enriched.EventType,
+		Source:    "webhook:" + enriched.EventType,
+		At:        enriched.ReceivedAt,
+	}
+}
+
+// transitionMessages publishes accepted status changes to the order-status
+// topic, keyed by order ID so that each order's changes stay in order.
+func transitionMessages(changes []models.StatusTransition) []orderstore.Message {
+	messages := make([]orderstore.Message, 0, len(changes))
+	for _, change := range changes {
+		messages = append(messages, orderstore.NewMessage("order-status", change.OrderID, change))
+	}
+	return messages
+}
+
+func logTransitions(changes []models.StatusTransition) {
+	for _, change := range changes {
+		log.Printf("[order-service] Order %s moved %s → %s (%s)", change.OrderID, change.From, change.To, change.Source)
 	}
 }
 
@@ -97,36 +340,34 @@ func convertToOrder(enriched models.EnrichedEvent) models.Order {
 		ID:        enriched.ID,
 		Platform:  enriched.Platform,
 		CreatedAt: enriched.ReceivedAt,
-		Status:    "pending",
 	}
 
 	if payload, ok := enriched.Payload["order"].(map[string]interface{}); ok {
-		if id, ok := payload["id"].(string); ok {
+		if id := idField(payload, "id"); id != "" {
 			order.ID = id
 		}
 		if total, ok := payload["total"].(float64); ok {
 			order.Total = total
 		}
 		if status, ok := payload["status"].(string); ok {
-			order.Status = status
+			order.Status = normalizeStatus(status)
 		}
+		order.Channel = stringField(payload, "source_name", stringField(payload, "channel", ""))
+		order.Tax = floatField(payload, "total_tax", "tax")
+		order.Discount = floatField(payload, "total_discounts", "discount")
+		order.Shipping = floatField(payload, "total_shipping", "shipping")
+		order.Items = parseItems(payload["items"])
+		attachCatalogRefs(order.Items, enriched.EnrichedData)
+		order.Customer = parseCustomer(payload)
 	}
+	applyRisk(&order, enriched.EnrichedData)
+	order.UserID = canonicalCustomerID(enriched.EnrichedData)
 
+	order.Balance = order.Total
 	return order
 }
 
-func listOrders(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Orders list endpoint"})
-}
-
-func getOrder(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Get order endpoint"})
-}
-
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
-
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/auth"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+	"ecommerce-platform/internal/pii"
+)
+
+var (
+	errOrderExists        = errors.New("order already exists")
+	errPreconditionFailed = errors.New("order has changed")
+)
+
+// mutator applies support-staff changes to stored orders. Every change goes
+// through the state machine, is persisted, and is published to the orders
+// topic so that the platform connector propagates it.
+type mutator struct {
+	store    orderstore.Store
+	redactor *pii.Redactor
+	router   *router
+}
+
+type createRequest struct {
+	Platform string                 `json:"platform"`
+	Order    map[string]interface{} `json:"order"`
+}
+
+type mutationRequest struct {
+	Reason string `json:"reason"`
+	Text   string `json:"text"`
+}
+
+// createOrder accepts an order in the same shape as an order.created webhook
+// payload. PII is redacted before anything is stored.
+func createOrder(m *mutator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Order == nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "order required")
+			return
+		}
+		if req.Platform == "" {
+			req.Platform = "manual"
+		}
+		if idField(req.Order, "id") == "" {
+			req.Order["id"] = "manual-" + newID()
+		}
+
+		payload := map[string]interface{}{"order": req.Order}
+		if _, err := m.redactor.Redact(r.Context(), payload); err != nil {
+			log.Printf("[order-service] Failed to redact order: %v", err)
+			writeError(w, http.StatusServiceUnavailable, "unavailable", "Failed to redact customer data")
+			return
+		}
+
+		event := models.EnrichedEvent{WebhookEvent: models.WebhookEvent{
+			Platform:   req.Platform,
+			EventType:  "order.created",
+			Payload:    payload,
+			ReceivedAt: time.Now(),
+		}}
+		incoming := convertToOrder(event)
+		if incoming.Status != "" && incoming.Status != "pending" && incoming.Status != "paid" {
+			writeError(w, http.StatusBadRequest, "invalid_request", "status must be pending or paid")
+			return
+		}
+
+		source := "api:" + r.Header.Get("X-Client-ID")
+		order, err := m.mutate(r.Context(), incoming.ID, "", true, apiChange("create", source), func(order *models.Order) ([]models.StatusTransition, error) {
+			if order.Version > 0 {
+				return nil, errOrderExists
+			}
+			*order = incoming
+			order.Status = ""
+			order.Destinations = m.router.route(*order)
+			status := incoming.Status
+			if status == "" {
+				status = "pending"
+			}
+			change, err := transition(order, status, source, "", "created manually", time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return []models.StatusTransition{*change}, nil
+		})
+		if err != nil {
+			writeMutationError(w, order.ID, err)
+			return
+		}
+
+		log.Printf("[order-service] Created order %s on %s for %s", order.ID, order.Platform, source)
+		w.Header().Set("ETag", orderETag(order))
+		writeJSON(w, http.StatusCreated, order)
+	}
+}
+
+// changeStatus handles cancel, hold and release. A release returns the
+// order to the status it had when it was put on hold.
+func changeStatus(m *mutator, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mutationRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_request", "Invalid JSON")
+				return
+			}
+		}
+
+		id := mux.Vars(r)["id"]
+		source := "api:" + r.Header.Get("X-Client-ID")
+		order, err := m.mutate(r.Context(), id, r.Header.Get("If-Match"), false, apiChange(action, source), func(order *models.Order) ([]models.StatusTransition, error) {
+			var to string
+			switch action {
+			case "cancel":
+				to = "cancelled"
+			case "hold":
+				to = "on_hold"
+			case "release":
+				if order.Status != "on_hold" {
+					return nil, fmt.Errorf("%w: order is %s, not on_hold", errInvalidTransition, order.Status)
+				}
+				to = order.HeldFrom
+				if to == "" {
+					to = "pending"
+				}
+			}
+			if order.Status == to {
+				return nil, fmt.Errorf("%w: order is already %s", errInvalidTransition, to)
+			}
+
+			change, err := transition(order, to, source, "", req.Reason, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return []models.StatusTransition{*change}, nil
+		})
+		if err != nil {
+			writeMutationError(w, id, err)
+			return
+		}
+
+		log.Printf("[order-service] Applied %s to order %s for %s", action, order.ID, source)
+		w.Header().Set("ETag", orderETag(order))
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+func annotateOrder(m *mutator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mutationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request", "text required")
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		note := models.OrderNote{Text: req.Text, Author: r.Header.Get("X-Client-ID"), CreatedAt: time.Now()}
+		order, err := m.mutate(r.Context(), id, r.Header.Get("If-Match"), false, apiChange("annotate", "api:"+note.Author), func(order *models.Order) ([]models.StatusTransition, error) {
+			order.Notes = append(order.Notes, note)
+			return nil, nil
+		})
+		if err != nil {
+			writeMutationError(w, id, err)
+			return
+		}
+
+		log.Printf("[order-service] Annotated order %s for %s", order.ID, note.Author)
+		w.Header().Set("ETag", orderETag(order))
+		writeJSON(w, http.StatusOK, order)
+	}
+}
+
+// mutate applies fn to the stored order and queues the resulting status
+// changes and the order itself in the outbox. Unknown IDs are rejected
+// unless create is set, in which case fn receives a zero order. A non-empty
+// ifMatch must equal the current ETag of the order.
+func (m *mutator) mutate(ctx context.Context, id, ifMatch string, create bool, change orderstore.Change, fn func(order *models.Order) ([]models.StatusTransition, error)) (models.Order, error) {
+	var changes []models.StatusTransition
+	order, err := orderstore.Update(ctx, m.store, id, change, func(order *models.Order) ([]orderstore.Message, error) {
+		if ifMatch != "" && !etagMatches(ifMatch, orderETag(*order)) {
+			return nil, errPreconditionFailed
+		}
+		if order.Version == 0 && !create {
+			return nil, orderstore.ErrNotFound
+		}
+
+		var err error
+		if changes, err = fn(order); err != nil {
+			return nil, err
+		}
+		return append(transitionMessages(changes), orderstore.OrderMessage("orders")), nil
+	})
+	if err != nil {
+		return order, err
+	}
+
+	logTransitions(changes)
+	return order, nil
+}
+
+func apiChange(action, source string) orderstore.Change {
+	return orderstore.Change{EventType: "api." + action, Source: source, At: time.Now()}
+}
+
+func writeMutationError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, orderstore.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("Order %s not found", id))
+	case errors.Is(err, errOrderExists):
+		writeError(w, http.StatusConflict, "already_exists", fmt.Sprintf("Order %s already exists", id))
+	case errors.Is(err, errInvalidSplit):
+		writeError(w, http.StatusConflict, "invalid_split", err.Error())
+	case errors.Is(err, errInvalidTransition):
+		writeError(w, http.StatusConflict, "invalid_transition", err.Error())
+	case errors.Is(err, errPreconditionFailed):
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+	default:
+		log.Printf("[order-service] Mutation of order %s failed: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "internal", "Failed to update order")
+	}
+}
+
+func requireGrant(clients auth.Clients, grant string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch err := clients.Authorize(r, grant); {
+		case errors.Is(err, auth.ErrUnauthorized):
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		case errors.Is(err, auth.ErrForbidden):
+			writeError(w, http.StatusForbidden, "forbidden", "Forbidden")
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b)
+}
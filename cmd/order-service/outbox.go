@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/orderstore"
+)
+
+const outboxBatchSize = 100
+
+// relayOutbox publishes outbox messages to Kafka in the order they were
+// written. A failed send stops the batch so that nothing overtakes it, and
+// is retried after a pause. Delivery is at least once: a crash between the
+// send and marking the message sent publishes it again.
+func relayOutbox(ctx context.Context, store orderstore.Store, producers map[string]*kafka.Producer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		messages, err := store.PendingOutbox(ctx, outboxBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[order-service] Outbox read error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if len(messages) == 0 {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+
+		var sent []int64
+		var failed bool
+		for _, message := range messages {
+			producer, ok := producers[message.Topic]
+			if !ok {
+				err = fmt.Errorf("no producer for topic %s", message.Topic)
+			} else {
+				err = producer.Send(ctx, message.Key, message.Value)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("[order-service] Failed to relay outbox message %d to %s: %v", message.ID, message.Topic, err)
+				if err := store.MarkOutboxFailed(ctx, message.ID, err); err != nil {
+					log.Printf("[order-service] Failed to record outbox failure: %v", err)
+				}
+				failed = true
+				break
+			}
+			sent = append(sent, message.ID)
+		}
+
+		if err := store.MarkOutboxSent(ctx, sent); err != nil {
+			log.Printf("[order-service] Failed to mark outbox messages sent: %v", err)
+		}
+		if failed {
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// monitorOutbox logs a warning while the oldest unsent message is older than
+// stuckAfter, e.g. because Kafka is down or a message keeps failing.
+func monitorOutbox(ctx context.Context, store orderstore.Store, stuckAfter time.Duration) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := store.OutboxStats(ctx)
+			if err != nil {
+				log.Printf("[order-service] Outbox stats error: %v", err)
+				continue
+			}
+			if outboxStuck(stats, stuckAfter) {
+				log.Printf("[order-service] Outbox stuck: %d pending, oldest from %s after %d attempts (last error: %s)",
+					stats.Pending, stats.Oldest.Format(time.RFC3339), stats.Attempts, stats.LastError)
+			}
+		}
+	}
+}
+
+func outboxStuck(stats orderstore.OutboxStats, stuckAfter time.Duration) bool {
+	return stats.Pending > 0 && time.Since(stats.Oldest) > stuckAfter
+}
+
+// getOutboxStats serves GET /outbox, answering 503 while the outbox is
+// stuck so that it can be used as an alerting probe.
+func getOutboxStats(store orderstore.Store, stuckAfter time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.OutboxStats(r.Context())
+		if err != nil {
+			log.Printf("[order-service] Outbox stats error: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal", "Failed to read outbox")
+			return
+		}
+
+		status := http.StatusOK
+		stuck := outboxStuck(stats, stuckAfter)
+		if stuck {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, map[string]interface{}{"outbox": stats, "stuck": stuck})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+)
+
+// rebuild wipes the order store and replays the enriched topic from its
+// first offset through the same handling as the live consumer, which
+// regenerates both orders and their history. Orders keep the destinations
+// they were routed to, since those systems already have them. Mutations
+// made through the API are not part of the topic and would be lost, so
+// rebuild refuses to run while the store has any unless force is set.
+// Resetting the store also empties the outbox, so rebuild refuses to run
+// while it holds messages the relay has not delivered yet. The events were
+// already published when they were first processed, so the outbox written
+// by the replay is dropped rather than relayed.
+func rebuild(cfg config.Config, store orderstore.Store, rt *router, force bool) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pending, err := store.PendingOutbox(ctx, 1)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the outbox holds undelivered messages; start order-service so the relay publishes them, then rebuild")
+	}
+
+	kept, mutated, err := scanOrders(ctx, store)
+	if err != nil {
+		return err
+	}
+	if len(mutated) > 0 {
+		if !force {
+			return fmt.Errorf("%d orders were changed through the API and would lose those changes (%s); run rebuild -force to rebuild anyway", len(mutated), strings.Join(mutated, ", "))
+		}
+		log.Printf("[%s] Dropping API changes of %d orders: %s", cfg.ServiceName, len(mutated), strings.Join(mutated, ", "))
+	}
+	rt = &router{rules: rt.rules, stock: rt.stock, kept: kept}
+
+	// Routing of new orders uses the catalog stock as it is now.
+	err = kafka.ReadAll(ctx, cfg.KafkaBroker, "catalog", func(key, value []byte) error {
+		var product models.CatalogProduct
+		if len(value) == 0 || json.Unmarshal(value, &product) != nil {
+			rt.stock.remove(string(key))
+			return nil
+		}
+		rt.stock.put(string(key), product)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.Reset(ctx); err != nil {
+		return err
+	}
+
+	topic := cfg.KafkaTopic + "-enriched"
+	log.Printf("[%s] Rebuilding order store from %s", cfg.ServiceName, topic)
+
+	var replayed int
+	err = kafka.ReadAll(ctx, cfg.KafkaBroker, topic, func(key, value []byte) error {
+		var enriched models.EnrichedEvent
+		if err := json.Unmarshal(value, &enriched); err != nil {
+			log.Printf("[%s] Skipping malformed event: %v", cfg.ServiceName, err)
+			return nil
+		}
+		handleEnriched(ctx, store, rt, cfg.RiskHoldThreshold, enriched)
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := discardOutbox(ctx, store); err != nil {
+		return err
+	}
+
+	log.Printf("[%s] Rebuilt order store from %d events", cfg.ServiceName, replayed)
+	return nil
+}
+
+// scanOrders returns the destinations of every stored order and the IDs of
+// the orders with changes made through the API, which the enriched topic
+// cannot replay.
+func scanOrders(ctx context.Context, store orderstore.Store) (map[string][]string, []string, error) {
+	destinations := make(map[string][]string)
+	var mutated []string
+	query := orderstore.Query{Limit: orderstore.MaxLimit}
+	for {
+		page, err := store.List(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, order := range page.Orders {
+			if len(order.Destinations) > 0 {
+				destinations[order.ID] = order.Destinations
+			}
+			entries, err := store.History(ctx, order.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.EventType, "api.") {
+					mutated = append(mutated, order.ID)
+					break
+				}
+			}
+		}
+		if page.NextCursor == "" {
+			return destinations, mutated, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func discardOutbox(ctx context.Context, store orderstore.Store) error {
+	for {
+		messages, err := store.PendingOutbox(ctx, outboxBatchSize)
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+		ids := make([]int64, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		if err := store.MarkOutboxSent(ctx, ids); err != nil {
+			return err
+		}
+	}
+}
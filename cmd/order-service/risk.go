@@ -0,0 +1,22 @@
+package main
+
+import "ecommerce-platform/internal/models"
+
+// applyRisk copies the score and reasons of the risk enrichment stage onto
+// the order.
+func applyRisk(order *models.Order, enrichedData map[string]interface{}) {
+	risk, ok := enrichedData["risk"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if score, ok := risk["score"].(float64); ok {
+		order.RiskScore = score
+	}
+	if reasons, ok := risk["reasons"].([]interface{}); ok {
+		for _, reason := range reasons {
+			if s, ok := reason.(string); ok {
+				order.RiskReasons = append(order.RiskReasons, s)
+			}
+		}
+	}
+}
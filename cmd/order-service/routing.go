@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+)
+
+// routingRules decide which systems receive an order. Every matching rule
+// adds its destinations, in rule order, until a rule with Stop matches. The
+// origin platform is added first unless IncludeOrigin is false, and is the
+// only destination when no rule matches.
+type routingRules struct {
+	IncludeOrigin *bool         `json:"include_origin,omitempty"`
+	Rules         []routingRule `json:"rules"`
+}
+
+type routingRule struct {
+	Name         string       `json:"name"`
+	Match        routingMatch `json:"match"`
+	Destinations []string     `json:"destinations"`
+	Stop         bool         `json:"stop,omitempty"`
+}
+
+// routingMatch conditions are ANDed; empty conditions match everything.
+// SKUs accept a trailing "*" wildcard and match when any line item does.
+// InStock requires catalog stock to cover every line item.
+type routingMatch struct {
+	Platforms []string `json:"platforms,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
+	Countries []string `json:"countries,omitempty"`
+	SKUs      []string `json:"skus,omitempty"`
+	MinTotal  *float64 `json:"min_total,omitempty"`
+	MaxTotal  *float64 `json:"max_total,omitempty"`
+	InStock   *bool    `json:"in_stock,omitempty"`
+}
+
+func loadRoutingRules(path string) (routingRules, error) {
+	var rules routingRules
+	if path == "" {
+		return rules, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, rule := range rules.Rules {
+		if len(rule.Destinations) == 0 {
+			return rules, fmt.Errorf("routing rule %q has no destinations", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// router stamps orders with their destinations. Orders in kept, set while
+// rebuilding the store, get back the destinations they had before instead.
+type router struct {
+	rules routingRules
+	stock *stockIndex
+	kept  map[string][]string
+}
+
+func (r *router) route(order models.Order) []string {
+	if kept, ok := r.kept[order.ID]; ok {
+		return kept
+	}
+
+	var destinations []string
+	add := func(destination string) {
+		for _, existing := range destinations {
+			if existing == destination {
+				return
+			}
+		}
+		destinations = append(destinations, destination)
+	}
+
+	if r.rules.IncludeOrigin == nil || *r.rules.IncludeOrigin {
+		add(order.Platform)
+	}
+	for _, rule := range r.rules.Rules {
+		if !r.matches(rule.Match, order) {
+			continue
+		}
+		for _, destination := range rule.Destinations {
+			add(destination)
+		}
+		if rule.Stop {
+			break
+		}
+	}
+
+	if len(destinations) == 0 {
+		add(order.Platform)
+	}
+	return destinations
+}
+
+func (r *router) matches(match routingMatch, order models.Order) bool {
+	if len(match.Platforms) > 0 && !containsFold(match.Platforms, order.Platform) {
+		return false
+	}
+	if len(match.Channels) > 0 && !containsFold(match.Channels, order.Channel) {
+		return false
+	}
+	if len(match.Countries) > 0 && !containsFold(match.Countries, orderCountry(order)) {
+		return false
+	}
+	if match.MinTotal != nil && order.Total < *match.MinTotal {
+		return false
+	}
+	if match.MaxTotal != nil && order.Total > *match.MaxTotal {
+		return false
+	}
+	if len(match.SKUs) > 0 && !anySKUMatches(match.SKUs, order.Items) {
+		return false
+	}
+	if match.InStock != nil && r.inStock(order.Items) != *match.InStock {
+		return false
+	}
+	return true
+}
+
+func (r *router) inStock(items []models.Item) bool {
+	if r.stock == nil || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if available, ok := r.stock.available(item.SKU); !ok || available < item.Quantity {
+			return false
+		}
+	}
+	return true
+}
+
+func orderCountry(order models.Order) string {
+	if order.Customer == nil {
+		return ""
+	}
+	for _, address := range []*models.Address{order.Customer.ShippingAddress, order.Customer.BillingAddress} {
+		if address != nil && address.Country != "" {
+			return address.Country
+		}
+	}
+	return ""
+}
+
+func anySKUMatches(patterns []string, items []models.Item) bool {
+	for _, item := range items {
+		if item.SKU == "" {
+			continue
+		}
+		for _, pattern := range patterns {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(item.SKU, prefix) {
+				return true
+			}
+			if pattern == item.SKU {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// stockIndex is the stock per SKU from the compacted catalog topic,
+// including the SKUs of product variants.
+type stockIndex struct {
+	mu    sync.RWMutex
+	skus  map[string][]string
+	stock map[string]int
+}
+
+func newStockIndex() *stockIndex {
+	return &stockIndex{skus: make(map[string][]string), stock: make(map[string]int)}
+}
+
+func (s *stockIndex) available(sku string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stock, ok := s.stock[sku]
+	return stock, ok
+}
+
+func (s *stockIndex) put(key string, product models.CatalogProduct) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+	if product.SKU != "" {
+		s.skus[key] = append(s.skus[key], product.SKU)
+		s.stock[product.SKU] = product.Stock
+	}
+	for _, variant := range product.Variants {
+		if variant.SKU != "" {
+			s.skus[key] = append(s.skus[key], variant.SKU)
+			s.stock[variant.SKU] = variant.Stock
+		}
+	}
+}
+
+func (s *stockIndex) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+}
+
+func (s *stockIndex) removeLocked(key string) {
+	for _, sku := range s.skus[key] {
+		delete(s.stock, sku)
+	}
+	delete(s.skus, key)
+}
+
+func materializeStock(ctx context.Context, consumer *kafka.Consumer, index *stockIndex) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			key := string(msg.Key)
+			if len(msg.Value) == 0 || string(msg.Value) == "null" {
+				index.remove(key)
+				continue
+			}
+
+			var product models.CatalogProduct
+			if err := json.Unmarshal(msg.Value, &product); err != nil {
+				log.Printf("[order-service] Catalog unmarshal error: %v", err)
+				continue
+			}
+			index.put(key, product)
+		}
+	}
+}
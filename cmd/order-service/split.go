@@ -0,0 +1,474 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+)
+
+var errInvalidSplit = errors.New("invalid split or merge")
+
+// splittable lists the statuses in which nothing has shipped yet.
+var splittable = map[string]bool{"pending": true, "paid": true, "on_hold": true}
+
+type splitRequest struct {
+	Children []splitChild `json:"children"`
+}
+
+// splitChild is one child order: the parent items it takes, the MSI source
+// that ships them and, optionally, where the child is routed. Children are
+// routed like the parent when Destinations is empty.
+type splitChild struct {
+	LocationID   string        `json:"location_id"`
+	Destinations []string      `json:"destinations,omitempty"`
+	Items        []models.Item `json:"items"`
+}
+
+type mergeRequest struct {
+	OrderIDs     []string `json:"order_ids"`
+	LocationID   string   `json:"location_id"`
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+// splitOrder serves POST /orders/{id}/split. Every parent line item must be
+// allocated to exactly one child in full or in part, and the children
+// together must take the whole quantity.
+func splitOrder(m *mutator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req splitRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Children) < 2 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "at least two children required")
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		source := "api:" + r.Header.Get("X-Client-ID")
+		first, err := m.nextChildIndex(r.Context(), id)
+		if err != nil {
+			writeMutationError(w, id, err)
+			return
+		}
+
+		var children []models.Order
+		parent, err := m.mutate(r.Context(), id, r.Header.Get("If-Match"), false, apiChange("split", source), func(order *models.Order) ([]models.StatusTransition, error) {
+			var err error
+			children, err = planSplit(*order, req.Children, first, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			order.ChildIDs = nil
+			for _, child := range children {
+				order.ChildIDs = append(order.ChildIDs, child.ID)
+			}
+			return nil, nil
+		})
+		if err != nil {
+			writeMutationError(w, id, err)
+			return
+		}
+
+		created, err := m.createChildren(r.Context(), children, source)
+		if err != nil {
+			m.abandonSplit(r.Context(), []string{id}, parent.ChildIDs, created, source)
+			writeMutationError(w, id, err)
+			return
+		}
+
+		log.Printf("[order-service] Split order %s into %v for %s", parent.ID, parent.ChildIDs, source)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"parent": parent, "children": created})
+	}
+}
+
+// mergeOrders serves POST /orders/merge: orders of one platform shipping to
+// the same address become a single child order shipped together.
+func mergeOrders(m *mutator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mergeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.OrderIDs) < 2 {
+			writeError(w, http.StatusBadRequest, "invalid_request", "at least two order_ids required")
+			return
+		}
+
+		parents := make([]models.Order, 0, len(req.OrderIDs))
+		for _, id := range req.OrderIDs {
+			order, err := m.store.Get(r.Context(), id)
+			if err != nil {
+				writeMutationError(w, id, err)
+				return
+			}
+			parents = append(parents, order)
+		}
+
+		merged, err := planMerge(parents, req, time.Now())
+		if err != nil {
+			writeMutationError(w, req.OrderIDs[0], err)
+			return
+		}
+
+		// Link every parent first, re-checking it under optimistic
+		// concurrency, so that no order ends up in two merges.
+		source := "api:" + r.Header.Get("X-Client-ID")
+		childIDs := []string{merged.ID}
+		for i, parent := range parents {
+			parents[i], err = m.mutate(r.Context(), parent.ID, "", false, apiChange("merge", source), func(order *models.Order) ([]models.StatusTransition, error) {
+				if err := checkSplittable(*order); err != nil {
+					return nil, err
+				}
+				order.ChildIDs = childIDs
+				return nil, nil
+			})
+			if err != nil {
+				m.abandonSplit(r.Context(), req.OrderIDs[:i], childIDs, nil, source)
+				writeMutationError(w, parent.ID, err)
+				return
+			}
+		}
+
+		created, err := m.createChildren(r.Context(), []models.Order{merged}, source)
+		if err != nil {
+			m.abandonSplit(r.Context(), req.OrderIDs, childIDs, created, source)
+			writeMutationError(w, merged.ID, err)
+			return
+		}
+
+		log.Printf("[order-service] Merged %v into %s for %s", req.OrderIDs, merged.ID, source)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"parents": parents, "child": created[0]})
+	}
+}
+
+func checkSplittable(order models.Order) error {
+	switch {
+	case len(order.ChildIDs) > 0:
+		return fmt.Errorf("%w: order %s already has child orders", errInvalidSplit, order.ID)
+	case len(order.ParentIDs) > 0:
+		return fmt.Errorf("%w: order %s is a child order", errInvalidSplit, order.ID)
+	case !splittable[order.Status]:
+		return fmt.Errorf("%w: order %s is %s", errInvalidSplit, order.ID, order.Status)
+	}
+	return nil
+}
+
+// planSplit builds the child orders, numbered from first+1. Tax, discount
+// and shipping are shared out in proportion to each child's item subtotal.
+func planSplit(parent models.Order, requested []splitChild, first int, now time.Time) ([]models.Order, error) {
+	if err := checkSplittable(parent); err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]int)
+	prices := make(map[string]models.Item)
+	for _, item := range parent.Items {
+		remaining[item.Key()] += item.Quantity
+		prices[item.Key()] = item
+	}
+
+	children := make([]models.Order, len(requested))
+	subtotals := make([]float64, len(requested))
+	for i, req := range requested {
+		if len(req.Items) == 0 {
+			return nil, fmt.Errorf("%w: child %d has no items", errInvalidSplit, i+1)
+		}
+
+		child := models.Order{
+			ID:           splitChildID(parent.ID, first+i+1),
+			Platform:     parent.Platform,
+			Channel:      parent.Channel,
+			Destinations: req.Destinations,
+			UserID:       parent.UserID,
+			Customer:     parent.Customer,
+			ParentIDs:    []string{parent.ID},
+			Relation:     "split",
+			LocationID:   req.LocationID,
+			CreatedAt:    now,
+		}
+		if len(child.Destinations) == 0 {
+			child.Destinations = parent.Destinations
+		}
+		for _, item := range req.Items {
+			if item.Quantity <= 0 || item.Quantity > remaining[item.Key()] {
+				return nil, fmt.Errorf("%w: product %s is over-allocated", errInvalidSplit, item.Key())
+			}
+			remaining[item.Key()] -= item.Quantity
+
+			line := prices[item.Key()]
+			line.Quantity = item.Quantity
+			line.Fulfilled = 0
+			child.Items = append(child.Items, line)
+			subtotals[i] += line.Price * float64(line.Quantity)
+		}
+		children[i] = child
+	}
+	for product, quantity := range remaining {
+		if quantity > 0 {
+			return nil, fmt.Errorf("%w: %d of product %s not allocated", errInvalidSplit, quantity, product)
+		}
+	}
+
+	taxes := allocate(parent.Tax, subtotals)
+	discounts := allocate(parent.Discount, subtotals)
+	shipping := allocate(parent.Shipping, subtotals)
+	for i := range children {
+		children[i].Tax = taxes[i]
+		children[i].Discount = discounts[i]
+		children[i].Shipping = shipping[i]
+		children[i].Total = round2(subtotals[i] + taxes[i] + shipping[i] - discounts[i])
+		children[i].Balance = children[i].Total
+		children[i].Status = parent.Status
+		if parent.Status == "on_hold" {
+			children[i].Status = parent.HeldFrom
+		}
+	}
+	return children, nil
+}
+
+// planMerge builds the merged child order. Its items remember the order
+// they came from so that fulfillment can be rolled up to each parent.
+func planMerge(parents []models.Order, req mergeRequest, now time.Time) (models.Order, error) {
+	first := parents[0]
+	merged := models.Order{
+		ID:           "merge-" + newID(),
+		Platform:     first.Platform,
+		Channel:      first.Channel,
+		Destinations: req.Destinations,
+		UserID:       first.UserID,
+		Customer:     first.Customer,
+		Relation:     "merge",
+		LocationID:   req.LocationID,
+		Status:       "paid",
+		CreatedAt:    now,
+	}
+	if len(merged.Destinations) == 0 {
+		merged.Destinations = first.Destinations
+	}
+
+	for _, parent := range parents {
+		if err := checkSplittable(parent); err != nil {
+			return merged, err
+		}
+		if parent.Platform != first.Platform {
+			return merged, fmt.Errorf("%w: orders come from different platforms", errInvalidSplit)
+		}
+		if shippingAddress(parent) != shippingAddress(first) {
+			return merged, fmt.Errorf("%w: order %s ships to a different address", errInvalidSplit, parent.ID)
+		}
+
+		merged.ParentIDs = append(merged.ParentIDs, parent.ID)
+		for _, item := range parent.Items {
+			item.OrderID = parent.ID
+			item.Fulfilled = 0
+			merged.Items = append(merged.Items, item)
+		}
+		merged.Tax += parent.Tax
+		merged.Discount += parent.Discount
+		merged.Shipping += parent.Shipping
+		merged.Total += parent.Total
+		if status := parent.Status; status == "pending" || (status == "on_hold" && parent.HeldFrom == "pending") {
+			merged.Status = "pending"
+		}
+	}
+
+	merged.Tax = round2(merged.Tax)
+	merged.Discount = round2(merged.Discount)
+	merged.Shipping = round2(merged.Shipping)
+	merged.Total = round2(merged.Total)
+	merged.Balance = merged.Total
+	return merged, nil
+}
+
+func shippingAddress(order models.Order) models.Address {
+	if order.Customer == nil || order.Customer.ShippingAddress == nil {
+		return models.Address{}
+	}
+	return *order.Customer.ShippingAddress
+}
+
+// createChildren stores and publishes new child orders.
+func (m *mutator) createChildren(ctx context.Context, children []models.Order, source string) ([]models.Order, error) {
+	created := make([]models.Order, 0, len(children))
+	for _, child := range children {
+		child := child
+		order, err := m.mutate(ctx, child.ID, "", true, apiChange(child.Relation, source), func(order *models.Order) ([]models.StatusTransition, error) {
+			if order.Version > 0 {
+				return nil, errOrderExists
+			}
+			status := child.Status
+			*order = child
+			order.Status = ""
+			change, err := transition(order, status, source, "", child.Relation+" of "+fmt.Sprint(child.ParentIDs), time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return []models.StatusTransition{*change}, nil
+		})
+		if err != nil {
+			return created, err
+		}
+		created = append(created, order)
+	}
+	return created, nil
+}
+
+// abandonSplit undoes a split or merge whose children could not all be
+// created, so that it can be retried: the parents are unlinked from
+// childIDs, and the children already created are cancelled since they have
+// been published. It runs even when the request was cancelled.
+func (m *mutator) abandonSplit(ctx context.Context, parentIDs, childIDs []string, created []models.Order, source string) {
+	ctx = context.WithoutCancel(ctx)
+	for _, child := range created {
+		_, err := m.mutate(ctx, child.ID, "", false, apiChange("abandon", source), func(order *models.Order) ([]models.StatusTransition, error) {
+			change, err := transition(order, "cancelled", source, "", child.Relation+" abandoned", time.Now())
+			if err != nil || change == nil {
+				return nil, err
+			}
+			return []models.StatusTransition{*change}, nil
+		})
+		if err != nil {
+			log.Printf("[order-service] Failed to cancel abandoned child order %s: %v", child.ID, err)
+		}
+	}
+	for _, id := range parentIDs {
+		_, err := m.mutate(ctx, id, "", false, apiChange("abandon", source), func(order *models.Order) ([]models.StatusTransition, error) {
+			if fmt.Sprint(order.ChildIDs) != fmt.Sprint(childIDs) {
+				return nil, fmt.Errorf("%w: order %s was linked to %v since", errInvalidSplit, id, order.ChildIDs)
+			}
+			order.ChildIDs = nil
+			return nil, nil
+		})
+		if err != nil {
+			log.Printf("[order-service] Failed to unlink order %s from %v: %v", id, childIDs, err)
+		}
+	}
+}
+
+// nextChildIndex is the number of split children the order has had, so
+// that a split retried after being abandoned does not reuse their IDs.
+func (m *mutator) nextChildIndex(ctx context.Context, id string) (int, error) {
+	for n := 0; ; n++ {
+		_, err := m.store.Get(ctx, splitChildID(id, n+1))
+		if errors.Is(err, orderstore.ErrNotFound) {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func splitChildID(parentID string, n int) string {
+	return fmt.Sprintf("%s-S%d", parentID, n)
+}
+
+// rollUp reflects a child order's progress on its parents: fulfilled
+// quantities are summed over all children of a parent, and the parent moves
+// to fulfilled or completed once every child has.
+func rollUp(ctx context.Context, store orderstore.Store, child models.Order, change orderstore.Change) {
+	for _, parentID := range child.ParentIDs {
+		var changes []models.StatusTransition
+		_, err := orderstore.Update(ctx, store, parentID, change, func(parent *models.Order) ([]orderstore.Message, error) {
+			changes = nil
+			if parent.Version == 0 {
+				return nil, orderstore.ErrNotFound
+			}
+
+			fulfilled := make(map[string]int)
+			completed := true
+			for _, childID := range parent.ChildIDs {
+				sibling := child
+				if childID != child.ID {
+					var err error
+					if sibling, err = store.Get(ctx, childID); err != nil {
+						return nil, err
+					}
+				}
+				if sibling.Status != "completed" {
+					completed = false
+				}
+				for _, item := range sibling.Items {
+					if item.OrderID == "" || item.OrderID == parent.ID {
+						fulfilled[item.Key()] += item.Fulfilled
+					}
+				}
+			}
+
+			// The children carry the fulfillments, so the parent only
+			// keeps their sum, under the empty ID.
+			parent.Fulfillments = map[string]map[string]int{"": fulfilled}
+			setFulfilled(parent)
+			var shipped int
+			for _, item := range parent.Items {
+				shipped += item.Fulfilled
+			}
+
+			outbox := []orderstore.Message{orderstore.OrderMessage("orders")}
+			if parent.Status == "on_hold" || parent.Status == "cancelled" || parent.Status == "refunded" {
+				return outbox, nil
+			}
+			var steps []string
+			switch {
+			case isFullyFulfilled(*parent) && completed:
+				steps = []string{"fulfilled", "completed"}
+			case isFullyFulfilled(*parent):
+				steps = []string{"fulfilled"}
+			case shipped > 0:
+				steps = []string{"partially_fulfilled"}
+			}
+			// Steps the parent has already passed are rejected by the state
+			// machine and skipped.
+			for _, step := range steps {
+				if next, err := transition(parent, step, "rollup:"+child.ID, change.EventID, "", change.At); err == nil && next != nil {
+					changes = append(changes, *next)
+				}
+			}
+			return append(transitionMessages(changes), outbox...), nil
+		})
+		if err != nil {
+			log.Printf("[order-service] Failed to roll up %s to parent %s: %v", child.ID, parentID, err)
+			continue
+		}
+
+		logTransitions(changes)
+	}
+}
+
+// allocate shares amount out in proportion to weights, rounded to cents,
+// with the rounding remainder going to the last share so that the shares
+// always add up to amount.
+func allocate(amount float64, weights []float64) []float64 {
+	shares := make([]float64, len(weights))
+	var total float64
+	for _, weight := range weights {
+		total += weight
+	}
+	if amount == 0 || len(weights) == 0 {
+		return shares
+	}
+
+	var assigned float64
+	for i, weight := range weights {
+		if i == len(weights)-1 {
+			shares[i] = round2(amount - assigned)
+			break
+		}
+		if total == 0 {
+			shares[i] = round2(amount / float64(len(weights)))
+		} else {
+			shares[i] = round2(amount * weight / total)
+		}
+		assigned += shares[i]
+	}
+	return shares
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
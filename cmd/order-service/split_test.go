@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/orderstore"
+)
+
+var errStoreDown = errors.New("store down")
+
+// failingStore fails every write of the orders failing matches.
+type failingStore struct {
+	orderstore.Store
+	failing func(id string) bool
+}
+
+func (s *failingStore) Put(ctx context.Context, order *models.Order, change orderstore.Change, outbox []orderstore.Message) error {
+	if s.failing != nil && s.failing(order.ID) {
+		return errStoreDown
+	}
+	return s.Store.Put(ctx, order, change, outbox)
+}
+
+func newSplitTest(t *testing.T) (*failingStore, http.Handler) {
+	t.Helper()
+	bolt, err := orderstore.OpenBolt(filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	store := &failingStore{Store: bolt}
+	m := &mutator{store: store, router: &router{stock: newStockIndex()}}
+	router := mux.NewRouter()
+	router.HandleFunc("/orders/{id}/split", splitOrder(m)).Methods("POST")
+	router.HandleFunc("/orders/merge", mergeOrders(m)).Methods("POST")
+	return store, router
+}
+
+func putOrder(t *testing.T, store orderstore.Store, order models.Order) {
+	t.Helper()
+	order.Platform = "shopify"
+	order.Status = "paid"
+	order.Destinations = []string{"shopify"}
+	order.CreatedAt = time.Now()
+	if err := store.Put(context.Background(), &order, orderstore.Change{EventType: "order.created", Source: "test", At: time.Now()}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func post(handler http.Handler, path, body string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return recorder.Code
+}
+
+func storedOrder(t *testing.T, store orderstore.Store, id string) models.Order {
+	t.Helper()
+	order, err := store.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("order %s: %v", id, err)
+	}
+	return order
+}
+
+func TestSplitUndoneWhenChildFails(t *testing.T) {
+	store, handler := newSplitTest(t)
+	putOrder(t, store, models.Order{ID: "O-1", Total: 30, Items: []models.Item{
+		{ProductID: "a", Quantity: 1, Price: 10},
+		{ProductID: "b", Quantity: 2, Price: 10},
+	}})
+
+	body := `{"children": [{"items": [{"product_id": "a", "quantity": 1}]}, {"items": [{"product_id": "b", "quantity": 2}]}]}`
+	store.failing = func(id string) bool { return id == "O-1-S2" }
+	if code := post(handler, "/orders/O-1/split", body); code != http.StatusInternalServerError {
+		t.Fatalf("split with failing child: status %d, want 500", code)
+	}
+
+	if parent := storedOrder(t, store, "O-1"); len(parent.ChildIDs) != 0 {
+		t.Fatalf("parent still linked to %v after the split failed", parent.ChildIDs)
+	}
+	if child := storedOrder(t, store, "O-1-S1"); child.Status != "cancelled" {
+		t.Fatalf("child created before the failure is %s, want cancelled", child.Status)
+	}
+
+	store.failing = nil
+	if code := post(handler, "/orders/O-1/split", body); code != http.StatusCreated {
+		t.Fatalf("retried split: status %d, want 201", code)
+	}
+	if parent := storedOrder(t, store, "O-1"); strings.Join(parent.ChildIDs, ",") != "O-1-S2,O-1-S3" {
+		t.Fatalf("parent children after retry = %v, want O-1-S2 and O-1-S3", parent.ChildIDs)
+	}
+}
+
+func TestMergeUndoneWhenChildFails(t *testing.T) {
+	store, handler := newSplitTest(t)
+	putOrder(t, store, models.Order{ID: "O-1", Total: 10, Items: []models.Item{{ProductID: "a", Quantity: 1, Price: 10}}})
+	putOrder(t, store, models.Order{ID: "O-2", Total: 10, Items: []models.Item{{ProductID: "b", Quantity: 1, Price: 10}}})
+
+	body := `{"order_ids": ["O-1", "O-2"]}`
+	store.failing = func(id string) bool { return strings.HasPrefix(id, "merge-") }
+	if code := post(handler, "/orders/merge", body); code != http.StatusInternalServerError {
+		t.Fatalf("merge with failing child: status %d, want 500", code)
+	}
+	for _, id := range []string{"O-1", "O-2"} {
+		if parent := storedOrder(t, store, id); len(parent.ChildIDs) != 0 {
+			t.Fatalf("order %s still linked to %v after the merge failed", id, parent.ChildIDs)
+		}
+	}
+
+	store.failing = nil
+	if code := post(handler, "/orders/merge", body); code != http.StatusCreated {
+		t.Fatalf("retried merge: status %d, want 201", code)
+	}
+}
+
+func TestMergeUndoneWhenParentFails(t *testing.T) {
+	store, handler := newSplitTest(t)
+	putOrder(t, store, models.Order{ID: "O-1", Total: 10, Items: []models.Item{{ProductID: "a", Quantity: 1, Price: 10}}})
+	putOrder(t, store, models.Order{ID: "O-2", Total: 10, Items: []models.Item{{ProductID: "b", Quantity: 1, Price: 10}}})
+
+	store.failing = func(id string) bool { return id == "O-2" }
+	if code := post(handler, "/orders/merge", `{"order_ids": ["O-1", "O-2"]}`); code != http.StatusInternalServerError {
+		t.Fatalf("merge with failing parent: status %d, want 500", code)
+	}
+	if parent := storedOrder(t, store, "O-1"); len(parent.ChildIDs) != 0 {
+		t.Fatalf("order O-1 still linked to %v after the merge failed", parent.ChildIDs)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+var errInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses each status may move to. Orders normally
+// go pending → paid → fulfilled → completed; cancelled, refunded and on_hold
+// branch off that path. refunded is terminal.
+var transitions = map[string][]string{
+	"pending":             {"paid", "partially_fulfilled", "fulfilled", "cancelled", "on_hold"},
+	"paid":                {"partially_fulfilled", "fulfilled", "partially_refunded", "refunded", "cancelled", "on_hold"},
+	"partially_fulfilled": {"fulfilled", "partially_refunded", "refunded", "cancelled", "on_hold"},
+	"fulfilled":           {"completed", "partially_refunded", "refunded", "on_hold"},
+	"completed":           {"partially_refunded", "refunded"},
+	"partially_refunded":  {"partially_fulfilled", "fulfilled", "completed", "refunded", "cancelled"},
+	"cancelled":           {"partially_refunded", "refunded"},
+	"on_hold":             {"pending", "paid", "partially_fulfilled", "fulfilled", "cancelled"},
+	"refunded":            {},
+}
+
+// statusAliases maps platform status names onto the lifecycle statuses.
+var statusAliases = map[string]string{
+	"open":       "pending",
+	"processing": "paid",
+	"authorized": "pending",
+	"canceled":   "cancelled",
+	"shipped":    "fulfilled",
+	"delivered":  "completed",
+	"complete":   "completed",
+	"closed":     "completed",
+	"voided":     "cancelled",
+}
+
+// normalizeStatus returns the lifecycle status for a platform status, or ""
+// when the status is not recognised.
+func normalizeStatus(status string) string {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if alias, ok := statusAliases[status]; ok {
+		return alias
+	}
+	if _, ok := transitions[status]; ok {
+		return status
+	}
+	return ""
+}
+
+func canTransition(from, to string) bool {
+	if from == "" {
+		_, ok := transitions[to]
+		return ok
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves order to status to and records the change. Moving to the
+// current status is a no-op and returns nil. Entering on_hold remembers the
+// previous status so that a release can return to it.
+func transition(order *models.Order, to, source, eventID, reason string, at time.Time) (*models.StatusTransition, error) {
+	from := order.Status
+	if from == to {
+		return nil, nil
+	}
+	if !canTransition(from, to) {
+		return nil, fmt.Errorf("%w: %s → %s", errInvalidTransition, from, to)
+	}
+
+	switch {
+	case to == "on_hold":
+		order.HeldFrom = from
+	case from == "on_hold":
+		order.HeldFrom = ""
+	}
+
+	change := models.StatusTransition{
+		OrderID:  order.ID,
+		Platform: order.Platform,
+		From:     from,
+		To:       to,
+		Source:   source,
+		EventID:  eventID,
+		Reason:   reason,
+		At:       at,
+	}
+	order.Status = to
+	order.Transitions = append(order.Transitions, change)
+	return &change, nil
+}
@@ -72,6 +72,12 @@ func processReports(ctx context.Context, consumer *kafka.Consumer, stats map[str
 				continue
 			}
 
+			// Split and merged child orders re-package their parents' items
+			// and would count the same sale twice.
+			if len(order.ParentIDs) > 0 {
+				continue
+			}
+
 			stats[order.Platform]++
 			log.Printf("[report-service] Updated stats for %s: %d orders", order.Platform, stats[order.Platform])
 		}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/saga"
+	"ecommerce-platform/internal/state"
+)
+
+func main() {
+	cfg := config.Load()
+	cfg.ServiceName = "saga-service"
+
+	steps, err := saga.ParseSteps(cfg.SagaSteps)
+	if err != nil {
+		log.Fatalf("[%s] Failed to parse saga steps: %v", cfg.ServiceName, err)
+	}
+
+	db, err := state.Open(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open state store: %v", cfg.ServiceName, err)
+	}
+	defer db.Close()
+
+	sagas, err := db.Store("sagas")
+	if err != nil {
+		log.Fatalf("[%s] Failed to open sagas: %v", cfg.ServiceName, err)
+	}
+
+	commands := kafka.NewProducer(cfg.KafkaBroker, saga.CommandsTopic)
+	defer commands.Close()
+
+	o := &orchestrator{sagas: sagas, commands: commands, steps: steps, timeout: cfg.SagaStepTimeout}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/sagas", listSagas(o)).Methods("GET")
+	router.HandleFunc("/sagas/{order_id}", getSaga(o)).Methods("GET")
+	router.HandleFunc("/health", healthCheck).Methods("GET")
+
+	server := &http.Server{
+		Addr:    ":" + cfg.HTTPPort,
+		Handler: router,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go func() {
+		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	go consumeOrders(ctx, cfg, o)
+	go consumeReplies(ctx, cfg, o)
+	go watchTimeouts(ctx, o)
+
+	<-ctx.Done()
+	log.Printf("[%s] Shutting down...", cfg.ServiceName)
+	server.Shutdown(context.Background())
+}
+
+// consumeOrders starts a saga for every new order on the orders topic.
+func consumeOrders(ctx context.Context, cfg config.Config, o *orchestrator) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, "orders", "saga-service-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var order models.Order
+			if err := json.Unmarshal(msg.Value, &order); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+			if err := o.handleOrder(ctx, order); err != nil {
+				log.Printf("[%s] Failed to handle order %s: %v", cfg.ServiceName, order.ID, err)
+			}
+		}
+	}
+}
+
+func consumeReplies(ctx context.Context, cfg config.Config, o *orchestrator) {
+	consumer := kafka.NewConsumer(cfg.KafkaBroker, saga.RepliesTopic, "saga-service-replies-group")
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var reply saga.Reply
+			if err := json.Unmarshal(msg.Value, &reply); err != nil {
+				log.Printf("[%s] Unmarshal error: %v", cfg.ServiceName, err)
+				continue
+			}
+			if err := o.handleReply(ctx, reply); err != nil {
+				log.Printf("[%s] Failed to handle reply for order %s: %v", cfg.ServiceName, reply.SagaID, err)
+			}
+		}
+	}
+}
+
+func watchTimeouts(ctx context.Context, o *orchestrator) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := o.checkTimeouts(ctx); err != nil {
+				log.Printf("[saga-service] Timeout check failed: %v", err)
+			}
+		}
+	}
+}
+
+func listSagas(o *orchestrator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sagas, err := o.list(r.URL.Query().Get("status"))
+		if err != nil {
+			log.Printf("[saga-service] List failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sagas)
+	}
+}
+
+func getSaga(o *orchestrator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := o.get(mux.Vars(r)["order_id"])
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, "Saga not found", http.StatusNotFound)
+		case err != nil:
+			log.Printf("[saga-service] Get failed: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, s)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/saga"
+	"ecommerce-platform/internal/state"
+)
+
+// maxAttempts is how often a command is sent before its step times out.
+const maxAttempts = 3
+
+var errNotFound = errors.New("saga not found")
+
+const (
+	sagaRunning      = "running"
+	sagaCompleted    = "completed"
+	sagaCompensating = "compensating"
+	sagaCompensated  = "compensated"
+	sagaFailed       = "failed"
+
+	stepPending      = "pending"
+	stepRunning      = "running"
+	stepSucceeded    = "succeeded"
+	stepFailed       = "failed"
+	stepTimedOut     = "timed_out"
+	stepCompensating = "compensating"
+	stepCompensated  = "compensated"
+)
+
+// sagaState is the persisted progress of one order's placement saga. Steps
+// run one at a time in order; Current is the step waiting for a reply, or
+// the step being compensated while Status is compensating.
+type sagaState struct {
+	OrderID         string       `json:"order_id"`
+	Status          string       `json:"status"`
+	Reason          string       `json:"reason,omitempty"`
+	CancelRequested bool         `json:"cancel_requested,omitempty"`
+	Current         int          `json:"current"`
+	Steps           []stepState  `json:"steps"`
+	Order           models.Order `json:"order"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+}
+
+type stepState struct {
+	saga.Step
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts,omitempty"`
+	ExternalID string    `json:"external_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Deadline   time.Time `json:"deadline,omitempty"`
+}
+
+type sender interface {
+	Send(ctx context.Context, key string, value interface{}) error
+}
+
+// orchestrator drives placement sagas. State is saved before a command is
+// sent, so after a restart an unanswered command is sent again once its
+// deadline passes.
+type orchestrator struct {
+	mu       sync.Mutex
+	sagas    *state.Store
+	commands sender
+	steps    saga.Definition
+	timeout  time.Duration
+}
+
+// handleOrder starts a saga the first time an order is seen. Later versions
+// of the order are forwarded to the destinations that already have it, and
+// a cancellation undoes the steps that already succeeded.
+func (o *orchestrator) handleOrder(ctx context.Context, order models.Order) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var s sagaState
+	found, err := o.sagas.Get(order.ID, &s)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		if order.Status != "cancelled" {
+			return o.forward(ctx, &s, order)
+		}
+		if s.CancelRequested {
+			return nil
+		}
+		s.CancelRequested = true
+		switch s.Status {
+		case sagaRunning:
+			return o.save(&s)
+		case sagaCompleted:
+			s.Reason = "order cancelled"
+			return o.compensate(ctx, &s, len(s.Steps)-1)
+		default:
+			return o.save(&s)
+		}
+	}
+
+	if order.Status == "cancelled" {
+		return nil
+	}
+	steps := o.steps.Resolve(order)
+	if len(steps) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	s = sagaState{OrderID: order.ID, Status: sagaRunning, Order: order, CreatedAt: now}
+	for _, step := range steps {
+		s.Steps = append(s.Steps, stepState{Step: step, Status: stepPending})
+	}
+	log.Printf("[saga-service] Starting saga for order %s with %d steps", order.ID, len(steps))
+	return o.dispatch(ctx, &s)
+}
+
+// handleReply records the outcome of the current step and moves the saga
+// on. Replies for steps that are no longer waiting, such as a late reply to
+// a retried command, are ignored.
+func (o *orchestrator) handleReply(ctx context.Context, reply saga.Reply) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var s sagaState
+	found, err := o.sagas.Get(reply.SagaID, &s)
+	if err != nil || !found {
+		return err
+	}
+	if reply.Step != s.Current || reply.Step >= len(s.Steps) {
+		return nil
+	}
+	step := &s.Steps[s.Current]
+
+	switch {
+	case s.Status == sagaRunning && step.Status == stepRunning && !reply.Compensating && reply.Action == step.Action:
+		if !reply.OK {
+			step.Status = stepFailed
+			step.Error = reply.Error
+			s.Reason = fmt.Sprintf("%s in %s failed: %s", step.Action, step.Destination, reply.Error)
+			log.Printf("[saga-service] Order %s: %s, compensating", s.OrderID, s.Reason)
+			return o.compensate(ctx, &s, s.Current-1)
+		}
+		step.Status = stepSucceeded
+		step.ExternalID = reply.ExternalID
+		step.Error = ""
+		s.Current++
+		if s.Current < len(s.Steps) {
+			return o.dispatch(ctx, &s)
+		}
+		if s.CancelRequested {
+			s.Reason = "order cancelled"
+			return o.compensate(ctx, &s, len(s.Steps)-1)
+		}
+		s.Status = sagaCompleted
+		log.Printf("[saga-service] Saga for order %s completed", s.OrderID)
+		return o.save(&s)
+
+	case s.Status == sagaCompensating && step.Status == stepCompensating && reply.Compensating:
+		if !reply.OK {
+			step.Error = reply.Error
+			if step.Attempts < maxAttempts {
+				return o.dispatch(ctx, &s)
+			}
+			return o.fail(&s, fmt.Sprintf("%s in %s failed: %s", step.Compensation, step.Destination, reply.Error))
+		}
+		step.Status = stepCompensated
+		step.Error = ""
+		return o.compensate(ctx, &s, s.Current-1)
+	}
+	return nil
+}
+
+// checkTimeouts resends commands whose deadline passed and gives up on a
+// step after maxAttempts. A forward step that timed out may still have
+// been applied, so it is compensated along with the steps before it.
+func (o *orchestrator) checkTimeouts(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	var due []sagaState
+	err := o.sagas.ForEach(func(key string, value []byte) error {
+		var s sagaState
+		if err := json.Unmarshal(value, &s); err != nil {
+			return err
+		}
+		if (s.Status == sagaRunning || s.Status == sagaCompensating) && s.Current < len(s.Steps) &&
+			now.After(s.Steps[s.Current].Deadline) {
+			due = append(due, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		s := &due[i]
+		step := &s.Steps[s.Current]
+		if step.Attempts < maxAttempts {
+			log.Printf("[saga-service] Order %s: no reply from %s, retrying", s.OrderID, step.Destination)
+			err = o.dispatch(ctx, s)
+		} else if s.Status == sagaRunning {
+			step.Status = stepTimedOut
+			step.Error = fmt.Sprintf("no reply after %d attempts", step.Attempts)
+			s.Reason = fmt.Sprintf("%s in %s timed out", step.Action, step.Destination)
+			log.Printf("[saga-service] Order %s: %s, compensating", s.OrderID, s.Reason)
+			err = o.compensate(ctx, s, s.Current)
+		} else {
+			err = o.fail(s, fmt.Sprintf("%s in %s timed out", step.Compensation, step.Destination))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compensate undoes, newest first, the steps up to and including from that
+// succeeded or timed out and have a compensation. The saga is compensated
+// once there is nothing left to undo.
+func (o *orchestrator) compensate(ctx context.Context, s *sagaState, from int) error {
+	s.Status = sagaCompensating
+	for i := from; i >= 0; i-- {
+		step := &s.Steps[i]
+		if step.Compensation == "" || (step.Status != stepSucceeded && step.Status != stepTimedOut) {
+			continue
+		}
+		s.Current = i
+		step.Status = stepCompensating
+		step.Attempts = 0
+		return o.dispatch(ctx, s)
+	}
+
+	s.Status = sagaCompensated
+	log.Printf("[saga-service] Saga for order %s compensated", s.OrderID)
+	return o.save(s)
+}
+
+// forward keeps the saga's copy of the order current, so that steps still
+// to run or compensate use the newest version, and sends the newer version
+// as an update to every destination whose step was sent. Updates are not
+// retried and their replies are ignored.
+func (o *orchestrator) forward(ctx context.Context, s *sagaState, order models.Order) error {
+	if order.Version <= s.Order.Version {
+		return nil
+	}
+	s.Order = order
+	if err := o.save(s); err != nil {
+		return err
+	}
+	if s.Status != sagaRunning && s.Status != sagaCompleted {
+		return nil
+	}
+
+	for i, step := range s.Steps {
+		if step.Status != stepSucceeded && step.Status != stepRunning {
+			continue
+		}
+		cmd := saga.Command{
+			SagaID:      s.OrderID,
+			Step:        i,
+			Attempt:     1,
+			Destination: step.Destination,
+			Action:      saga.UpdateAction,
+			Order:       order,
+			IssuedAt:    time.Now(),
+		}
+		if err := o.commands.Send(ctx, s.OrderID, cmd); err != nil {
+			log.Printf("[saga-service] Failed to send update of order %s to %s: %v", s.OrderID, step.Destination, err)
+		}
+	}
+	return nil
+}
+
+func (o *orchestrator) fail(s *sagaState, reason string) error {
+	s.Status = sagaFailed
+	s.Reason = reason
+	log.Printf("[saga-service] Saga for order %s failed and needs attention: %s", s.OrderID, reason)
+	return o.save(s)
+}
+
+// dispatch sends the command for the current step. A failed send is left
+// to the timeout check to retry.
+func (o *orchestrator) dispatch(ctx context.Context, s *sagaState) error {
+	step := &s.Steps[s.Current]
+	compensating := s.Status == sagaCompensating
+	action := step.Action
+	if compensating {
+		action = step.Compensation
+	} else {
+		step.Status = stepRunning
+	}
+	step.Attempts++
+	step.Deadline = time.Now().Add(o.timeout)
+	if err := o.save(s); err != nil {
+		return err
+	}
+
+	cmd := saga.Command{
+		SagaID:       s.OrderID,
+		Step:         s.Current,
+		Attempt:      step.Attempts,
+		Destination:  step.Destination,
+		Action:       action,
+		Compensating: compensating,
+		Order:        s.Order,
+		IssuedAt:     time.Now(),
+	}
+	if err := o.commands.Send(ctx, s.OrderID, cmd); err != nil {
+		log.Printf("[saga-service] Failed to send %s to %s for order %s: %v", action, step.Destination, s.OrderID, err)
+	}
+	return nil
+}
+
+func (o *orchestrator) save(s *sagaState) error {
+	s.UpdatedAt = time.Now()
+	return o.sagas.Put(s.OrderID, s)
+}
+
+func (o *orchestrator) get(orderID string) (sagaState, error) {
+	var s sagaState
+	found, err := o.sagas.Get(orderID, &s)
+	if err == nil && !found {
+		err = errNotFound
+	}
+	return s, err
+}
+
+// list returns sagas with the given status, or all sagas when status is
+// empty.
+func (o *orchestrator) list(status string) ([]sagaState, error) {
+	sagas := []sagaState{}
+	err := o.sagas.ForEach(func(key string, value []byte) error {
+		var s sagaState
+		if err := json.Unmarshal(value, &s); err != nil {
+			return err
+		}
+		if status == "" || s.Status == status {
+			sagas = append(sagas, s)
+		}
+		return nil
+	})
+	return sagas, err
+}
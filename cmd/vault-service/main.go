@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+
+	"ecommerce-platform/internal/auth"
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/pii"
+)
+
+var tokensBucket = []byte("tokens")
+
+type vault struct {
+	db     *bolt.DB
+	secret []byte
+}
+
+func main() {
+	cfg := config.Load()
+	cfg.ServiceName = "vault-service"
+
+	if cfg.VaultSecret == "" {
+		log.Fatalf("[%s] VAULT_SECRET is required", cfg.ServiceName)
+	}
+	clients, err := auth.ParseClients(cfg.VaultClients)
+	if err != nil {
+		log.Fatalf("[%s] Invalid VAULT_CLIENTS: %v", cfg.ServiceName, err)
+	}
+
+	db, err := bolt.Open(cfg.VaultDBPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Fatalf("[%s] Failed to open vault: %v", cfg.ServiceName, err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	}); err != nil {
+		log.Fatalf("[%s] Failed to initialize vault: %v", cfg.ServiceName, err)
+	}
+
+	v := &vault{db: db, secret: []byte(cfg.VaultSecret)}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/tokenize", clients.RequireGrant("tokenize", v.tokenize)).Methods("POST")
+	router.HandleFunc("/detokenize", clients.RequireGrant("detokenize", v.detokenize)).Methods("POST")
+	router.HandleFunc("/health", healthCheck).Methods("GET")
+
+	server := &http.Server{
+		Addr:    ":" + cfg.HTTPPort,
+		Handler: router,
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	go func() {
+		log.Printf("[%s] Starting server on port %s", cfg.ServiceName, cfg.HTTPPort)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("[%s] Shutting down...", cfg.ServiceName)
+	server.Shutdown(context.Background())
+}
+
+func (v *vault) tokenize(w http.ResponseWriter, r *http.Request) {
+	var req pii.TokenizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	tokens := make([]string, len(req.Values))
+	err := v.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokensBucket)
+		for i, value := range req.Values {
+			tokens[i] = pii.NewToken(v.secret, value.Kind, value.Value)
+			if err := bucket.Put([]byte(tokens[i]), []byte(value.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[vault-service] Tokenize failed: %v", err)
+		http.Error(w, "Failed to tokenize", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[vault-service] Tokenized %d values for %s", len(tokens), r.Header.Get("X-Client-ID"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pii.TokenizeResponse{Tokens: tokens})
+}
+
+func (v *vault) detokenize(w http.ResponseWriter, r *http.Request) {
+	var req pii.DetokenizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	values := make(map[string]string, len(req.Tokens))
+	err := v.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokensBucket)
+		for _, token := range req.Tokens {
+			if value := bucket.Get([]byte(token)); value != nil {
+				values[token] = string(value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[vault-service] Detokenize failed: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[vault-service] Detokenized %d of %d tokens for %s", len(values), len(req.Tokens), r.Header.Get("X-Client-ID"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pii.DetokenizeResponse{Values: values})
+}
+
+func healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+}
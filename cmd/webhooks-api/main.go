@@ -15,6 +15,7 @@ import (
 	"ecommerce-platform/internal/config"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
 )
 
 func main() {
@@ -24,8 +25,17 @@ func main() {
 	producer := kafka.NewProducer(cfg.KafkaBroker, cfg.KafkaTopic)
 	defer producer.Close()
 
+	var tokenizer pii.Tokenizer
+	if cfg.VaultURL != "" {
+		tokenizer = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+	redactor, err := pii.NewRedactor(cfg.PIIFields, cfg.PIIDetectors, tokenizer)
+	if err != nil {
+		log.Fatalf("[%s] Invalid PII configuration: %v", cfg.ServiceName, err)
+	}
+
 	router := mux.NewRouter()
-	router.HandleFunc("/webhooks/{platform}", handleWebhook(producer)).Methods("POST")
+	router.HandleFunc("/webhooks/{platform}", handleWebhook(producer, redactor)).Methods("POST")
 	router.HandleFunc("/health", healthCheck).Methods("GET")
 
 	server := &http.Server{
@@ -48,7 +58,7 @@ func main() {
 	server.Shutdown(context.Background())
 }
 
-func handleWebhook(producer *kafka.Producer) http.HandlerFunc {
+func handleWebhook(producer *kafka.Producer, redactor *pii.Redactor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		platform := vars["platform"]
@@ -59,6 +69,13 @@ func handleWebhook(producer *kafka.Producer) http.HandlerFunc {
 			return
 		}
 
+		// Redact before the payload reaches the shared webhooks topic.
+		if _, err := redactor.Redact(r.Context(), payload); err != nil {
+			log.Printf("[webhooks-api] Failed to redact webhook from %s: %v", platform, err)
+			http.Error(w, "Failed to process webhook", http.StatusServiceUnavailable)
+			return
+		}
+
 		event := models.WebhookEvent{
 			ID:         generateID(),
 			Platform:   platform,
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+)
+
+// catalogIndex is the local materialization of the compacted catalog topic
+// published by catalog-service. Variants are indexed by their platform IDs
+// and SKUs alongside products.
+type catalogIndex struct {
+	mu         sync.RWMutex
+	products   map[string]models.CatalogProduct
+	byPlatform map[string]string
+	bySKU      map[string]string
+}
+
+func newCatalogIndex() *catalogIndex {
+	return &catalogIndex{
+		products:   make(map[string]models.CatalogProduct),
+		byPlatform: make(map[string]string),
+		bySKU:      make(map[string]string),
+	}
+}
+
+func (c *catalogIndex) put(key string, product models.CatalogProduct) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(key)
+	c.products[key] = product
+	for platform, id := range product.PlatformIDs {
+		c.byPlatform[platform+"/"+id] = key
+	}
+	if product.SKU != "" {
+		c.bySKU[product.SKU] = key
+	}
+	for _, variant := range product.Variants {
+		for platform, id := range variant.PlatformIDs {
+			c.byPlatform[platform+"/variant/"+id] = key
+		}
+		c.bySKU[variant.SKU] = key
+	}
+}
+
+func (c *catalogIndex) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *catalogIndex) removeLocked(key string) {
+	old, ok := c.products[key]
+	if !ok {
+		return
+	}
+	for platform, id := range old.PlatformIDs {
+		delete(c.byPlatform, platform+"/"+id)
+	}
+	delete(c.bySKU, old.SKU)
+	for _, variant := range old.Variants {
+		for platform, id := range variant.PlatformIDs {
+			delete(c.byPlatform, platform+"/variant/"+id)
+		}
+		delete(c.bySKU, variant.SKU)
+	}
+	delete(c.products, key)
+}
+
+// lookup resolves a line item to a product and, for configurable products,
+// the variant it was bought as. Platform-native variant and product IDs are
+// tried before the SKU.
+func (c *catalogIndex) lookup(platform, productID, variantID, sku string) (models.CatalogProduct, *models.ProductVariant, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sku = strings.ToUpper(strings.TrimSpace(sku))
+	if variantID != "" {
+		if key, ok := c.byPlatform[platform+"/variant/"+variantID]; ok {
+			product := c.products[key]
+			for _, variant := range product.Variants {
+				if variant.PlatformIDs[platform] == variantID {
+					return product, &variant, true
+				}
+			}
+		}
+	}
+
+	key, ok := c.byPlatform[platform+"/"+productID]
+	if !ok && sku != "" {
+		key, ok = c.bySKU[sku]
+	}
+	if !ok {
+		return models.CatalogProduct{}, nil, false
+	}
+	product := c.products[key]
+	if variant, ok := product.Variant(sku); ok {
+		return product, &variant, true
+	}
+	return product, nil, true
+}
+
+func materializeCatalog(ctx context.Context, consumer *kafka.Consumer, index *catalogIndex) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			key := string(msg.Key)
+			if len(msg.Value) == 0 || string(msg.Value) == "null" {
+				index.remove(key)
+				continue
+			}
+
+			var product models.CatalogProduct
+			if err := json.Unmarshal(msg.Value, &product); err != nil {
+				log.Printf("[webhooks-enrich] Catalog unmarshal error: %v", err)
+				continue
+			}
+			index.put(key, product)
+		}
+	}
+}
+
+// catalogEnricher attaches the canonical catalog product to every order line
+// item and flags the ones the catalog does not know.
+type catalogEnricher struct {
+	index *catalogIndex
+}
+
+func (catalogEnricher) Name() string { return "catalog" }
+
+func (e catalogEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	items := payloadItems(event.Payload)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]map[string]interface{}, 0, len(items))
+	unknown := []string{}
+	for _, item := range items {
+		productID := fieldString(item, "product_id")
+		variantID := fieldString(item, "variant_id")
+		sku := fieldString(item, "sku")
+
+		product, variant, ok := e.index.lookup(event.Platform, productID, variantID, sku)
+		if !ok {
+			if sku == "" {
+				sku = productID
+			}
+			unknown = append(unknown, sku)
+			refs = append(refs, map[string]interface{}{
+				"product_id": productID,
+				"known":      false,
+			})
+			continue
+		}
+
+		ref := map[string]interface{}{
+			"product_id": productID,
+			"variant_id": variantID,
+			"known":      true,
+			"catalog_id": product.ID,
+			"sku":        product.SKU,
+			"name":       product.Name,
+			"cost":       product.Cost,
+		}
+		if variant != nil {
+			ref["sku"] = variant.SKU
+			ref["variant"] = variant.Title
+			ref["variant_options"] = variant.Options
+			if variant.Cost > 0 {
+				ref["cost"] = variant.Cost
+			}
+		}
+		refs = append(refs, ref)
+	}
+
+	return map[string]interface{}{
+		"items":        refs,
+		"unknown_skus": unknown,
+		"all_known":    len(unknown) == 0,
+	}, nil
+}
+
+// payloadItems returns the line items of an order payload.
+func payloadItems(payload map[string]interface{}) []map[string]interface{} {
+	order, ok := payload["order"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	raw, ok := order["items"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	items := make([]map[string]interface{}, 0, len(raw))
+	for _, entry := range raw {
+		if item, ok := entry.(map[string]interface{}); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// fieldString reads an identifier that platforms send either as a string or
+// as a number.
+func fieldString(fields map[string]interface{}, key string) string {
+	switch value := fields[key].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/currency"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/payload"
+)
+
+// defaultMonetaryFields are the amounts of orders and refunds. Product
+// prices are left in the platform's currency, as the catalog pushes them
+// back to platforms that sell in it.
+var defaultMonetaryFields = []string{
+	"order.total",
+	"order.subtotal",
+	"order.tax",
+	"order.shipping",
+	"order.discount",
+	"order.items.price",
+	"refund.amount",
+	"refund.items.price",
+}
+
+// currencyEnricher converts monetary payload fields into the base currency
+// at the rate in effect when the webhook was received.
+type currencyEnricher struct {
+	rates  *currency.Table
+	fields []string
+}
+
+func (currencyEnricher) Name() string { return "currency" }
+
+func (e currencyEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	code := eventCurrency(event.Payload)
+	if code == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(code, e.rates.Base()) {
+		return map[string]interface{}{"base": e.rates.Base(), "converted": false}, nil
+	}
+
+	rate, err := e.rates.Lookup(code, event.ReceivedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	original := make(map[string]interface{})
+	for _, field := range e.fields {
+		payload.Rewrite(event.Payload, field, func(path string, value interface{}) interface{} {
+			amount, ok := value.(float64)
+			if !ok {
+				return value
+			}
+			original[path] = amount
+			return currency.Convert(amount, rate, e.rates.Base())
+		})
+	}
+	if len(original) == 0 {
+		return map[string]interface{}{"base": e.rates.Base(), "converted": false}, nil
+	}
+	setCurrency(event.Payload, e.rates.Base(), original)
+
+	return map[string]interface{}{
+		"base":                e.rates.Base(),
+		"converted":           true,
+		"original_currency":   strings.ToUpper(code),
+		"original_amounts":    original,
+		"rate":                rate.Rate,
+		"rate_version":        rate.Version,
+		"rate_effective_from": rate.EffectiveFrom,
+	}, nil
+}
+
+// eventCurrency returns the currency code of the payload, looking at the
+// top level first and then at each nested object such as "order".
+func eventCurrency(payload map[string]interface{}) string {
+	if code, ok := payload["currency"].(string); ok {
+		return code
+	}
+	for _, value := range payload {
+		if nested, ok := value.(map[string]interface{}); ok {
+			if code, ok := nested["currency"].(string); ok {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// setCurrency sets the currency code at the top level of the payload and in
+// the nested objects holding one of the converted amounts, leaving the
+// currency of objects that were not converted as it was.
+func setCurrency(payload map[string]interface{}, code string, converted map[string]interface{}) {
+	if _, ok := payload["currency"]; ok {
+		payload["currency"] = code
+	}
+	for path := range converted {
+		root, _, _ := strings.Cut(path, ".")
+		if nested, ok := payload[root].(map[string]interface{}); ok {
+			if _, ok := nested["currency"]; ok {
+				nested["currency"] = code
+			}
+		}
+	}
+}
+
+func loadRates(path, base string) (*currency.Table, error) {
+	if path == "" {
+		return currency.NewTable(base), nil
+	}
+	table, err := currency.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(table.Base(), base) {
+		log.Printf("[webhooks-enrich] Rates file base %s overrides BASE_CURRENCY %s", table.Base(), base)
+	}
+	return table, nil
+}
+
+// materializeRates adds every rate published on the rates topic to the
+// table, so new rates apply without a restart.
+func materializeRates(ctx context.Context, consumer *kafka.Consumer, table *currency.Table) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var rate currency.Rate
+			if err := json.Unmarshal(msg.Value, &rate); err != nil {
+				log.Printf("[webhooks-enrich] Rate unmarshal error: %v", err)
+				continue
+			}
+			table.Add(rate)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/enrich"
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/state"
+)
+
+func newDedupEnricher(ctx context.Context, cfg config.Config, db *state.DB) (*dedupEnricher, error) {
+	topic := cfg.ServiceName + "-dedup-changelog"
+	if err := kafka.EnsureCompactedTopic(cfg.KafkaBroker, topic); err != nil {
+		return nil, err
+	}
+
+	// The changelog is written in the background so that the stage, which
+	// holds its lock while writing, stays within its timeout.
+	changelog := state.NewAsyncChangelog(ctx, kafka.NewCompactedProducer(cfg.KafkaBroker, topic), 1024)
+	store, err := db.LoggedStore("dedup", changelog)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := store.Restore(ctx, kafka.TopicReader(cfg.KafkaBroker, topic))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[%s] Restored %d dedup entries from %s", cfg.ServiceName, restored, topic)
+
+	return &dedupEnricher{
+		store:       store,
+		staleAction: cfg.DedupStaleAction,
+		window:      cfg.DedupCollapseWindow,
+	}, nil
+}
+
+// entityState is what the dedup stage remembers about one platform entity.
+type entityState struct {
+	UpdatedAt   time.Time            `json:"updated_at"`
+	Version     float64              `json:"version"`
+	LastEmitted time.Time            `json:"last_emitted"`
+	Pending     *models.WebhookEvent `json:"pending,omitempty"`
+}
+
+// dedupEnricher drops duplicate and stale entity events and collapses
+// bursts of updates: the first update in a window is forwarded at once and
+// the latest one received during the window is forwarded when it closes.
+type dedupEnricher struct {
+	mu          sync.Mutex
+	store       *state.Store
+	staleAction string
+	window      time.Duration
+}
+
+func (*dedupEnricher) Name() string { return "dedup" }
+
+func (e *dedupEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	key, fields, ok := entityKey(event.WebhookEvent)
+	if !ok {
+		return nil, nil
+	}
+	updatedAt, version := entityVersion(fields)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var st entityState
+	found, err := e.store.Get(key, &st)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{"entity": key}
+	now := time.Now()
+
+	if found && st.Pending != nil && st.Pending.ID == event.ID {
+		st.Pending = nil
+		st.LastEmitted = now
+		data["collapsed"] = true
+		return data, e.store.Put(key, st)
+	}
+
+	if found && isStale(st, updatedAt, version) {
+		if e.staleAction != "tag" {
+			return nil, enrich.ErrDrop
+		}
+		data["stale"] = true
+		return data, nil
+	}
+	if found && isDuplicate(st, updatedAt, version) {
+		return nil, enrich.ErrDrop
+	}
+
+	isUpdate := strings.HasSuffix(event.EventType, ".updated")
+	if !found && isUpdate {
+		data["out_of_order"] = true
+	}
+	st.UpdatedAt, st.Version = updatedAt, version
+
+	if found && isUpdate && e.window > 0 && now.Sub(st.LastEmitted) < e.window {
+		pending := event.WebhookEvent
+		st.Pending = &pending
+		if err := e.store.Put(key, st); err != nil {
+			return nil, err
+		}
+		return nil, enrich.ErrDrop
+	}
+
+	st.Pending = nil
+	st.LastEmitted = now
+	return data, e.store.Put(key, st)
+}
+
+// flushLoop re-injects collapsed updates whose window has closed.
+func (e *dedupEnricher) flushLoop(ctx context.Context, events chan<- models.WebhookEvent) {
+	if e.window <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.window / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, pending := range e.due(now) {
+				select {
+				case events <- pending:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *dedupEnricher) due(now time.Time) []models.WebhookEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var due []models.WebhookEvent
+	err := e.store.ForEach(func(key string, value []byte) error {
+		var st entityState
+		if err := json.Unmarshal(value, &st); err != nil {
+			return err
+		}
+		if st.Pending != nil && now.Sub(st.LastEmitted) >= e.window {
+			due = append(due, *st.Pending)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[webhooks-enrich] Failed to scan collapsed updates: %v", err)
+	}
+	return due
+}
+
+// entityKey identifies the entity an event is about, e.g.
+// "shopify/order/1001" for an order.updated webhook.
+func entityKey(event models.WebhookEvent) (string, map[string]interface{}, bool) {
+	entity, _, ok := strings.Cut(event.EventType, ".")
+	if !ok {
+		return "", nil, false
+	}
+	fields, ok := event.Payload[entity].(map[string]interface{})
+	if !ok {
+		return "", nil, false
+	}
+	id := fieldString(fields, "id")
+	if id == "" {
+		return "", nil, false
+	}
+	return event.Platform + "/" + entity + "/" + id, fields, true
+}
+
+func entityVersion(fields map[string]interface{}) (time.Time, float64) {
+	var updatedAt time.Time
+	if value, ok := fields["updated_at"].(string); ok {
+		updatedAt, _ = time.Parse(time.RFC3339, value)
+	}
+	version, _ := fields["version"].(float64)
+	return updatedAt, version
+}
+
+// isStale prefers the explicit version and falls back to updated_at.
+func isStale(st entityState, updatedAt time.Time, version float64) bool {
+	if version > 0 && st.Version > 0 {
+		return version < st.Version
+	}
+	return !updatedAt.IsZero() && updatedAt.Before(st.UpdatedAt)
+}
+
+func isDuplicate(st entityState, updatedAt time.Time, version float64) bool {
+	if version > 0 && st.Version > 0 {
+		return version == st.Version
+	}
+	return !updatedAt.IsZero() && updatedAt.Equal(st.UpdatedAt)
+}
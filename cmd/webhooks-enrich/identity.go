@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	"ecommerce-platform/internal/customer"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/payload"
+)
+
+// identityEnricher resolves the canonical customer of an order from its
+// email, phone and platform customer ID.
+type identityEnricher struct {
+	client *customer.Client
+}
+
+func (identityEnricher) Name() string { return "identity" }
+
+func (e identityEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	identifiers := customerIdentifiers(event.Platform, event.Payload)
+	if len(identifiers) == 0 {
+		return nil, nil
+	}
+
+	resp, err := e.client.Resolve(ctx, identifiers)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"customer_id": resp.CustomerID,
+		"new":         resp.Created,
+	}
+	if len(resp.Merged) > 0 {
+		data["merged"] = resp.Merged
+	}
+	return data, nil
+}
+
+func customerIdentifiers(platform string, p map[string]interface{}) []customer.Identifier {
+	var identifiers []customer.Identifier
+	for _, kind := range []string{"email", "phone"} {
+		if value := riskValue(p, kind); value != "" {
+			identifiers = append(identifiers, customer.Identifier{Type: kind, Value: value})
+		}
+	}
+	for _, path := range []string{"order.customer", "customer"} {
+		if value, ok := payload.Lookup(p, path); ok {
+			if fields, ok := value.(map[string]interface{}); ok {
+				if id := fieldString(fields, "id"); id != "" {
+					identifiers = append(identifiers, customer.Identifier{Type: platform, Value: id})
+					break
+				}
+			}
+		}
+	}
+	return identifiers
+}
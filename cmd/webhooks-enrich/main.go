@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
 	"os/signal"
@@ -10,30 +11,124 @@ import (
 	"time"
 
 	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/customer"
+	"ecommerce-platform/internal/enrich"
 	"ecommerce-platform/internal/kafka"
 	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/state"
 )
 
 func main() {
 	cfg := config.Load()
 	cfg.ServiceName = "webhooks-enrich"
 
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	catalog := newCatalogIndex()
+	catalogConsumer := kafka.NewTableConsumer(cfg.KafkaBroker, "catalog")
+	defer catalogConsumer.Close()
+	go materializeCatalog(ctx, catalogConsumer, catalog)
+
+	rates, err := loadRates(cfg.CurrencyRatesFile, cfg.BaseCurrency)
+	if err != nil {
+		log.Fatalf("[%s] Failed to load currency rates: %v", cfg.ServiceName, err)
+	}
+	if cfg.CurrencyRatesTopic != "" {
+		ratesConsumer := kafka.NewTableConsumer(cfg.KafkaBroker, cfg.CurrencyRatesTopic)
+		defer ratesConsumer.Close()
+		go materializeRates(ctx, ratesConsumer, rates)
+	}
+
+	var tokenizer pii.Tokenizer
+	if cfg.VaultURL != "" {
+		tokenizer = pii.NewVaultClient(cfg.VaultURL, cfg.ServiceName, cfg.VaultClientKey)
+	}
+	redactor, err := pii.NewRedactor(cfg.PIIFields, cfg.PIIDetectors, tokenizer)
+	if err != nil {
+		log.Fatalf("[%s] Invalid PII configuration: %v", cfg.ServiceName, err)
+	}
+
+	// Dead letters are masked without the vault, which may be what failed.
+	masker := &pii.Redactor{Fields: redactor.Fields, Detectors: redactor.Detectors}
+
+	stateDB, err := state.Open(cfg.StatePath)
+	if err != nil {
+		log.Fatalf("[%s] Failed to open state store: %v", cfg.ServiceName, err)
+	}
+	defer stateDB.Close()
+
+	risk, err := newRiskEnricher(ctx, cfg, stateDB, tokenizer)
+	if err != nil {
+		log.Fatalf("[%s] Invalid risk rules: %v", cfg.ServiceName, err)
+	}
+
+	dedup, err := newDedupEnricher(ctx, cfg, stateDB)
+	if err != nil {
+		log.Fatalf("[%s] Failed to restore dedup state: %v", cfg.ServiceName, err)
+	}
+
+	monetaryFields := cfg.CurrencyFields
+	if len(monetaryFields) == 0 {
+		monetaryFields = defaultMonetaryFields
+	}
+
+	pipeline, err := buildPipeline(cfg,
+		piiEnricher{redactor: redactor},
+		dedup,
+		platformEnricher{},
+		catalogEnricher{index: catalog},
+		currencyEnricher{rates: rates, fields: monetaryFields},
+		risk,
+		identityEnricher{client: customer.NewClient(cfg.CustomerServiceURL, cfg.ServiceName, cfg.CustomerClientKey)},
+	)
+	if err != nil {
+		log.Fatalf("[%s] Invalid enrichment pipeline: %v", cfg.ServiceName, err)
+	}
+
 	consumer := kafka.NewConsumer(cfg.KafkaBroker, cfg.KafkaTopic, "enrich-group")
 	defer consumer.Close()
 
 	producer := kafka.NewProducer(cfg.KafkaBroker, cfg.KafkaTopic+"-enriched")
 	defer producer.Close()
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
+	dlq := kafka.NewProducer(cfg.KafkaBroker, cfg.KafkaTopic+"-dlq")
+	defer dlq.Close()
 
-	log.Printf("[%s] Starting enrichment service", cfg.ServiceName)
+	log.Printf("[%s] Starting enrichment service with stages %v", cfg.ServiceName, pipeline.Stages())
+
+	events := make(chan models.WebhookEvent)
+	go readEvents(ctx, cfg, consumer, events)
+	go dedup.flushLoop(ctx, events)
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("[%s] Shutting down...", cfg.ServiceName)
 			return
+		case event := <-events:
+			enriched, err := pipeline.Run(ctx, event)
+			if err != nil {
+				handleStageError(ctx, cfg, dlq, masker, event, err)
+				continue
+			}
+
+			if err := producer.Send(ctx, enriched.ID, enriched); err != nil {
+				log.Printf("[%s] Failed to send enriched event: %v", cfg.ServiceName, err)
+				continue
+			}
+
+			log.Printf("[%s] Enriched event: %s from %s", cfg.ServiceName, enriched.ID, enriched.Platform)
+		}
+	}
+}
+
+func readEvents(ctx context.Context, cfg config.Config, consumer *kafka.Consumer, events chan<- models.WebhookEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
 		default:
 			msg, err := consumer.Read(ctx)
 			if err != nil {
@@ -51,61 +146,85 @@ func main() {
 				continue
 			}
 
-			enriched := enrichEvent(event)
-			if err := producer.Send(ctx, enriched.ID, enriched); err != nil {
-				log.Printf("[%s] Failed to send enriched event: %v", cfg.ServiceName, err)
-				continue
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
 			}
-
-			log.Printf("[%s] Enriched event: %s from %s", cfg.ServiceName, enriched.ID, enriched.Platform)
 		}
 	}
 }
 
-func enrichEvent(event models.WebhookEvent) models.EnrichedEvent {
-	now := time.Now()
-	event.ProcessedAt = &now
-
-	enrichedData := make(map[string]interface{})
-	enrichedData["source"] = event.Platform
-	enrichedData["enriched_by"] = "ant-enrich"
-	enrichedData["timestamp"] = now.Unix()
-
-	// Add platform-specific enrichment
-	switch event.Platform {
-	case "shopify":
-		enrichedData["store_id"] = extractStoreID(event.Payload)
-	case "magento":
-		enrichedData["website_id"] = extractWebsiteID(event.Payload)
-	case "bigcommerce":
-		enrichedData["store_hash"] = extractStoreHash(event.Payload)
+func buildPipeline(cfg config.Config, enrichers ...enrich.Enricher) (*enrich.Pipeline, error) {
+	registry := map[string]enrich.Enricher{}
+	for _, enricher := range enrichers {
+		registry[enricher.Name()] = enricher
 	}
 
-	return models.EnrichedEvent{
-		WebhookEvent: event,
-		EnrichedData: enrichedData,
-		EnrichedAt:   now,
+	pipelineCfg := defaultPipelineConfig()
+	if cfg.EnrichPipelineConfig != "" {
+		loaded, err := enrich.LoadConfig(cfg.EnrichPipelineConfig)
+		if err != nil {
+			return nil, err
+		}
+		pipelineCfg = loaded
 	}
+
+	return enrich.Build(pipelineCfg, registry)
 }
 
-func extractStoreID(payload map[string]interface{}) string {
-	if shop, ok := payload["shop_domain"].(string); ok {
-		return shop
+func defaultPipelineConfig() enrich.Config {
+	return enrich.Config{
+		Stages: []enrich.StageConfig{
+			{Name: "pii", Timeout: "2s", OnError: enrich.PolicyDLQ},
+			{Name: "dedup", Timeout: "1s", OnError: enrich.PolicySkip},
+			{Name: "platform", Timeout: "1s", OnError: enrich.PolicySkip},
+			{Name: "catalog", EventTypes: []string{"order.*"}, Timeout: "1s", OnError: enrich.PolicySkip},
+			{Name: "currency", Timeout: "1s", OnError: enrich.PolicySkip},
+			{Name: "risk", EventTypes: []string{"order.created"}, Timeout: "1s", OnError: enrich.PolicySkip},
+			{Name: "identity", EventTypes: []string{"order.*", "customer.*"}, Timeout: "2s", OnError: enrich.PolicySkip},
+		},
 	}
-	return "unknown"
 }
 
-func extractWebsiteID(payload map[string]interface{}) string {
-	if id, ok := payload["website_id"].(string); ok {
-		return id
+func handleStageError(ctx context.Context, cfg config.Config, dlq *kafka.Producer, masker *pii.Redactor, event models.WebhookEvent, err error) {
+	var stageErr *enrich.StageError
+	if !errors.As(err, &stageErr) {
+		log.Printf("[%s] Enrichment failed for %s: %v", cfg.ServiceName, event.ID, err)
+		return
 	}
-	return "unknown"
-}
 
-func extractStoreHash(payload map[string]interface{}) string {
-	if hash, ok := payload["store_hash"].(string); ok {
-		return hash
+	if errors.Is(err, enrich.ErrDrop) {
+		log.Printf("[%s] Dropped event %s at stage %s", cfg.ServiceName, event.ID, stageErr.Stage)
+		return
 	}
-	return "unknown"
+
+	if stageErr.Policy == enrich.PolicyDLQ {
+		letter := newDeadLetter(ctx, masker, event, stageErr)
+		if err := dlq.Send(ctx, event.ID, letter); err != nil {
+			log.Printf("[%s] Failed to send %s to dead-letter topic: %v", cfg.ServiceName, event.ID, err)
+			return
+		}
+		log.Printf("[%s] Sent event %s to dead-letter topic: %v", cfg.ServiceName, event.ID, stageErr)
+		return
+	}
+
+	log.Printf("[%s] Enrichment failed for %s: %v", cfg.ServiceName, event.ID, stageErr)
 }
 
+// newDeadLetter builds the dead letter for an event that failed stageErr.
+// The event is the one received, so it can be replayed, but the stage may
+// have failed before the pii stage redacted it or in that stage, so its PII
+// is masked for the shared dead-letter topic. When masking fails the payload
+// is left out.
+func newDeadLetter(ctx context.Context, masker *pii.Redactor, event models.WebhookEvent, stageErr *enrich.StageError) enrich.DeadLetter {
+	if _, err := masker.Redact(ctx, event.Payload); err != nil {
+		event.Payload = nil
+	}
+	return enrich.DeadLetter{
+		Event:    event,
+		Stage:    stageErr.Stage,
+		Error:    stageErr.Err.Error(),
+		FailedAt: time.Now(),
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"ecommerce-platform/internal/enrich"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+)
+
+func TestDeadLetterHasNoPII(t *testing.T) {
+	masker, err := pii.NewRedactor(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := models.WebhookEvent{ID: "evt-1", Platform: "shopify", EventType: "order.created", Payload: map[string]interface{}{
+		"order": map[string]interface{}{
+			"id":    "1001",
+			"email": "jane@example.com",
+			"note":  "call +1 415 555 0100 before delivery",
+			"customer": map[string]interface{}{
+				"first_name": "Jane",
+				"last_name":  "Doe",
+			},
+			"shipping_address": map[string]interface{}{
+				"address1": "1 Market St",
+				"phone":    "(415) 555-0199",
+			},
+		},
+	}}
+	stageErr := &enrich.StageError{Stage: "pii", Policy: enrich.PolicyDLQ, Err: errors.New("vault unavailable")}
+
+	letter, err := json.Marshal(newDeadLetter(context.Background(), masker, event, stageErr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"jane@example.com", "415 555 0100", "Jane", "Doe", "1 Market St", "555-0199"} {
+		if strings.Contains(string(letter), value) {
+			t.Fatalf("dead letter contains %q: %s", value, letter)
+		}
+	}
+	if !strings.Contains(string(letter), "1001") {
+		t.Fatalf("dead letter lost the order ID: %s", letter)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/pii"
+)
+
+// piiEnricher tokenizes any PII left in the payload, so nothing readable
+// reaches the webhooks-enriched topic even for events that bypassed
+// webhooks-api redaction.
+type piiEnricher struct {
+	redactor *pii.Redactor
+}
+
+func (piiEnricher) Name() string { return "pii" }
+
+func (e piiEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	paths, err := e.redactor.Redact(ctx, event.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	return map[string]interface{}{
+		"redacted_fields": paths,
+		"tokenized":       e.redactor.Tokenizer != nil,
+	}, nil
+}
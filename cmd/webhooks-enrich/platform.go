@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"ecommerce-platform/internal/models"
+)
+
+// platformEnricher extracts the store identifiers each platform puts in its
+// webhook payloads.
+type platformEnricher struct{}
+
+func (platformEnricher) Name() string { return "platform" }
+
+func (platformEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	payload := event.Payload
+	data := make(map[string]interface{})
+
+	switch event.Platform {
+	case "shopify":
+		data["store_id"] = extractString(payload, "shop_domain")
+	case "magento":
+		data["website_id"] = extractString(payload, "website_id")
+		data["store_code"] = extractString(payload, "store_code")
+	case "bigcommerce":
+		data["store_hash"] = extractString(payload, "store_hash")
+		data["channel_id"] = extractString(payload, "channel_id")
+	case "netsuite":
+		data["account_id"] = extractString(payload, "account_id")
+		data["subsidiary"] = extractString(payload, "subsidiary")
+	case "msi":
+		data["source_code"] = extractString(payload, "source_code")
+		data["stock_id"] = extractString(payload, "stock_id")
+	case "kidzania":
+		data["park_id"] = extractString(payload, "park_id")
+		data["city"] = extractString(payload, "city")
+	}
+
+	return data, nil
+}
+
+func extractString(payload map[string]interface{}, key string) string {
+	if value, ok := payload[key].(string); ok {
+		return value
+	}
+	return "unknown"
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"ecommerce-platform/internal/config"
+	"ecommerce-platform/internal/models"
+	"ecommerce-platform/internal/payload"
+	"ecommerce-platform/internal/pii"
+	"ecommerce-platform/internal/state"
+)
+
+// riskFields lists where each named risk attribute can be found in an order
+// payload. Rules may also name a dotted payload path directly.
+var riskFields = map[string][]string{
+	"email":            {"order.email", "order.customer.email", "email"},
+	"phone":            {"order.phone", "order.customer.phone", "order.shipping_address.phone"},
+	"ip":               {"order.browser_ip", "order.client_details.browser_ip", "order.ip_address", "ip"},
+	"card_fingerprint": {"order.payment.card_fingerprint", "order.card_fingerprint"},
+	"country":          {"order.shipping_address.country", "order.billing_address.country"},
+}
+
+type riskRules struct {
+	Velocity        []velocityRule      `json:"velocity"`
+	OrderValue      []valueRule         `json:"order_value"`
+	NewCustomer     *valueRule          `json:"new_customer,omitempty"`
+	AddressMismatch *valueRule          `json:"address_mismatch,omitempty"`
+	Blocklists      map[string][]string `json:"blocklists,omitempty"`
+	BlocklistScore  float64             `json:"blocklist_score"`
+}
+
+type velocityRule struct {
+	Field     string  `json:"field"`
+	Window    string  `json:"window"`
+	MaxOrders int     `json:"max_orders"`
+	Score     float64 `json:"score"`
+
+	window time.Duration
+}
+
+// valueRule adds Score when the order total is at least MinTotal.
+type valueRule struct {
+	MinTotal float64 `json:"min_total"`
+	Score    float64 `json:"score"`
+}
+
+func defaultRiskRules() riskRules {
+	return riskRules{
+		Velocity: []velocityRule{
+			{Field: "email", Window: "1h", MaxOrders: 3, Score: 30},
+			{Field: "ip", Window: "1h", MaxOrders: 5, Score: 30},
+			{Field: "card_fingerprint", Window: "24h", MaxOrders: 5, Score: 40},
+		},
+		OrderValue: []valueRule{
+			{MinTotal: 500, Score: 15},
+			{MinTotal: 2000, Score: 25},
+		},
+		NewCustomer:     &valueRule{MinTotal: 300, Score: 25},
+		AddressMismatch: &valueRule{Score: 20},
+		BlocklistScore:  100,
+	}
+}
+
+// loadRiskRules reads the rules file and tokenizes email and phone
+// blocklist entries, since payloads only carry tokens for them.
+func loadRiskRules(ctx context.Context, path string, tokenizer pii.Tokenizer) (riskRules, error) {
+	rules := defaultRiskRules()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return rules, err
+		}
+		rules = riskRules{}
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return rules, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	for i, rule := range rules.Velocity {
+		window, err := time.ParseDuration(rule.Window)
+		if err != nil {
+			return rules, fmt.Errorf("velocity rule %s: %w", rule.Field, err)
+		}
+		rules.Velocity[i].window = window
+	}
+
+	if tokenizer == nil {
+		return rules, nil
+	}
+	for _, kind := range []string{"email", "phone"} {
+		var values []pii.Value
+		for _, entry := range rules.Blocklists[kind] {
+			if !pii.IsToken(entry) {
+				values = append(values, pii.Value{Kind: kind, Value: entry})
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		tokens, err := tokenizer.Tokenize(ctx, values)
+		if err != nil {
+			return rules, fmt.Errorf("tokenize %s blocklist: %w", kind, err)
+		}
+		rules.Blocklists[kind] = append(rules.Blocklists[kind], tokens...)
+	}
+	return rules, nil
+}
+
+func newRiskEnricher(ctx context.Context, cfg config.Config, db *state.DB, tokenizer pii.Tokenizer) (riskEnricher, error) {
+	rules, err := loadRiskRules(ctx, cfg.RiskRulesFile, tokenizer)
+	if err != nil {
+		return riskEnricher{}, err
+	}
+	velocity, err := db.Store("risk-velocity")
+	if err != nil {
+		return riskEnricher{}, err
+	}
+	seen, err := db.Store("risk-customers")
+	if err != nil {
+		return riskEnricher{}, err
+	}
+	return riskEnricher{rules: rules, velocity: velocity, seen: seen}, nil
+}
+
+// riskEnricher scores orders against the rules. Velocity counters and
+// first-seen customers are kept in the local state store.
+type riskEnricher struct {
+	rules    riskRules
+	velocity *state.Store
+	seen     *state.Store
+}
+
+func (riskEnricher) Name() string { return "risk" }
+
+func (e riskEnricher) Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	order, ok := event.Payload["order"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	total, _ := order["total"].(float64)
+
+	var score float64
+	reasons := []string{}
+	add := func(points float64, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+
+	for kind, entries := range e.rules.Blocklists {
+		value := riskValue(event.Payload, kind)
+		for _, entry := range entries {
+			if value != "" && value == entry {
+				add(e.rules.BlocklistScore, "blocklist:"+kind)
+				break
+			}
+		}
+	}
+
+	for _, rule := range e.rules.OrderValue {
+		if total >= rule.MinTotal {
+			add(rule.Score, "order_value>="+strconv.FormatFloat(rule.MinTotal, 'f', -1, 64))
+		}
+	}
+
+	if rule := e.rules.AddressMismatch; rule != nil && addressMismatch(order) {
+		add(rule.Score, "address_mismatch")
+	}
+
+	newCustomer, err := e.firstSeen(riskValue(event.Payload, "email"), event.ReceivedAt)
+	if err != nil {
+		return nil, err
+	}
+	if rule := e.rules.NewCustomer; rule != nil && newCustomer && total >= rule.MinTotal {
+		add(rule.Score, "new_customer")
+	}
+
+	counts, err := e.countVelocity(event.Payload, event.ReceivedAt)
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range e.rules.Velocity {
+		if counts[i] > rule.MaxOrders {
+			add(rule.Score, fmt.Sprintf("velocity:%s>%d/%s", rule.Field, rule.MaxOrders, rule.Window))
+		}
+	}
+
+	return map[string]interface{}{
+		"score":        math.Min(score, 100),
+		"reasons":      reasons,
+		"new_customer": newCustomer,
+	}, nil
+}
+
+// firstSeen records the customer and reports whether this is the first
+// order seen for them.
+func (e riskEnricher) firstSeen(email string, at time.Time) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	var since time.Time
+	found, err := e.seen.Get(email, &since)
+	if err != nil || found {
+		return false, err
+	}
+	return true, e.seen.Put(email, at)
+}
+
+// countVelocity records the order against every velocity attribute and
+// returns, per rule, the number of orders inside its window.
+func (e riskEnricher) countVelocity(p map[string]interface{}, at time.Time) ([]int, error) {
+	counts := make([]int, len(e.rules.Velocity))
+	history := make(map[string][]time.Time)
+
+	for i, rule := range e.rules.Velocity {
+		value := riskValue(p, rule.Field)
+		if value == "" {
+			continue
+		}
+		key := rule.Field + "/" + value
+
+		times, ok := history[key]
+		if !ok {
+			if _, err := e.velocity.Get(key, &times); err != nil {
+				return nil, err
+			}
+			times = append(times, at)
+			history[key] = times
+		}
+		for _, t := range times {
+			if at.Sub(t) <= rule.window {
+				counts[i]++
+			}
+		}
+	}
+
+	for key, times := range history {
+		if err := e.velocity.Put(key, trimWindow(times, at, e.maxWindow())); err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+func (e riskEnricher) maxWindow() time.Duration {
+	var max time.Duration
+	for _, rule := range e.rules.Velocity {
+		if rule.window > max {
+			max = rule.window
+		}
+	}
+	return max
+}
+
+func trimWindow(times []time.Time, at time.Time, window time.Duration) []time.Time {
+	kept := times[:0]
+	for _, t := range times {
+		if at.Sub(t) <= window {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func riskValue(p map[string]interface{}, field string) string {
+	paths, ok := riskFields[field]
+	if !ok {
+		paths = []string{field}
+	}
+	for _, path := range paths {
+		if value, ok := payload.Lookup(p, path); ok {
+			if s, ok := value.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// addressMismatch reports whether the billing and shipping addresses are
+// both present and differ. Tokens are deterministic, so equal addresses
+// still compare equal after redaction. Values are compared as text, as
+// platforms may send them as numbers or nested objects.
+func addressMismatch(order map[string]interface{}) bool {
+	shipping, ok := order["shipping_address"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	billing, ok := order["billing_address"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, key := range []string{"address1", "zip", "country"} {
+		if fmt.Sprint(shipping[key]) != fmt.Sprint(billing[key]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnauthorized = errors.New("unknown client or wrong key")
+	ErrForbidden    = errors.New("grant not allowed for client")
+)
+
+type client struct {
+	key    string
+	grants map[string]bool
+}
+
+// Clients are the API clients of a service by ID. A client identifies
+// itself with the X-Client-ID and X-Client-Key headers.
+type Clients map[string]client
+
+// ParseClients reads entries of the form "id=key:grant|grant", e.g.
+// "support-console=secret:create|cancel|hold|release|annotate".
+func ParseClients(entries []string) (Clients, error) {
+	clients := make(Clients)
+	for _, entry := range entries {
+		id, rest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("entry %q: missing '='", entry)
+		}
+		key, grants, ok := strings.Cut(rest, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("entry %q: missing key or grants", id)
+		}
+
+		c := client{key: key, grants: make(map[string]bool)}
+		for _, grant := range strings.Split(grants, "|") {
+			c.grants[grant] = true
+		}
+		clients[id] = c
+	}
+	return clients, nil
+}
+
+// Authorize checks the client of the request against its key and returns
+// ErrUnauthorized when it is unknown or the key is wrong, or ErrForbidden
+// when it lacks grant.
+func (clients Clients) Authorize(r *http.Request, grant string) error {
+	c, ok := clients[r.Header.Get("X-Client-ID")]
+	if !ok || subtle.ConstantTimeCompare([]byte(c.key), []byte(r.Header.Get("X-Client-Key"))) != 1 {
+		return ErrUnauthorized
+	}
+	if !c.grants[grant] {
+		log.Printf("[auth] Denied %s for %s", grant, r.Header.Get("X-Client-ID"))
+		return ErrForbidden
+	}
+	return nil
+}
+
+// RequireGrant lets only clients holding grant through to next and answers
+// everyone else with 401 or 403.
+func (clients Clients) RequireGrant(grant string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch err := clients.Authorize(r, grant); {
+		case errors.Is(err, ErrUnauthorized):
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			next(w, r)
+		}
+	}
+}
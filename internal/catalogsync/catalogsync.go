@@ -0,0 +1,302 @@
+package catalogsync
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+)
+
+const (
+	CatalogTopic = "catalog"
+	PushesTopic  = "catalog-pushes"
+)
+
+// Push records the fields a connector changed on its platform for one
+// product. catalog-service applies it to the platform's record of the
+// product and ignores the webhooks the platform echoes back for it.
+type Push struct {
+	ProductID string               `json:"product_id"`
+	Platform  string               `json:"platform"`
+	Changes   models.ProductSource `json:"changes"`
+	PushedAt  time.Time            `json:"pushed_at"`
+}
+
+// Pusher updates the changed fields of product on a platform. Changes has
+// the platform's product ID and only the fields to update set; variants
+// are listed by canonical SKU with the platform's variant ID.
+type Pusher func(ctx context.Context, product models.CatalogProduct, changes models.ProductSource) error
+
+// Diff returns the synced fields of product that differ from known, the
+// platform's record of it. Name, description, price, stock and the
+// platform categories the product's categories map to are synced;
+// a configurable product syncs the price and stock of every variant the
+// platform has instead of its own. Only fields the platform has reported
+// are synced, so a platform that does not track stock for a product is not
+// sent any, and one that names categories without IDs is not sent
+// categories. Categories are only added: the product stays in the platform
+// categories it is in, as they may not map to the category tree.
+func Diff(platform string, product models.CatalogProduct, known models.ProductSource) (models.ProductSource, bool) {
+	changes := models.ProductSource{ID: product.PlatformIDs[platform]}
+	changed := false
+
+	if known.Name != nil && product.Name != "" && *known.Name != product.Name {
+		changes.Name = &product.Name
+		changed = true
+	}
+	if known.Description != nil && product.Description != "" && *known.Description != product.Description {
+		changes.Description = &product.Description
+		changed = true
+	}
+	if HasCategoryIDs(known.Categories) {
+		var categories []models.CategoryRef
+		in := make(map[string]bool)
+		for _, ref := range known.Categories {
+			if ref.ID != "" && !in[ref.ID] {
+				categories = append(categories, models.CategoryRef{ID: ref.ID})
+				in[ref.ID] = true
+			}
+		}
+		added := false
+		for _, id := range product.CategoryIDs[platform] {
+			if !in[id] {
+				categories = append(categories, models.CategoryRef{ID: id})
+				in[id] = true
+				added = true
+			}
+		}
+		if added {
+			changes.Categories = categories
+			changed = true
+		}
+	}
+
+	if len(product.Variants) == 0 {
+		if known.Price != nil && !models.SamePrice(*known.Price, product.Price) {
+			changes.Price = &product.Price
+			changed = true
+		}
+		if known.Stock != nil && *known.Stock != product.Stock {
+			changes.Stock = &product.Stock
+			changed = true
+		}
+		return changes, changed
+	}
+
+	for _, variant := range product.Variants {
+		id, ok := variant.PlatformIDs[platform]
+		if !ok {
+			continue
+		}
+		var current models.SourceVariant
+		for _, v := range known.Variants {
+			if models.CanonicalSKU(v.SKU) == variant.SKU {
+				current = v
+				break
+			}
+		}
+
+		update := models.SourceVariant{ID: id, SKU: variant.SKU}
+		if current.Price != nil && !models.SamePrice(*current.Price, variant.Price) {
+			price := variant.Price
+			update.Price = &price
+		}
+		if current.Stock != nil && *current.Stock != variant.Stock {
+			stock := variant.Stock
+			update.Stock = &stock
+		}
+		if update.Price != nil || update.Stock != nil {
+			changes.Variants = append(changes.Variants, update)
+			changed = true
+		}
+	}
+	return changes, changed
+}
+
+// Fields lists the names of the fields set in changes, for logging.
+func Fields(changes models.ProductSource) []string {
+	var fields []string
+	if changes.Name != nil {
+		fields = append(fields, "name")
+	}
+	if changes.Description != nil {
+		fields = append(fields, "description")
+	}
+	if changes.Price != nil {
+		fields = append(fields, "price")
+	}
+	if changes.Stock != nil {
+		fields = append(fields, "stock")
+	}
+	if changes.Categories != nil {
+		fields = append(fields, "categories")
+	}
+	for _, variant := range changes.Variants {
+		fields = append(fields, "variant "+variant.SKU)
+	}
+	return fields
+}
+
+// Overlay applies changes on top of source. Variants in changes update the
+// variant with the same canonical SKU and are added when source lacks it.
+func Overlay(source, changes models.ProductSource) models.ProductSource {
+	if changes.Name != nil {
+		source.Name = changes.Name
+	}
+	if changes.Description != nil {
+		source.Description = changes.Description
+	}
+	if changes.Price != nil {
+		source.Price = changes.Price
+	}
+	if changes.Cost != nil {
+		source.Cost = changes.Cost
+	}
+	if changes.Stock != nil {
+		source.Stock = changes.Stock
+	}
+	if changes.Categories != nil {
+		source.Categories = changes.Categories
+	}
+
+	variants := append([]models.SourceVariant(nil), source.Variants...)
+	for _, update := range changes.Variants {
+		i := 0
+		for i < len(variants) && models.CanonicalSKU(variants[i].SKU) != models.CanonicalSKU(update.SKU) {
+			i++
+		}
+		if i == len(variants) {
+			variants = append(variants, models.SourceVariant{ID: update.ID, SKU: update.SKU})
+		}
+		if update.Price != nil {
+			variants[i].Price = update.Price
+		}
+		if update.Cost != nil {
+			variants[i].Cost = update.Cost
+		}
+		if update.Stock != nil {
+			variants[i].Stock = update.Stock
+		}
+	}
+	source.Variants = variants
+	return source
+}
+
+// Serve consumes product versions from the catalog topic and pushes to
+// platform the fields that differ from what the platform has, publishing a
+// Push for each. Until the platform's record catches up with a push, the
+// pushed values count as known, so a product is not pushed twice. Products
+// the platform does not sell are skipped. It returns when ctx is done.
+func Serve(ctx context.Context, broker, platform, groupID string, push Pusher) {
+	consumer := kafka.NewConsumer(broker, CatalogTopic, groupID)
+	defer consumer.Close()
+	pushes := kafka.NewProducer(broker, PushesTopic)
+	defer pushes.Close()
+
+	s := &syncer{platform: platform, pending: make(map[string]Push)}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			if len(msg.Value) == 0 {
+				continue
+			}
+
+			var product models.CatalogProduct
+			if err := json.Unmarshal(msg.Value, &product); err != nil {
+				log.Printf("[catalogsync] Unmarshal error: %v", err)
+				continue
+			}
+			changes, ok := s.changes(product)
+			if !ok {
+				continue
+			}
+			if err := push(ctx, product, changes); err != nil {
+				log.Printf("[catalogsync] Failed to push product %s to %s: %v", product.ID, platform, err)
+				continue
+			}
+
+			record := Push{ProductID: product.ID, Platform: platform, Changes: changes, PushedAt: time.Now()}
+			s.pushed(record)
+			if err := pushes.Send(ctx, product.ID, record); err != nil {
+				log.Printf("[catalogsync] Failed to publish push of %s to %s: %v", product.ID, platform, err)
+			}
+		}
+	}
+}
+
+type syncer struct {
+	mu       sync.Mutex
+	platform string
+	pending  map[string]Push
+}
+
+// changes diffs product against the platform's record overlaid with what
+// was pushed since the platform last reported a change.
+func (s *syncer) changes(product models.CatalogProduct) (models.ProductSource, bool) {
+	if _, ok := product.PlatformIDs[s.platform]; !ok {
+		return models.ProductSource{}, false
+	}
+	known := product.Sources[s.platform]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pending, ok := s.pending[product.ID]; ok {
+		if known.UpdatedAt.After(pending.PushedAt) {
+			delete(s.pending, product.ID)
+		} else {
+			known = Overlay(known, pending.Changes)
+		}
+	}
+	return Diff(s.platform, product, known)
+}
+
+func (s *syncer) pushed(record Push) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pending, ok := s.pending[record.ProductID]; ok {
+		record.Changes = Overlay(pending.Changes, record.Changes)
+	}
+	s.pending[record.ProductID] = record
+}
+
+// SameCategories reports whether two lists file a product under the same
+// platform categories, in any order. Categories known by name only, such
+// as a Shopify product type, are not compared.
+func SameCategories(a, b []models.CategoryRef) bool {
+	ids := func(refs []models.CategoryRef) map[string]bool {
+		set := make(map[string]bool, len(refs))
+		for _, ref := range refs {
+			if ref.ID != "" {
+				set[ref.ID] = true
+			}
+		}
+		return set
+	}
+	return reflect.DeepEqual(ids(a), ids(b))
+}
+
+// HasCategoryIDs reports whether any of refs has a platform category ID.
+func HasCategoryIDs(refs []models.CategoryRef) bool {
+	for _, ref := range refs {
+		if ref.ID != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package catalogsync
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+)
+
+const PriceChangesTopic = "price-changes"
+
+// PriceChange is the price a platform sells a SKU at within a scope from
+// EffectiveAt on. An empty Channel or CustomerGroup covers every sales
+// channel or customer group. Price is nil once no price list applies any
+// more, and the SKU sells at its RegularPrice again.
+type PriceChange struct {
+	Platform      string    `json:"platform"`
+	ProductID     string    `json:"product_id"`
+	PlatformID    string    `json:"platform_id"`
+	VariantID     string    `json:"variant_id,omitempty"`
+	SKU           string    `json:"sku"`
+	Channel       string    `json:"channel,omitempty"`
+	Currency      string    `json:"currency"`
+	CustomerGroup string    `json:"customer_group,omitempty"`
+	Price         *float64  `json:"price"`
+	RegularPrice  float64   `json:"regular_price"`
+	PriceListID   string    `json:"price_list_id,omitempty"`
+	EffectiveAt   time.Time `json:"effective_at"`
+}
+
+// Key identifies the platform, scope and SKU the price is for.
+func (c PriceChange) Key() string {
+	return c.Platform + "|" + c.Channel + "|" + c.Currency + "|" + c.CustomerGroup + "|" + c.SKU
+}
+
+// PriceSetter sets or, when the change has no price, removes the price of
+// a SKU for a scope on a platform.
+type PriceSetter func(ctx context.Context, change PriceChange) error
+
+// ServePrices consumes price changes and applies those for platform. It
+// returns when ctx is done.
+func ServePrices(ctx context.Context, broker, platform, groupID string, set PriceSetter) {
+	consumer := kafka.NewConsumer(broker, PriceChangesTopic, groupID)
+	defer consumer.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var change PriceChange
+			if err := json.Unmarshal(msg.Value, &change); err != nil {
+				log.Printf("[catalogsync] Unmarshal error: %v", err)
+				continue
+			}
+			if change.Platform != platform {
+				continue
+			}
+			if err := set(ctx, change); err != nil {
+				log.Printf("[catalogsync] Failed to set price of %s on %s: %v", change.SKU, platform, err)
+			}
+		}
+	}
+}
@@ -1,12 +1,64 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	KafkaBroker string
 	KafkaTopic  string
 	HTTPPort    string
 	ServiceName string
+
+	EnrichPipelineConfig string
+	BaseCurrency         string
+	CurrencyRatesFile    string
+	CurrencyRatesTopic   string
+	CurrencyFields       []string
+
+	VaultURL       string
+	VaultClientKey string
+	VaultSecret    string
+	VaultDBPath    string
+	VaultClients   []string
+	PIIFields      []string
+	PIIDetectors   []string
+
+	StatePath         string
+	RiskRulesFile     string
+	RiskHoldThreshold float64
+
+	DedupStaleAction    string
+	DedupCollapseWindow time.Duration
+
+	CustomerServiceURL string
+	CustomerClientKey  string
+	CustomerClients    []string
+
+	OrderStore  string
+	OrderDBPath string
+	DatabaseURL string
+
+	OrderAPIClients  []string
+	RoutingRulesFile string
+	OutboxStuckAfter time.Duration
+
+	SagaSteps       []string
+	SagaStepTimeout time.Duration
+
+	CatalogPrecedence []string
+	CatalogEchoWindow time.Duration
+
+	CatalogFeedLink      string
+	CatalogFeedImageLink string
+	CatalogPriceInterval time.Duration
+	CatalogClients       []string
+
+	InventoryDefaultLocation string
+	InventoryClients         []string
 }
 
 func Load() Config {
@@ -15,6 +67,53 @@ func Load() Config {
 		KafkaTopic:  getEnv("KAFKA_TOPIC", "webhooks"),
 		HTTPPort:    getEnv("HTTP_PORT", "8080"),
 		ServiceName: getEnv("SERVICE_NAME", "unknown"),
+
+		EnrichPipelineConfig: getEnv("ENRICH_PIPELINE_CONFIG", ""),
+		BaseCurrency:         getEnv("BASE_CURRENCY", "USD"),
+		CurrencyRatesFile:    getEnv("CURRENCY_RATES_FILE", ""),
+		CurrencyRatesTopic:   getEnv("CURRENCY_RATES_TOPIC", ""),
+		CurrencyFields:       getEnvList("CURRENCY_FIELDS"),
+
+		VaultURL:       getEnv("VAULT_URL", ""),
+		VaultClientKey: getEnv("VAULT_CLIENT_KEY", ""),
+		VaultSecret:    getEnv("VAULT_SECRET", ""),
+		VaultDBPath:    getEnv("VAULT_DB_PATH", "vault.db"),
+		VaultClients:   getEnvList("VAULT_CLIENTS"),
+		PIIFields:      getEnvList("PII_FIELDS"),
+		PIIDetectors:   getEnvLines("PII_DETECTORS"),
+
+		StatePath:         getEnv("STATE_PATH", "state.db"),
+		RiskRulesFile:     getEnv("RISK_RULES_FILE", ""),
+		RiskHoldThreshold: getEnvFloat("RISK_HOLD_THRESHOLD", 70),
+
+		DedupStaleAction:    getEnv("DEDUP_STALE_ACTION", "drop"),
+		DedupCollapseWindow: getEnvDuration("DEDUP_COLLAPSE_WINDOW", 5*time.Second),
+
+		CustomerServiceURL: getEnv("CUSTOMER_SERVICE_URL", "http://localhost:8086"),
+		CustomerClientKey:  getEnv("CUSTOMER_CLIENT_KEY", ""),
+		CustomerClients:    getEnvList("CUSTOMER_CLIENTS"),
+
+		OrderStore:  getEnv("ORDER_STORE", "bolt"),
+		OrderDBPath: getEnv("ORDER_DB_PATH", "orders.db"),
+		DatabaseURL: getEnv("DATABASE_URL", ""),
+
+		OrderAPIClients:  getEnvList("ORDER_API_CLIENTS"),
+		RoutingRulesFile: getEnv("ROUTING_RULES_FILE", ""),
+		OutboxStuckAfter: getEnvDuration("OUTBOX_STUCK_AFTER", time.Minute),
+
+		SagaSteps:       getEnvList("SAGA_STEPS"),
+		SagaStepTimeout: getEnvDuration("SAGA_STEP_TIMEOUT", 30*time.Second),
+
+		CatalogPrecedence: getEnvList("CATALOG_PRECEDENCE"),
+		CatalogEchoWindow: getEnvDuration("CATALOG_ECHO_WINDOW", 10*time.Minute),
+
+		CatalogFeedLink:      getEnv("CATALOG_FEED_LINK", ""),
+		CatalogFeedImageLink: getEnv("CATALOG_FEED_IMAGE_LINK", ""),
+		CatalogPriceInterval: getEnvDuration("CATALOG_PRICE_INTERVAL", time.Minute),
+		CatalogClients:       getEnvList("CATALOG_CLIENTS"),
+
+		InventoryDefaultLocation: getEnv("INVENTORY_DEFAULT_LOCATION", "default"),
+		InventoryClients:         getEnvList("INVENTORY_CLIENTS"),
 	}
 }
 
@@ -24,3 +123,45 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable, returning nil when unset.
+func getEnvList(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// getEnvLines reads a list with one entry per line, for entries such as
+// regular expressions that may contain commas.
+func getEnvLines(key string) []string {
+	var list []string
+	for _, entry := range strings.Split(os.Getenv(key), "\n") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate converts one unit of Currency into the base currency from
+// EffectiveFrom until a newer rate for the same currency takes effect.
+type Rate struct {
+	Currency      string    `json:"currency"`
+	Rate          float64   `json:"rate"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	Version       string    `json:"version,omitempty"`
+}
+
+// RatesFile is the on-disk format of a versioned rate table.
+type RatesFile struct {
+	Version string `json:"version"`
+	Base    string `json:"base"`
+	Rates   []Rate `json:"rates"`
+}
+
+type Table struct {
+	mu    sync.RWMutex
+	base  string
+	rates map[string][]Rate
+}
+
+func NewTable(base string) *Table {
+	return &Table{
+		base:  strings.ToUpper(base),
+		rates: make(map[string][]Rate),
+	}
+}
+
+func LoadFile(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file RatesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if file.Base == "" {
+		return nil, fmt.Errorf("%s: missing base currency", path)
+	}
+
+	table := NewTable(file.Base)
+	for _, rate := range file.Rates {
+		if rate.Version == "" {
+			rate.Version = file.Version
+		}
+		table.Add(rate)
+	}
+	return table, nil
+}
+
+func (t *Table) Base() string {
+	return t.base
+}
+
+// Add inserts a rate, replacing any rate for the same currency and
+// effective date.
+func (t *Table) Add(rate Rate) {
+	rate.Currency = strings.ToUpper(rate.Currency)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rates := t.rates[rate.Currency]
+	for i := range rates {
+		if rates[i].EffectiveFrom.Equal(rate.EffectiveFrom) {
+			rates[i] = rate
+			return
+		}
+	}
+	rates = append(rates, rate)
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].EffectiveFrom.Before(rates[j].EffectiveFrom)
+	})
+	t.rates[rate.Currency] = rates
+}
+
+// Lookup returns the rate for currency in effect at the given time. The
+// base currency always converts at 1.
+func (t *Table) Lookup(currency string, at time.Time) (Rate, error) {
+	currency = strings.ToUpper(currency)
+	if currency == t.base {
+		return Rate{Currency: currency, Rate: 1}, nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rates := t.rates[currency]
+	i := sort.Search(len(rates), func(i int) bool {
+		return rates[i].EffectiveFrom.After(at)
+	})
+	if i == 0 {
+		return Rate{}, fmt.Errorf("no %s rate in effect at %s", currency, at.Format(time.RFC3339))
+	}
+	return rates[i-1], nil
+}
+
+// Convert converts amount using rate, rounded to the minor unit of the base
+// currency.
+func Convert(amount float64, rate Rate, base string) float64 {
+	scale := math.Pow10(MinorUnits(base))
+	return math.Round(amount*rate.Rate*scale) / scale
+}
+
+// minorUnits lists the ISO 4217 currencies whose minor unit is not a
+// hundredth.
+var minorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// MinorUnits returns the number of decimals amounts in currency are
+// rounded to.
+func MinorUnits(currency string) int {
+	if units, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return units
+	}
+	return 2
+}
@@ -0,0 +1,90 @@
+package customer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identifier is one way a shopper is known: an email or phone token, or a
+// platform customer ID such as {Type: "shopify", Value: "7421"}.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (i Identifier) Key() string {
+	return i.Type + ":" + i.Value
+}
+
+// Customer is a node in the identity graph. A merged customer points at the
+// customer it was merged into and keeps its own identifiers so the merge
+// can be undone.
+type Customer struct {
+	ID          string       `json:"id"`
+	Identifiers []Identifier `json:"identifiers"`
+	MergedInto  string       `json:"merged_into,omitempty"`
+	Merged      []string     `json:"merged,omitempty"`
+	Split       []string     `json:"split,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+type ResolveRequest struct {
+	Identifiers []Identifier `json:"identifiers"`
+}
+
+type ResolveResponse struct {
+	CustomerID string   `json:"customer_id"`
+	Created    bool     `json:"created"`
+	Merged     []string `json:"merged,omitempty"`
+}
+
+type Client struct {
+	baseURL  string
+	clientID string
+	key      string
+	http     *http.Client
+}
+
+func NewClient(baseURL, clientID, key string) *Client {
+	return &Client{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		clientID: clientID,
+		key:      key,
+		http:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Resolve returns the canonical customer for the identifiers, creating or
+// linking customers in the graph as needed.
+func (c *Client) Resolve(ctx context.Context, identifiers []Identifier) (ResolveResponse, error) {
+	var resp ResolveResponse
+
+	body, err := json.Marshal(ResolveRequest{Identifiers: identifiers})
+	if err != nil {
+		return resp, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/customers/resolve", bytes.NewReader(body))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", c.clientID)
+	req.Header.Set("X-Client-Key", c.key)
+
+	httpResp, err := c.http.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("customer-service resolve: %s", httpResp.Status)
+	}
+	return resp, json.NewDecoder(httpResp.Body).Decode(&resp)
+}
@@ -0,0 +1,73 @@
+package enrich
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type StageConfig struct {
+	Name       string      `json:"name"`
+	Platforms  []string    `json:"platforms,omitempty"`
+	EventTypes []string    `json:"event_types,omitempty"`
+	Timeout    string      `json:"timeout,omitempty"`
+	OnError    ErrorPolicy `json:"on_error,omitempty"`
+}
+
+type Config struct {
+	Stages []StageConfig `json:"stages"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Build resolves each configured stage against the registered enrichers and
+// returns the pipeline in configuration order.
+func Build(cfg Config, registry map[string]Enricher) (*Pipeline, error) {
+	stages := make([]Stage, 0, len(cfg.Stages))
+
+	for _, sc := range cfg.Stages {
+		enricher, ok := registry[sc.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown enrichment stage %q", sc.Name)
+		}
+
+		stage := Stage{
+			Enricher:   enricher,
+			Platforms:  sc.Platforms,
+			EventTypes: sc.EventTypes,
+			OnError:    sc.OnError,
+		}
+		if stage.OnError == "" {
+			stage.OnError = PolicySkip
+		}
+		switch stage.OnError {
+		case PolicySkip, PolicyFail, PolicyDLQ:
+		default:
+			return nil, fmt.Errorf("stage %s: unknown error policy %q", sc.Name, sc.OnError)
+		}
+
+		if sc.Timeout != "" {
+			timeout, err := time.ParseDuration(sc.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("stage %s: %w", sc.Name, err)
+			}
+			stage.Timeout = timeout
+		}
+
+		stages = append(stages, stage)
+	}
+
+	return NewPipeline(stages...), nil
+}
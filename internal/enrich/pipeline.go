@@ -0,0 +1,209 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+// ErrDrop is returned by an Enricher when the event must not be forwarded.
+var ErrDrop = errors.New("event dropped")
+
+// Enricher is a single enrichment stage. It may read what earlier stages
+// recorded and may rewrite the payload; the returned data is stored in
+// EnrichedData under the stage name.
+type Enricher interface {
+	Name() string
+	Enrich(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error)
+}
+
+type ErrorPolicy string
+
+const (
+	PolicySkip ErrorPolicy = "skip"
+	PolicyFail ErrorPolicy = "fail"
+	PolicyDLQ  ErrorPolicy = "dlq"
+)
+
+type Stage struct {
+	Enricher   Enricher
+	Platforms  []string
+	EventTypes []string
+	Timeout    time.Duration
+	OnError    ErrorPolicy
+}
+
+type StageError struct {
+	Stage  string
+	Policy ErrorPolicy
+	Err    error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v", e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetter is published to the dead-letter topic when a stage with the
+// dlq policy fails.
+type DeadLetter struct {
+	Event    models.WebhookEvent `json:"event"`
+	Stage    string              `json:"stage"`
+	Error    string              `json:"error"`
+	FailedAt time.Time           `json:"failed_at"`
+}
+
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Enricher.Name()
+	}
+	return names
+}
+
+// Run passes the event through every matching stage in order. Stage errors
+// are handled according to the stage policy: skip records the error and
+// continues, fail and dlq stop the pipeline with a *StageError.
+func (p *Pipeline) Run(ctx context.Context, event models.WebhookEvent) (models.EnrichedEvent, error) {
+	now := time.Now()
+	event.ProcessedAt = &now
+
+	enriched := models.EnrichedEvent{
+		WebhookEvent: event,
+		EnrichedData: map[string]interface{}{
+			"source":      event.Platform,
+			"enriched_by": "ant-enrich",
+			"timestamp":   now.Unix(),
+		},
+		EnrichedAt: now,
+	}
+
+	for _, stage := range p.stages {
+		if !stage.matches(event) {
+			continue
+		}
+
+		name := stage.Enricher.Name()
+		data, err := stage.run(ctx, &enriched)
+		if errors.Is(err, ErrDrop) {
+			return enriched, &StageError{Stage: name, Policy: stage.OnError, Err: err}
+		}
+		if err != nil {
+			if stage.OnError == PolicySkip {
+				log.Printf("[enrich] Stage %s skipped for %s: %v", name, event.ID, err)
+				enriched.EnrichedData[name] = map[string]interface{}{"error": err.Error()}
+				continue
+			}
+			return enriched, &StageError{Stage: name, Policy: stage.OnError, Err: err}
+		}
+
+		if len(data) > 0 {
+			enriched.EnrichedData[name] = data
+		}
+	}
+
+	return enriched, nil
+}
+
+// run runs the stage within its timeout. The stage works on a copy of the
+// event, kept only when it finishes in time, so a stage that ignores ctx
+// and is abandoned cannot change the event later stages are working on.
+func (s Stage) run(ctx context.Context, event *models.EnrichedEvent) (map[string]interface{}, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		data map[string]interface{}
+		err  error
+	}
+	working := cloneEvent(*event)
+	done := make(chan result, 1)
+	go func() {
+		data, err := s.Enricher.Enrich(ctx, &working)
+		done <- result{data, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err == nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		*event = working
+		return res.data, res.err
+	}
+}
+
+// cloneEvent copies the payload and enriched data of event deeply enough
+// that changes to the copy do not reach the original.
+func cloneEvent(event models.EnrichedEvent) models.EnrichedEvent {
+	event.Payload, _ = cloneValue(event.Payload).(map[string]interface{})
+	event.EnrichedData, _ = cloneValue(event.EnrichedData).(map[string]interface{})
+	return event
+}
+
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make(map[string]interface{}, len(v))
+		for key, entry := range v {
+			clone[key] = cloneValue(entry)
+		}
+		return clone
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make([]interface{}, len(v))
+		for i, entry := range v {
+			clone[i] = cloneValue(entry)
+		}
+		return clone
+	default:
+		return value
+	}
+}
+
+func (s Stage) matches(event models.WebhookEvent) bool {
+	return matchAny(s.Platforms, event.Platform) && matchAny(s.EventTypes, event.EventType)
+}
+
+// matchAny reports whether value matches one of the patterns. An empty list
+// matches everything and a trailing "*" matches by prefix.
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == value {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
@@ -43,10 +44,30 @@ func (p *Producer) Send(ctx context.Context, key string, value interface{}) erro
 	return nil
 }
 
+// Delete writes a tombstone for key, which removes it from compacted topics.
+func (p *Producer) Delete(ctx context.Context, key string) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key)})
+}
+
 func (p *Producer) Close() error {
 	return p.writer.Close()
 }
 
+// NewCompactedProducer writes to a compacted topic used as a changelog.
+// Writes are acknowledged by all replicas and not held back for batching.
+func NewCompactedProducer(broker, topic string) *Producer {
+	return &Producer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(broker),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafka.RequireAll,
+		},
+		topic: topic,
+	}
+}
+
 type Consumer struct {
 	reader *kafka.Reader
 }
@@ -62,6 +83,21 @@ func NewConsumer(broker, topic, groupID string) *Consumer {
 	}
 }
 
+// NewTableConsumer reads a compacted topic from the first offset without a
+// consumer group, so every start rebuilds the full table. Compacted topics
+// are created with a single partition.
+func NewTableConsumer(broker, topic string) *Consumer {
+	return &Consumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     []string{broker},
+			Topic:       topic,
+			Partition:   0,
+			StartOffset: kafka.FirstOffset,
+			MaxBytes:    10e6,
+		}),
+	}
+}
+
 func (c *Consumer) Read(ctx context.Context) (kafka.Message, error) {
 	return c.reader.ReadMessage(ctx)
 }
@@ -69,3 +105,49 @@ func (c *Consumer) Read(ctx context.Context) (kafka.Message, error) {
 func (c *Consumer) Close() error {
 	return c.reader.Close()
 }
+
+// TopicReader returns a function reading topic with ReadAll, for restoring a
+// state store from the topic it is logged to.
+func TopicReader(broker, topic string) func(ctx context.Context, fn func(key, value []byte) error) error {
+	return func(ctx context.Context, fn func(key, value []byte) error) error {
+		return ReadAll(ctx, broker, topic, fn)
+	}
+}
+
+// ReadAll reads a single-partition topic from its first offset up to its
+// current end, calling fn for every message, and then returns.
+func ReadAll(ctx context.Context, broker, topic string, fn func(key, value []byte) error) error {
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, 0)
+	if err != nil {
+		return err
+	}
+	first, last, err := conn.ReadOffsets()
+	conn.Close()
+	if err != nil || last <= first {
+		return err
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   []string{broker},
+		Topic:     topic,
+		Partition: 0,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(first); err != nil {
+		return err
+	}
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg.Key, msg.Value); err != nil {
+			return err
+		}
+		if msg.Offset >= last-1 {
+			return nil
+		}
+	}
+}
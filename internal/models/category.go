@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Category is a node of the canonical category tree. Mappings lists, per
+// platform, the platform's own categories that correspond to it, such as
+// Shopify collections, BigCommerce categories or Magento category IDs;
+// products a platform files under one of them are filed under the category.
+// Aliases are other names of the category, matched when a platform names a
+// category without an ID, such as a Shopify product type.
+type Category struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	ParentID  string              `json:"parent_id,omitempty"`
+	Path      string              `json:"path,omitempty"`
+	Aliases   []string            `json:"aliases,omitempty"`
+	Mappings  map[string][]string `json:"mappings,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// CategoryRef is a category as a platform refers to it, by its own ID, by
+// name, or both.
+type CategoryRef struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+type Refund struct {
+	ID           string    `json:"id"`
+	OrderID      string    `json:"order_id"`
+	Platform     string    `json:"platform"`
+	Amount       float64   `json:"amount"`
+	Reason       string    `json:"reason"`
+	Items        []Item    `json:"items"`
+	CreatedAt    time.Time `json:"created_at"`
+	Destinations []string  `json:"destinations,omitempty"`
+}
+
+type Return struct {
+	ID           string    `json:"id"`
+	OrderID      string    `json:"order_id"`
+	Platform     string    `json:"platform"`
+	Status       string    `json:"status"`
+	Reason       string    `json:"reason"`
+	Items        []Item    `json:"items"`
+	CreatedAt    time.Time `json:"created_at"`
+	Destinations []string  `json:"destinations,omitempty"`
+}
+
+type Fulfillment struct {
+	ID           string    `json:"id"`
+	OrderID      string    `json:"order_id"`
+	Platform     string    `json:"platform"`
+	Status       string    `json:"status"`
+	LocationID   string    `json:"location_id"`
+	Items        []Item    `json:"items"`
+	CreatedAt    time.Time `json:"created_at"`
+	Destinations []string  `json:"destinations,omitempty"`
+}
+
+type Shipment struct {
+	ID             string     `json:"id"`
+	OrderID        string     `json:"order_id"`
+	FulfillmentID  string     `json:"fulfillment_id"`
+	Platform       string     `json:"platform"`
+	Carrier        string     `json:"carrier"`
+	TrackingNumber string     `json:"tracking_number"`
+	TrackingURL    string     `json:"tracking_url"`
+	Status         string     `json:"status"`
+	ShippedAt      *time.Time `json:"shipped_at,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Destinations   []string   `json:"destinations,omitempty"`
+}
+
+// OrderEventRef holds the fields shared by refund, return, fulfillment and
+// shipment messages, which is all a connector needs to route them.
+type OrderEventRef struct {
+	ID           string   `json:"id"`
+	OrderID      string   `json:"order_id"`
+	Platform     string   `json:"platform"`
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+// RoutesTo reports whether the event is for destination. Events published
+// before routing existed only go back to their origin platform.
+func (r OrderEventRef) RoutesTo(destination string) bool {
+	return routesTo(r.Platform, r.Destinations, destination)
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// InventoryLevel is the stock of one SKU across all locations. Levels are
+// published on the compacted inventory topic, keyed by SKU, whenever they
+// change.
+//
+// Reserved counts units held for open orders. Reservations for orders not
+// yet assigned to a location only count towards the SKU total, so Reserved
+// can exceed the sum over Locations.
+type InventoryLevel struct {
+	SKU       string                   `json:"sku"`
+	OnHand    int                      `json:"on_hand"`
+	Reserved  int                      `json:"reserved"`
+	Available int                      `json:"available"`
+	Locations map[string]LocationStock `json:"locations"`
+	UpdatedAt time.Time                `json:"updated_at"`
+}
+
+// LocationStock is the stock of a SKU at one location, such as an MSI
+// source.
+type LocationStock struct {
+	OnHand    int `json:"on_hand"`
+	Reserved  int `json:"reserved"`
+	Available int `json:"available"`
+}
+
+// Inventory adjustment reasons.
+const (
+	AdjustmentManual      = "adjustment"
+	AdjustmentCount       = "count"
+	AdjustmentSourceSync  = "source_sync"
+	AdjustmentFulfillment = "fulfillment"
+)
+
+// InventoryAdjustment is one entry of the append-only inventory ledger: a
+// change of the on-hand stock of a SKU at a location. OnHand is the stock
+// at the location after the change.
+type InventoryAdjustment struct {
+	ID         string    `json:"id"`
+	SKU        string    `json:"sku"`
+	LocationID string    `json:"location_id"`
+	Delta      int       `json:"delta"`
+	OnHand     int       `json:"on_hand"`
+	Reason     string    `json:"reason"`
+	Reference  string    `json:"reference,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
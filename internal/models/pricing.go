@@ -0,0 +1,56 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// PriceList sets the price of SKUs, in Currency, for a scope of sales
+// between StartsAt and EndsAt. An empty Platform, Channel or CustomerGroup
+// matches every platform, sales channel or customer group; an unset
+// StartsAt or EndsAt leaves the range open on that side. Where several
+// lists apply, the one with the highest Priority wins, then the one with
+// the narrowest scope, then the one that started last.
+type PriceList struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name,omitempty"`
+	Platform      string           `json:"platform,omitempty"`
+	Channel       string           `json:"channel,omitempty"`
+	Currency      string           `json:"currency"`
+	CustomerGroup string           `json:"customer_group,omitempty"`
+	Priority      int              `json:"priority,omitempty"`
+	StartsAt      *time.Time       `json:"starts_at,omitempty"`
+	EndsAt        *time.Time       `json:"ends_at,omitempty"`
+	Prices        []PriceListPrice `json:"prices"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// SamePrice compares prices to the cent, as prices converted from another
+// currency may differ in the last digits.
+func SamePrice(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+// PriceListPrice is the price of a product or variant SKU in a price list.
+type PriceListPrice struct {
+	SKU   string  `json:"sku"`
+	Price float64 `json:"price"`
+}
+
+// ActiveAt reports whether the list is in effect at t.
+func (l PriceList) ActiveAt(t time.Time) bool {
+	if l.StartsAt != nil && t.Before(*l.StartsAt) {
+		return false
+	}
+	return l.EndsAt == nil || t.Before(*l.EndsAt)
+}
+
+// Price returns the price the list sets for sku.
+func (l PriceList) Price(sku string) (float64, bool) {
+	for _, entry := range l.Prices {
+		if entry.SKU == sku {
+			return entry.Price, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// StatusTransition records one accepted change of Order.Status. Transitions
+// are kept on the order and published to the order-status topic.
+type StatusTransition struct {
+	OrderID  string    `json:"order_id"`
+	Platform string    `json:"platform"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Source   string    `json:"source"`
+	EventID  string    `json:"event_id,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// OrderNote is a free-text annotation added by support staff.
+type OrderNote struct {
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type WebhookEvent struct {
 	ID          string                 `json:"id"`
@@ -18,27 +21,187 @@ type EnrichedEvent struct {
 }
 
 type Order struct {
-	ID        string    `json:"id"`
-	Platform  string    `json:"platform"`
-	UserID    string    `json:"user_id"`
-	Items     []Item    `json:"items"`
-	Total     float64   `json:"total"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            string                    `json:"id"`
+	Platform      string                    `json:"platform"`
+	Channel       string                    `json:"channel,omitempty"`
+	Destinations  []string                  `json:"destinations,omitempty"`
+	UserID        string                    `json:"user_id"`
+	Customer      *Customer                 `json:"customer,omitempty"`
+	Items         []Item                    `json:"items"`
+	Tax           float64                   `json:"tax,omitempty"`
+	Discount      float64                   `json:"discount,omitempty"`
+	Shipping      float64                   `json:"shipping,omitempty"`
+	Total         float64                   `json:"total"`
+	RefundedTotal float64                   `json:"refunded_total"`
+	Refunds       map[string]float64        `json:"refunds,omitempty"`
+	Fulfillments  map[string]map[string]int `json:"fulfillments,omitempty"`
+	Balance       float64                   `json:"balance"`
+	Status        string                    `json:"status"`
+	HeldFrom      string                    `json:"held_from,omitempty"`
+	RiskScore     float64                   `json:"risk_score,omitempty"`
+	RiskReasons   []string                  `json:"risk_reasons,omitempty"`
+	Transitions   []StatusTransition        `json:"transitions,omitempty"`
+	Notes         []OrderNote               `json:"notes,omitempty"`
+	ParentIDs     []string                  `json:"parent_ids,omitempty"`
+	ChildIDs      []string                  `json:"child_ids,omitempty"`
+	Relation      string                    `json:"relation,omitempty"`
+	LocationID    string                    `json:"location_id,omitempty"`
+	Version       int64                     `json:"version"`
+	CreatedAt     time.Time                 `json:"created_at"`
+	UpdatedAt     time.Time                 `json:"updated_at"`
+}
+
+// RoutesTo reports whether destination should receive the order. Orders
+// published before routing existed only go back to their origin platform.
+// Once an order is split or merged its child orders carry the fulfillment
+// work, so a parent only goes to its origin platform, and a child, which the
+// origin platform never saw, only goes to the other destinations.
+func (o Order) RoutesTo(destination string) bool {
+	if len(o.ChildIDs) > 0 && destination != o.Platform {
+		return false
+	}
+	if len(o.ParentIDs) > 0 && destination == o.Platform {
+		return false
+	}
+	return routesTo(o.Platform, o.Destinations, destination)
+}
+
+func routesTo(platform string, destinations []string, destination string) bool {
+	if len(destinations) == 0 {
+		return platform == destination
+	}
+	for _, d := range destinations {
+		if d == destination {
+			return true
+		}
+	}
+	return false
 }
 
 type Item struct {
 	ProductID string  `json:"product_id"`
 	Quantity  int     `json:"quantity"`
 	Price     float64 `json:"price"`
+	Fulfilled int     `json:"fulfilled,omitempty"`
+	CatalogID string  `json:"catalog_id,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Cost      float64 `json:"cost,omitempty"`
+	OrderID   string  `json:"order_id,omitempty"`
+	VariantID string  `json:"variant_id,omitempty"`
+	Variant   string  `json:"variant,omitempty"`
+}
+
+// Key identifies the line within its order: the product, and the variant
+// when the item was bought as one.
+func (i Item) Key() string {
+	if i.VariantID == "" {
+		return i.ProductID
+	}
+	return i.ProductID + "/" + i.VariantID
 }
 
+// Product types.
+const (
+	ProductSimple       = "simple"
+	ProductConfigurable = "configurable"
+	ProductBundle       = "bundle"
+)
+
+// CatalogProduct is one product merged from every platform that sells it
+// under the same canonical SKU. Sources keeps what each platform last sent,
+// so the merged fields can be recomputed when one of them changes.
+//
+// A configurable product is sold as one of its Variants, each picking a
+// value for every option. A bundle is sold as a unit made of Components,
+// which are other catalog products or variants.
+//
+// Categories are canonical category IDs; CategoryIDs lists, per platform
+// selling the product, the platform's categories they map to.
 type CatalogProduct struct {
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
+	ID          string                   `json:"id"`
+	Type        string                   `json:"type,omitempty"`
+	Name        string                   `json:"name"`
+	SKU         string                   `json:"sku"`
+	Description string                   `json:"description,omitempty"`
+	Price       float64                  `json:"price"`
+	Cost        float64                  `json:"cost"`
+	Stock       int                      `json:"stock"`
+	Options     []ProductOption          `json:"options,omitempty"`
+	Variants    []ProductVariant         `json:"variants,omitempty"`
+	Components  []BundleComponent        `json:"components,omitempty"`
+	Categories  []string                 `json:"categories,omitempty"`
+	CategoryIDs map[string][]string      `json:"category_ids,omitempty"`
+	PlatformIDs map[string]string        `json:"platform_ids"`
+	Sources     map[string]ProductSource `json:"sources,omitempty"`
+	UpdatedAt   time.Time                `json:"updated_at"`
+}
+
+// Variant returns the variant with the given canonical SKU.
+func (p CatalogProduct) Variant(sku string) (ProductVariant, bool) {
+	for _, variant := range p.Variants {
+		if variant.SKU == sku {
+			return variant, true
+		}
+	}
+	return ProductVariant{}, false
+}
+
+// CanonicalSKU is the normalized form SKUs are matched by across platforms
+// and services.
+func CanonicalSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// ProductOption is a dimension a configurable product varies in, such as
+// {Name: "Size", Values: ["S", "M", "L"]}.
+type ProductOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// ProductVariant is one purchasable combination of option values. Variants
+// are matched across platforms by canonical SKU.
+type ProductVariant struct {
 	SKU         string            `json:"sku"`
+	Title       string            `json:"title,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
 	Price       float64           `json:"price"`
+	Cost        float64           `json:"cost,omitempty"`
 	Stock       int               `json:"stock"`
-	PlatformIDs map[string]string `json:"platform_ids"`
+	PlatformIDs map[string]string `json:"platform_ids,omitempty"`
+}
+
+// BundleComponent is Quantity units of the product or variant with SKU
+// inside a bundle.
+type BundleComponent struct {
+	SKU      string `json:"sku"`
+	Quantity int    `json:"quantity"`
+}
+
+// ProductSource is a platform's own record of a product. Fields the
+// platform never sent are nil.
+type ProductSource struct {
+	ID          string            `json:"id"`
+	Name        *string           `json:"name,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Price       *float64          `json:"price,omitempty"`
+	Cost        *float64          `json:"cost,omitempty"`
+	Stock       *int              `json:"stock,omitempty"`
+	Options     []ProductOption   `json:"options,omitempty"`
+	Variants    []SourceVariant   `json:"variants,omitempty"`
+	Components  []BundleComponent `json:"components,omitempty"`
+	Categories  []CategoryRef     `json:"categories,omitempty"`
 	UpdatedAt   time.Time         `json:"updated_at"`
 }
+
+// SourceVariant is a platform's own record of a variant.
+type SourceVariant struct {
+	ID      string            `json:"id"`
+	SKU     string            `json:"sku"`
+	Title   string            `json:"title,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	Price   *float64          `json:"price,omitempty"`
+	Cost    *float64          `json:"cost,omitempty"`
+	Stock   *int              `json:"stock,omitempty"`
+}
@@ -0,0 +1,326 @@
+package orderstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"ecommerce-platform/internal/models"
+)
+
+var (
+	metaBucket    = []byte("meta")
+	ordersBucket  = []byte("orders")
+	historyBucket = []byte("history")
+	outboxBucket  = []byte("outbox")
+)
+
+// boltMigrations are applied in order; the index of the last applied one is
+// kept in the meta bucket.
+var boltMigrations = []func(tx *bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(ordersBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	},
+}
+
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(migrateBolt); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func migrateBolt(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+
+	var applied uint64
+	if value := meta.Get([]byte("schema_version")); value != nil {
+		applied = binary.BigEndian.Uint64(value)
+	}
+	for i := applied; i < uint64(len(boltMigrations)); i++ {
+		if err := boltMigrations[i](tx); err != nil {
+			return err
+		}
+	}
+
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, uint64(len(boltMigrations)))
+	return meta.Put([]byte("schema_version"), version)
+}
+
+func (s *BoltStore) Get(ctx context.Context, id string) (models.Order, error) {
+	var order models.Order
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(ordersBucket).Get([]byte(id))
+		if value == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(value, &order)
+	})
+	return order, err
+}
+
+func (s *BoltStore) Put(ctx context.Context, order *models.Order, change Change, outbox []Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBolt(tx, order, change, outbox)
+	})
+}
+
+func putBolt(tx *bolt.Tx, order *models.Order, change Change, outbox []Message) error {
+	bucket := tx.Bucket(ordersBucket)
+
+	var stored models.Order
+	if value := bucket.Get([]byte(order.ID)); value != nil {
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return err
+		}
+	}
+	if stored.Version != order.Version {
+		return ErrVersionConflict
+	}
+
+	next := *order
+	next.Version++
+	next.UpdatedAt = time.Now()
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte(order.ID), data); err != nil {
+		return err
+	}
+
+	history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(order.ID))
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(HistoryEntry{
+		OrderID: next.ID,
+		Version: next.Version,
+		Change:  change,
+		Changes: diffOrders(stored, next),
+		Order:   next,
+	})
+	if err != nil {
+		return err
+	}
+	if err := history.Put(versionKey(next.Version), entry); err != nil {
+		return err
+	}
+	if err := enqueueBolt(tx, prepare(outbox, data, next.ID, next.UpdatedAt)); err != nil {
+		return err
+	}
+
+	*order = next
+	return nil
+}
+
+func versionKey(version int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(version))
+	return key
+}
+
+func (s *BoltStore) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if history == nil {
+			return ErrNotFound
+		}
+		return history.ForEach(func(key, value []byte) error {
+			var entry HistoryEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (s *BoltStore) Reset(ctx context.Context) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{ordersBucket, historyBucket, outboxBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List scans every order; the embedded store is meant for single-node
+// deployments where the order count stays small.
+func (s *BoltStore) List(ctx context.Context, query Query) (Page, error) {
+	if err := query.Normalize(); err != nil {
+		return Page{}, err
+	}
+	position, err := parseCursor(query.Cursor, query.SortBy)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var orders []models.Order
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ordersBucket).ForEach(func(key, value []byte) error {
+			var order models.Order
+			if err := json.Unmarshal(value, &order); err != nil {
+				return err
+			}
+			if query.matches(order) && afterCursor(order, position, query.SortBy, query.Descending) {
+				orders = append(orders, order)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return Page{}, err
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		c := compareOrders(orders[i], orders[j], query.SortBy)
+		if query.Descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	page := Page{Orders: orders}
+	if len(orders) > query.Limit {
+		page.Orders = orders[:query.Limit]
+		page.NextCursor = newCursor(page.Orders[query.Limit-1], query.SortBy)
+	}
+	return page, nil
+}
+
+func enqueueBolt(tx *bolt.Tx, messages []Message) error {
+	bucket := tx.Bucket(outboxBucket)
+	for _, message := range messages {
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		message.ID = int64(id)
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(versionKey(message.ID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) EnqueueOutbox(ctx context.Context, messages []Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return enqueueBolt(tx, prepare(messages, nil, "", time.Now()))
+	})
+}
+
+func (s *BoltStore) PendingOutbox(ctx context.Context, limit int) ([]Message, error) {
+	var messages []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for key, value := cursor.First(); key != nil && len(messages) < limit; key, value = cursor.Next() {
+			var message Message
+			if err := json.Unmarshal(value, &message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+// MarkOutboxSent removes sent messages; the embedded store keeps no record
+// of them.
+func (s *BoltStore) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		for _, id := range ids {
+			if err := bucket.Delete(versionKey(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) MarkOutboxFailed(ctx context.Context, id int64, cause error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		value := bucket.Get(versionKey(id))
+		if value == nil {
+			return nil
+		}
+		var message Message
+		if err := json.Unmarshal(value, &message); err != nil {
+			return err
+		}
+		message.Attempts++
+		message.LastError = cause.Error()
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(versionKey(id), data)
+	})
+}
+
+func (s *BoltStore) OutboxStats(ctx context.Context) (OutboxStats, error) {
+	var stats OutboxStats
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		stats.Pending = bucket.Stats().KeyN
+		_, value := bucket.Cursor().First()
+		if value == nil {
+			return nil
+		}
+		var oldest Message
+		if err := json.Unmarshal(value, &oldest); err != nil {
+			return err
+		}
+		stats.Oldest = oldest.CreatedAt
+		stats.Attempts = oldest.Attempts
+		stats.LastError = oldest.LastError
+		return nil
+	})
+	return stats, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,123 @@
+package orderstore
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+// Change describes what caused a write: the webhook event or API call and
+// when it happened. Every Put appends it to the order's history.
+type Change struct {
+	EventID   string    `json:"event_id,omitempty"`
+	EventType string    `json:"event_type"`
+	Source    string    `json:"source"`
+	At        time.Time `json:"at"`
+}
+
+// HistoryEntry is one append-only record of an order write. Version is the
+// order version the write produced, so entries of an order are numbered
+// 1, 2, 3, ... Order holds the full state after the write.
+type HistoryEntry struct {
+	OrderID string `json:"order_id"`
+	Version int64  `json:"version"`
+	Change  `json:"change"`
+	Changes []FieldChange `json:"changes"`
+	Order   models.Order  `json:"order"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// AsOf rebuilds the order as it stood at the given time from its history:
+// the state after the last write whose change happened at or before at.
+func AsOf(ctx context.Context, store Store, id string, at time.Time) (models.Order, error) {
+	entries, err := store.History(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	var order *models.Order
+	for i := range entries {
+		if !entries[i].At.After(at) {
+			order = &entries[i].Order
+		}
+	}
+	if order == nil {
+		return models.Order{}, ErrNotFound
+	}
+	return *order, nil
+}
+
+// ignoredFields change on every write and would only add noise to diffs.
+var ignoredFields = map[string]bool{"version": true, "updated_at": true}
+
+// diffOrders lists the fields that differ between before and after, using
+// dotted JSON paths with array indexes, e.g. "items.0.fulfilled".
+func diffOrders(before, after models.Order) []FieldChange {
+	old := flattenOrder(before)
+	current := flattenOrder(after)
+
+	fields := make(map[string]bool)
+	for field := range old {
+		fields[field] = true
+	}
+	for field := range current {
+		fields[field] = true
+	}
+
+	changes := []FieldChange{}
+	for field := range fields {
+		if ignoredFields[field] {
+			continue
+		}
+		if !reflect.DeepEqual(old[field], current[field]) {
+			changes = append(changes, FieldChange{Field: field, From: old[field], To: current[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func flattenOrder(order models.Order) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if order.ID == "" && order.Version == 0 {
+		return fields
+	}
+
+	data, _ := json.Marshal(order)
+	var value interface{}
+	json.Unmarshal(data, &value)
+	flatten("", value, fields)
+	return fields
+}
+
+func flatten(prefix string, value interface{}, fields map[string]interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			flatten(join(key), child, fields)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flatten(join(strconv.Itoa(i)), child, fields)
+		}
+	default:
+		fields[prefix] = v
+	}
+}
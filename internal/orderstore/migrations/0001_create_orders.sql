@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS orders (
+    id         TEXT PRIMARY KEY,
+    platform   TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    user_id    TEXT NOT NULL DEFAULT '',
+    total      NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    version    BIGINT NOT NULL,
+    data       JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS orders_platform_idx ON orders (platform);
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status);
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+CREATE INDEX IF NOT EXISTS orders_created_at_idx ON orders (created_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS order_history (
+    order_id   TEXT NOT NULL,
+    version    BIGINT NOT NULL,
+    event_id   TEXT NOT NULL DEFAULT '',
+    event_type TEXT NOT NULL,
+    source     TEXT NOT NULL,
+    at         TIMESTAMPTZ NOT NULL,
+    changes    JSONB NOT NULL,
+    data       JSONB NOT NULL,
+    PRIMARY KEY (order_id, version)
+);
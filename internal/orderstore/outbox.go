@@ -0,0 +1,54 @@
+package orderstore
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Message is an outbox row: a Kafka message written in the same
+// transaction as the order change it announces, and published later by a
+// relay in ID order.
+type Message struct {
+	ID        int64           `json:"id"`
+	Topic     string          `json:"topic"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+
+	// order makes Put fill in Key and Value from the order as written,
+	// including its new version.
+	order bool
+}
+
+func NewMessage(topic, key string, value interface{}) Message {
+	data, _ := json.Marshal(value)
+	return Message{Topic: topic, Key: key, Value: data}
+}
+
+// OrderMessage publishes the order being written to topic, keyed by its ID.
+func OrderMessage(topic string) Message {
+	return Message{Topic: topic, order: true}
+}
+
+type OutboxStats struct {
+	Pending   int       `json:"pending"`
+	Oldest    time.Time `json:"oldest,omitempty"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// prepare resolves order messages against the order as written.
+func prepare(messages []Message, data []byte, id string, now time.Time) []Message {
+	prepared := make([]Message, len(messages))
+	for i, message := range messages {
+		if message.order {
+			message.Key = id
+			message.Value = data
+		}
+		message.CreatedAt = now
+		prepared[i] = message
+	}
+	return prepared
+}
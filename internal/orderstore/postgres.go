@@ -0,0 +1,411 @@
+package orderstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"ecommerce-platform/internal/models"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func OpenPostgres(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// The database may still be starting when the service comes up.
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil || attempt == 10 {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+// migratePostgres applies every embedded migration not yet recorded in
+// schema_migrations, in file name order.
+func migratePostgres(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		name       TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var applied bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE name = $1)`, name).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + name)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(script)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES ($1)`, name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (models.Order, error) {
+	var order models.Order
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM orders WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, ErrNotFound
+	}
+	if err != nil {
+		return order, err
+	}
+	err = json.Unmarshal(data, &order)
+	return order, err
+}
+
+func (s *PostgresStore) Put(ctx context.Context, order *models.Order, change Change, outbox []Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stored models.Order
+	var current []byte
+	err = tx.QueryRowContext(ctx, `SELECT data FROM orders WHERE id = $1 FOR UPDATE`, order.ID).Scan(&current)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(current, &stored); err != nil {
+			return err
+		}
+	}
+	if stored.Version != order.Version {
+		return ErrVersionConflict
+	}
+
+	next := *order
+	next.Version++
+	next.UpdatedAt = time.Now()
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+
+	var result sql.Result
+	if order.Version == 0 {
+		result, err = tx.ExecContext(ctx, `
+			INSERT INTO orders (id, platform, status, user_id, total, created_at, updated_at, version, data)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (id) DO NOTHING`,
+			next.ID, next.Platform, next.Status, next.UserID, next.Total, next.CreatedAt, next.UpdatedAt, next.Version, data)
+	} else {
+		result, err = tx.ExecContext(ctx, `
+			UPDATE orders
+			SET platform = $2, status = $3, user_id = $4, total = $5, created_at = $6, updated_at = $7, version = $8, data = $9
+			WHERE id = $1 AND version = $10`,
+			next.ID, next.Platform, next.Status, next.UserID, next.Total, next.CreatedAt, next.UpdatedAt, next.Version, data, order.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrVersionConflict
+	}
+
+	changes, err := json.Marshal(diffOrders(stored, next))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO order_history (order_id, version, event_id, event_type, source, at, changes, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		next.ID, next.Version, change.EventID, change.EventType, change.Source, change.At, changes, data); err != nil {
+		return err
+	}
+	if err := enqueuePostgres(ctx, tx, prepare(outbox, data, next.ID, next.UpdatedAt)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*order = next
+	return nil
+}
+
+func (s *PostgresStore) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT version, event_id, event_type, source, at, changes, data
+		FROM order_history WHERE order_id = $1 ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		entry := HistoryEntry{OrderID: id}
+		var changes, data []byte
+		if err := rows.Scan(&entry.Version, &entry.EventID, &entry.EventType, &entry.Source, &entry.At, &changes, &data); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &entry.Order); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+func (s *PostgresStore) Reset(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `TRUNCATE orders, order_history, outbox`)
+	return err
+}
+
+func enqueuePostgres(ctx context.Context, tx *sql.Tx, messages []Message) error {
+	for _, message := range messages {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO outbox (topic, key, value, created_at) VALUES ($1, $2, $3, $4)`,
+			message.Topic, message.Key, []byte(message.Value), message.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) EnqueueOutbox(ctx context.Context, messages []Message) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := enqueuePostgres(ctx, tx, prepare(messages, nil, "", time.Now())); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) PendingOutbox(ctx context.Context, limit int) ([]Message, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, topic, key, value, created_at, attempts, last_error
+		FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var message Message
+		var value []byte
+		if err := rows.Scan(&message.ID, &message.Topic, &message.Key, &value, &message.CreatedAt, &message.Attempts, &message.LastError); err != nil {
+			return nil, err
+		}
+		message.Value = value
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// MarkOutboxSent stamps sent messages and drops those sent more than a day
+// ago, which are only kept to help trace recent deliveries.
+func (s *PostgresStore) MarkOutboxSent(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, `UPDATE outbox SET sent_at = now() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE sent_at < now() - interval '1 day'`)
+	return err
+}
+
+func (s *PostgresStore) MarkOutboxFailed(ctx context.Context, id int64, cause error) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1`, id, cause.Error())
+	return err
+}
+
+func (s *PostgresStore) OutboxStats(ctx context.Context) (OutboxStats, error) {
+	var stats OutboxStats
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM outbox WHERE sent_at IS NULL`).Scan(&stats.Pending); err != nil {
+		return stats, err
+	}
+	if stats.Pending == 0 {
+		return stats, nil
+	}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT created_at, attempts, last_error FROM outbox
+		WHERE sent_at IS NULL ORDER BY id LIMIT 1`).Scan(&stats.Oldest, &stats.Attempts, &stats.LastError)
+	return stats, err
+}
+
+func (s *PostgresStore) List(ctx context.Context, query Query) (Page, error) {
+	if err := query.Normalize(); err != nil {
+		return Page{}, err
+	}
+	position, err := parseCursor(query.Cursor, query.SortBy)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, values ...interface{}) {
+		for _, value := range values {
+			args = append(args, value)
+			condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), 1)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if query.Platform != "" {
+		where("platform = ?", query.Platform)
+	}
+	if query.Status != "" {
+		where("status = ?", query.Status)
+	}
+	if query.CustomerID != "" {
+		where("user_id = ?", query.CustomerID)
+	}
+	if !query.CreatedAfter.IsZero() {
+		where("created_at >= ?", query.CreatedAfter)
+	}
+	if !query.CreatedBefore.IsZero() {
+		where("created_at < ?", query.CreatedBefore)
+	}
+	if query.MinTotal != nil {
+		where("total >= ?", *query.MinTotal)
+	}
+	if query.MaxTotal != nil {
+		where("total <= ?", *query.MaxTotal)
+	}
+
+	direction, operator := "ASC", ">"
+	if query.Descending {
+		direction, operator = "DESC", "<"
+	}
+	if position != nil {
+		var value interface{} = position.Time
+		if query.SortBy == "total" {
+			value = position.Total
+		}
+		where(fmt.Sprintf("(%s, id) %s (?, ?)", query.SortBy, operator), value, position.ID)
+	}
+
+	statement := "SELECT data, created_at, updated_at, total FROM orders"
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// SortBy is checked against sortColumns by Normalize.
+	statement += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", query.SortBy, direction, direction, query.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	defer rows.Close()
+
+	// The cursor is built from the columns rather than the order data:
+	// Postgres keeps times to the microsecond and totals to the cent, so a
+	// cursor from the data would not match the row it was taken from.
+	var orders, positions []models.Order
+	for rows.Next() {
+		var data []byte
+		var position models.Order
+		if err := rows.Scan(&data, &position.CreatedAt, &position.UpdatedAt, &position.Total); err != nil {
+			return Page{}, err
+		}
+		var order models.Order
+		if err := json.Unmarshal(data, &order); err != nil {
+			return Page{}, err
+		}
+		position.ID = order.ID
+		orders = append(orders, order)
+		positions = append(positions, position)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, err
+	}
+
+	page := Page{Orders: orders}
+	if len(orders) > query.Limit {
+		page.Orders = orders[:query.Limit]
+		page.NextCursor = newCursor(positions[query.Limit-1], query.SortBy)
+	}
+	return page, nil
+}
+
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,165 @@
+package orderstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+var ErrInvalidQuery = errors.New("invalid order query")
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// Query selects a page of orders. Zero-valued filters match everything.
+// Results are ordered by SortBy, then by ID so that the cursor is stable
+// when several orders share the same sort value.
+type Query struct {
+	Platform      string
+	Status        string
+	CustomerID    string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	MinTotal      *float64
+	MaxTotal      *float64
+
+	SortBy     string
+	Descending bool
+	Limit      int
+	Cursor     string
+}
+
+type Page struct {
+	Orders     []models.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+var sortColumns = map[string]bool{"created_at": true, "updated_at": true, "total": true}
+
+// Normalize fills in defaults and rejects unknown sort fields or limits out
+// of range.
+func (q *Query) Normalize() error {
+	if q.SortBy == "" {
+		q.SortBy = "created_at"
+	}
+	if !sortColumns[q.SortBy] {
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, q.SortBy)
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxLimit)
+	}
+	return nil
+}
+
+// cursor is the sort position of the last order on a page.
+type cursor struct {
+	SortBy string    `json:"s"`
+	Time   time.Time `json:"t,omitempty"`
+	Total  float64   `json:"n,omitempty"`
+	ID     string    `json:"id"`
+}
+
+func newCursor(order models.Order, sortBy string) string {
+	c := cursor{SortBy: sortBy, ID: order.ID}
+	switch sortBy {
+	case "created_at":
+		c.Time = order.CreatedAt
+	case "updated_at":
+		c.Time = order.UpdatedAt
+	case "total":
+		c.Total = order.Total
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseCursor(value, sortBy string) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if c.SortBy != sortBy {
+		return nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidQuery, c.SortBy)
+	}
+	return &c, nil
+}
+
+// matches reports whether order passes the query filters.
+func (q Query) matches(order models.Order) bool {
+	if q.Platform != "" && order.Platform != q.Platform {
+		return false
+	}
+	if q.Status != "" && order.Status != q.Status {
+		return false
+	}
+	if q.CustomerID != "" && order.UserID != q.CustomerID {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && order.CreatedAt.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !order.CreatedAt.Before(q.CreatedBefore) {
+		return false
+	}
+	if q.MinTotal != nil && order.Total < *q.MinTotal {
+		return false
+	}
+	if q.MaxTotal != nil && order.Total > *q.MaxTotal {
+		return false
+	}
+	return true
+}
+
+// compareOrders orders a before b (negative), after b (positive) or equal
+// (zero) by the sort field and then by ID.
+func compareOrders(a, b models.Order, sortBy string) int {
+	var c int
+	switch sortBy {
+	case "created_at":
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case "total":
+		switch {
+		case a.Total < b.Total:
+			c = -1
+		case a.Total > b.Total:
+			c = 1
+		}
+	}
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// afterCursor reports whether order comes after the cursor position in the
+// requested direction.
+func afterCursor(order models.Order, c *cursor, sortBy string, descending bool) bool {
+	if c == nil {
+		return true
+	}
+	position := models.Order{ID: c.ID, CreatedAt: c.Time, UpdatedAt: c.Time, Total: c.Total}
+	cmp := compareOrders(order, position, sortBy)
+	if descending {
+		return cmp < 0
+	}
+	return cmp > 0
+}
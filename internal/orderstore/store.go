@@ -0,0 +1,86 @@
+package orderstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ecommerce-platform/internal/models"
+)
+
+var (
+	ErrNotFound        = errors.New("order not found")
+	ErrVersionConflict = errors.New("order version conflict")
+)
+
+// Store persists orders keyed by ID. Put is an upsert guarded by optimistic
+// concurrency: it succeeds only when the stored version still equals
+// order.Version (0 for an order that does not exist yet), and on success
+// increments order.Version. In the same transaction Put appends a history
+// entry recording change and the fields it modified, and adds outbox to the
+// outbox.
+type Store interface {
+	Get(ctx context.Context, id string) (models.Order, error)
+	Put(ctx context.Context, order *models.Order, change Change, outbox []Message) error
+	List(ctx context.Context, query Query) (Page, error)
+	History(ctx context.Context, id string) ([]HistoryEntry, error)
+	// Reset deletes every order, its history and the outbox, sent or not,
+	// ahead of a rebuild. Callers check that PendingOutbox is empty first.
+	Reset(ctx context.Context) error
+
+	// EnqueueOutbox adds messages that accompany no order change.
+	EnqueueOutbox(ctx context.Context, messages []Message) error
+	// PendingOutbox returns up to limit unsent messages, oldest first.
+	PendingOutbox(ctx context.Context, limit int) ([]Message, error)
+	MarkOutboxSent(ctx context.Context, ids []int64) error
+	MarkOutboxFailed(ctx context.Context, id int64, cause error) error
+	OutboxStats(ctx context.Context) (OutboxStats, error)
+	Close() error
+}
+
+// Open returns the store selected by kind: "bolt" for the embedded store at
+// path, or "postgres" for the database at dsn.
+func Open(kind, path, dsn string) (Store, error) {
+	switch kind {
+	case "bolt", "":
+		return OpenBolt(path)
+	case "postgres":
+		return OpenPostgres(dsn)
+	}
+	return nil, fmt.Errorf("unknown order store %q", kind)
+}
+
+// maxUpdateAttempts bounds how often Update retries a write that keeps
+// losing to concurrent writers.
+const maxUpdateAttempts = 100
+
+// Update reads the order, applies fn and writes it back together with the
+// messages fn returns, retrying when a concurrent writer bumped the version
+// in between. fn receives a zero order with only the ID set when the order
+// does not exist yet. It gives up with ErrVersionConflict after
+// maxUpdateAttempts tries, and with the context's error once ctx is done.
+func Update(ctx context.Context, store Store, id string, change Change, fn func(order *models.Order) ([]Message, error)) (models.Order, error) {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return models.Order{ID: id}, err
+		}
+
+		order, err := store.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			order = models.Order{ID: id}
+		} else if err != nil {
+			return order, err
+		}
+
+		outbox, err := fn(&order)
+		if err != nil {
+			return order, err
+		}
+
+		err = store.Put(ctx, &order, change, outbox)
+		if errors.Is(err, ErrVersionConflict) && attempt < maxUpdateAttempts {
+			continue
+		}
+		return order, err
+	}
+}
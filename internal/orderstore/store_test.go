@@ -0,0 +1,304 @@
+package orderstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+// testStores runs fn against every backend: the bolt store in a temporary
+// file, and the Postgres store when ORDERSTORE_TEST_DSN points at a
+// database, such as the postgres container of docker-compose.yml. The
+// Postgres database is emptied first.
+func testStores(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("bolt", func(t *testing.T) {
+		store, err := OpenBolt(filepath.Join(t.TempDir(), "orders.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		fn(t, store)
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("ORDERSTORE_TEST_DSN")
+		if dsn == "" {
+			t.Skip("ORDERSTORE_TEST_DSN not set")
+		}
+		store, err := OpenPostgres(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.Reset(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		fn(t, store)
+	})
+}
+
+func testChange(eventType string) Change {
+	return Change{EventType: eventType, Source: "test", At: time.Now()}
+}
+
+func TestPutAndGet(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get missing order: got %v, want ErrNotFound", err)
+		}
+
+		order := models.Order{ID: "o-1", Platform: "shopify", Status: "pending", Total: 10, CreatedAt: time.Now()}
+		if err := store.Put(ctx, &order, testChange("order.created"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if order.Version != 1 {
+			t.Fatalf("version after create = %d, want 1", order.Version)
+		}
+
+		stale := order
+		stale.Version = 0
+		if err := store.Put(ctx, &stale, testChange("order.created"), nil); !errors.Is(err, ErrVersionConflict) {
+			t.Fatalf("Put with stale version: got %v, want ErrVersionConflict", err)
+		}
+
+		order.Total = 12
+		if err := store.Put(ctx, &order, testChange("order.updated"), nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(ctx, "o-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != 2 || got.Total != 12 {
+			t.Fatalf("stored order = version %d total %v, want version 2 total 12", got.Version, got.Total)
+		}
+	})
+}
+
+func TestUpdateRetriesConflicts(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		const writers = 8
+
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := Update(ctx, store, "o-1", testChange("order.updated"), func(order *models.Order) ([]Message, error) {
+					order.Total++
+					return nil, nil
+				})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		order, err := store.Get(ctx, "o-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Total != writers || order.Version != writers {
+			t.Fatalf("order = total %v version %d, want %d and %d", order.Total, order.Version, writers, writers)
+		}
+	})
+}
+
+// conflictingStore loses every write to a concurrent writer.
+type conflictingStore struct {
+	Store
+	puts int
+}
+
+func (s *conflictingStore) Put(ctx context.Context, order *models.Order, change Change, outbox []Message) error {
+	s.puts++
+	return ErrVersionConflict
+}
+
+func TestUpdateGivesUp(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		conflicting := &conflictingStore{Store: store}
+		noop := func(order *models.Order) ([]Message, error) { return nil, nil }
+
+		_, err := Update(context.Background(), conflicting, "o-1", testChange("order.updated"), noop)
+		if !errors.Is(err, ErrVersionConflict) || conflicting.puts != maxUpdateAttempts {
+			t.Fatalf("Update under constant conflicts: got %v after %d puts, want ErrVersionConflict after %d", err, conflicting.puts, maxUpdateAttempts)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		conflicting.puts = 0
+		_, err = Update(ctx, conflicting, "o-1", testChange("order.updated"), noop)
+		if !errors.Is(err, context.Canceled) || conflicting.puts != 0 {
+			t.Fatalf("Update with cancelled context: got %v after %d puts, want context.Canceled", err, conflicting.puts)
+		}
+	})
+}
+
+func TestHistoryAndAsOf(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		created := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+		order := models.Order{ID: "o-1", Platform: "shopify", Status: "pending", Total: 10, CreatedAt: created}
+		if err := store.Put(ctx, &order, Change{EventType: "order.created", Source: "test", At: created}, nil); err != nil {
+			t.Fatal(err)
+		}
+		order.Status = "paid"
+		if err := store.Put(ctx, &order, Change{EventType: "order.updated", Source: "test", At: created.Add(time.Minute)}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := store.History(ctx, "o-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Version != 1 || entries[1].Version != 2 {
+			t.Fatalf("history = %+v, want versions 1 and 2", entries)
+		}
+		changes := entries[1].Changes
+		if len(changes) != 1 || changes[0].Field != "status" || changes[0].From != "pending" || changes[0].To != "paid" {
+			t.Fatalf("changes of second write = %+v, want status pending → paid", changes)
+		}
+
+		before, err := AsOf(ctx, store, "o-1", created.Add(30*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before.Status != "pending" {
+			t.Fatalf("status as of before the update = %q, want pending", before.Status)
+		}
+		if _, err := AsOf(ctx, store, "o-1", created.Add(-time.Second)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("AsOf before creation: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		order := models.Order{ID: "o-1", Platform: "shopify", Status: "pending", CreatedAt: time.Now()}
+		outbox := []Message{OrderMessage("orders"), NewMessage("order-status", "o-1", map[string]string{"to": "pending"})}
+		if err := store.Put(ctx, &order, testChange("order.created"), outbox); err != nil {
+			t.Fatal(err)
+		}
+
+		pending, err := store.PendingOutbox(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 2 || pending[0].Topic != "orders" || pending[0].Key != "o-1" {
+			t.Fatalf("pending outbox = %+v, want the order on orders then the status", pending)
+		}
+		var published models.Order
+		if err := json.Unmarshal(pending[0].Value, &published); err != nil {
+			t.Fatal(err)
+		}
+		if published.Version != 1 {
+			t.Fatalf("published order version = %d, want the version written, 1", published.Version)
+		}
+
+		if err := store.MarkOutboxFailed(ctx, pending[1].ID, errors.New("broker down")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.MarkOutboxSent(ctx, []int64{pending[0].ID}); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := store.OutboxStats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Pending != 1 || stats.Attempts != 1 || stats.LastError != "broker down" {
+			t.Fatalf("outbox stats = %+v, want one pending message with one failed attempt", stats)
+		}
+	})
+}
+
+func TestListPages(t *testing.T) {
+	testStores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		// Nanosecond times and sub-cent totals are finer than Postgres keeps
+		// them; the cursor must still land on the stored values.
+		base := time.Now().Add(-time.Hour).Truncate(time.Second).Add(123456789)
+
+		for i, id := range []string{"o-1", "o-2", "o-3", "o-4", "o-5"} {
+			platform := "shopify"
+			if i%2 == 1 {
+				platform = "magento"
+			}
+			order := models.Order{ID: id, Platform: platform, Status: "pending", Total: float64(10*(i+1)) + 0.004, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+			if err := store.Put(ctx, &order, testChange("order.created"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, query := range []Query{
+			{SortBy: "created_at", Descending: true, Limit: 2},
+			{SortBy: "total", Descending: true, Limit: 2},
+		} {
+			var ids []string
+			for {
+				page, err := store.List(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, order := range page.Orders {
+					ids = append(ids, order.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+			if want := []string{"o-5", "o-4", "o-3", "o-2", "o-1"}; !equalIDs(ids, want) {
+				t.Fatalf("ids paged by %s = %v, want %v", query.SortBy, ids, want)
+			}
+		}
+
+		minTotal := 20.0
+		page, err := store.List(ctx, Query{Platform: "shopify", MinTotal: &minTotal, SortBy: "total"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, order := range page.Orders {
+			ids = append(ids, order.ID)
+		}
+		if want := []string{"o-3", "o-5"}; !equalIDs(ids, want) {
+			t.Fatalf("filtered ids = %v, want %v", ids, want)
+		}
+
+		if _, err := store.List(ctx, Query{SortBy: "status"}); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("List with unknown sort: got %v, want ErrInvalidQuery", err)
+		}
+	})
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package payload
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Rewrite applies fn to every value found at the dotted path in payload and
+// stores the result in place. Arrays along the path are traversed, so
+// "order.items.price" visits the price of every line item. fn receives the
+// concrete path of each value, e.g. "order.items.1.price".
+func Rewrite(payload map[string]interface{}, path string, fn func(path string, value interface{}) interface{}) {
+	rewrite(payload, strings.Split(path, "."), "", fn)
+}
+
+func rewrite(node map[string]interface{}, keys []string, prefix string, fn func(string, interface{}) interface{}) {
+	key := keys[0]
+	value, ok := node[key]
+	if !ok {
+		return
+	}
+	path := joinPath(prefix, key)
+
+	if len(keys) == 1 {
+		if list, ok := value.([]interface{}); ok {
+			for i := range list {
+				list[i] = fn(joinPath(path, strconv.Itoa(i)), list[i])
+			}
+			return
+		}
+		node[key] = fn(path, value)
+		return
+	}
+
+	switch child := value.(type) {
+	case map[string]interface{}:
+		rewrite(child, keys[1:], path, fn)
+	case []interface{}:
+		for i, entry := range child {
+			if m, ok := entry.(map[string]interface{}); ok {
+				rewrite(m, keys[1:], joinPath(path, strconv.Itoa(i)), fn)
+			}
+		}
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// RewriteStrings applies fn to every string leaf of payload and stores the
+// result in place.
+func RewriteStrings(payload map[string]interface{}, fn func(path, value string) string) {
+	rewriteStrings(payload, "", fn)
+}
+
+func rewriteStrings(node interface{}, path string, fn func(string, string) string) interface{} {
+	switch value := node.(type) {
+	case string:
+		return fn(path, value)
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = rewriteStrings(child, joinPath(path, key), fn)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = rewriteStrings(child, joinPath(path, strconv.Itoa(i)), fn)
+		}
+	}
+	return node
+}
+
+// Lookup returns the value at a dotted path of nested objects.
+func Lookup(payload map[string]interface{}, path string) (interface{}, bool) {
+	var node interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
@@ -0,0 +1,93 @@
+package pii
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Detector finds PII of one kind inside free-form strings.
+type Detector struct {
+	Kind    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultDetectors find emails and phone numbers. The phone detector only
+// matches numbers written in phone notation so that order IDs, dates and
+// amounts sent as strings are left alone.
+var DefaultDetectors = []Detector{
+	{Kind: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{Kind: "phone", Pattern: regexp.MustCompile(`\+\d{1,3}[\s.\-]?\(?\d{1,4}\)?(?:[\s.\-]?\d{2,4}){2,4}|\(\d{3}\)\s?\d{3}[\s.\-]\d{4}`)},
+}
+
+// DefaultFields maps payload paths that always hold PII to their kind.
+func DefaultFields() map[string]string {
+	fields := map[string]string{
+		"email":                     "email",
+		"phone":                     "phone",
+		"order.email":               "email",
+		"order.phone":               "phone",
+		"order.customer.email":      "email",
+		"order.customer.phone":      "phone",
+		"order.customer.name":       "name",
+		"order.customer.first_name": "name",
+		"order.customer.last_name":  "name",
+		"customer.email":            "email",
+		"customer.phone":            "phone",
+		"customer.name":             "name",
+		"customer.first_name":       "name",
+		"customer.last_name":        "name",
+	}
+	for _, address := range []string{"order.shipping_address", "order.billing_address", "customer.default_address"} {
+		fields[address+".name"] = "name"
+		fields[address+".first_name"] = "name"
+		fields[address+".last_name"] = "name"
+		fields[address+".phone"] = "phone"
+		fields[address+".address1"] = "address"
+		fields[address+".address2"] = "address"
+	}
+	return fields
+}
+
+// kindPattern is what a PII kind may look like; the kind is part of its
+// tokens, which IsToken must recognise.
+var kindPattern = regexp.MustCompile(`^[a-z]+$`)
+
+// ParseDetectors builds detectors from entries that either name a default
+// detector, such as "email", or give a kind and a pattern as kind:pattern.
+// Without entries the default detectors are used.
+func ParseDetectors(entries []string) ([]Detector, error) {
+	if len(entries) == 0 {
+		return DefaultDetectors, nil
+	}
+
+	var detectors []Detector
+	for _, entry := range entries {
+		kind, pattern, ok := strings.Cut(entry, ":")
+		if !ok {
+			found := false
+			for _, detector := range DefaultDetectors {
+				if detector.Kind == entry {
+					detectors = append(detectors, detector)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown PII detector %q", entry)
+			}
+			continue
+		}
+		if kind == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid PII detector %q, want kind:pattern", entry)
+		}
+		if !kindPattern.MatchString(kind) {
+			return nil, fmt.Errorf("invalid PII detector %q: kind must be lowercase letters only", entry)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII detector %q: %w", entry, err)
+		}
+		detectors = append(detectors, Detector{Kind: kind, Pattern: re})
+	}
+	return detectors, nil
+}
@@ -0,0 +1,22 @@
+package pii
+
+import "testing"
+
+func TestParseDetectorsRejectsKindsTokensCannotCarry(t *testing.T) {
+	for _, kind := range []string{"loyalty_id", "Card2", "IBAN"} {
+		if _, err := ParseDetectors([]string{kind + `:\d+`}); err == nil {
+			t.Fatalf("detector kind %q accepted", kind)
+		}
+		if _, err := NewRedactor([]string{"order.loyalty:" + kind}, nil, nil); err == nil {
+			t.Fatalf("field kind %q accepted", kind)
+		}
+	}
+
+	detectors, err := ParseDetectors([]string{`iban:[A-Z]{2}\d{2}[A-Z0-9]{10,30}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := NewToken([]byte("secret"), detectors[0].Kind, "DE89370400440532013000"); !IsToken(token) {
+		t.Fatalf("token %q of kind iban not recognised", token)
+	}
+}
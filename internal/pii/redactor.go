@@ -0,0 +1,133 @@
+package pii
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ecommerce-platform/internal/payload"
+)
+
+type Tokenizer interface {
+	Tokenize(ctx context.Context, values []Value) ([]string, error)
+}
+
+// Redactor replaces PII in webhook payloads, both at configured field paths
+// and wherever a detector matches. Without a Tokenizer values are masked
+// irreversibly.
+type Redactor struct {
+	Fields    map[string]string
+	Detectors []Detector
+	Tokenizer Tokenizer
+}
+
+// Redact rewrites p in place and returns the sorted paths that held PII.
+func (r *Redactor) Redact(ctx context.Context, p map[string]interface{}) ([]string, error) {
+	var found []Value
+	r.visit(p, func(path string, value Value) string {
+		found = append(found, value)
+		return value.Value
+	})
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	replacements, err := r.replacements(ctx, found)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := make(map[string]bool)
+	r.visit(p, func(path string, value Value) string {
+		redacted[path] = true
+		return replacements[value]
+	})
+
+	paths := make([]string, 0, len(redacted))
+	for path := range redacted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (r *Redactor) replacements(ctx context.Context, found []Value) (map[Value]string, error) {
+	unique := make([]Value, 0, len(found))
+	replacements := make(map[Value]string)
+	for _, value := range found {
+		if _, ok := replacements[value]; ok {
+			continue
+		}
+		replacements[value] = "[redacted:" + value.Kind + "]"
+		unique = append(unique, value)
+	}
+
+	if r.Tokenizer == nil {
+		return replacements, nil
+	}
+
+	tokens, err := r.Tokenizer.Tokenize(ctx, unique)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range unique {
+		replacements[value] = tokens[i]
+	}
+	return replacements, nil
+}
+
+// visit calls fn for every PII value in p and stores what fn returns in its
+// place. Values that are already tokens are skipped.
+func (r *Redactor) visit(p map[string]interface{}, fn func(path string, value Value) string) {
+	for field, kind := range r.Fields {
+		kind := kind
+		payload.Rewrite(p, field, func(path string, value interface{}) interface{} {
+			s, ok := value.(string)
+			if !ok || s == "" || IsToken(s) {
+				return value
+			}
+			return fn(path, Value{Kind: kind, Value: s})
+		})
+	}
+
+	payload.RewriteStrings(p, func(path, s string) string {
+		for _, detector := range r.Detectors {
+			s = detector.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+				return fn(path, Value{Kind: detector.Kind, Value: match})
+			})
+		}
+		return s
+	})
+}
+
+// NewRedactor builds a redactor from "path:kind" field entries, using
+// DefaultFields when none are given, and from detector entries as read by
+// ParseDetectors.
+func NewRedactor(entries, detectorEntries []string, tokenizer Tokenizer) (*Redactor, error) {
+	detectors, err := ParseDetectors(detectorEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := DefaultFields()
+	if len(entries) > 0 {
+		fields = make(map[string]string, len(entries))
+		for _, entry := range entries {
+			path, kind, ok := strings.Cut(entry, ":")
+			if !ok || path == "" || kind == "" {
+				return nil, fmt.Errorf("invalid PII field %q, want path:kind", entry)
+			}
+			if !kindPattern.MatchString(kind) {
+				return nil, fmt.Errorf("invalid PII field %q: kind must be lowercase letters only", entry)
+			}
+			fields[path] = kind
+		}
+	}
+
+	return &Redactor{
+		Fields:    fields,
+		Detectors: detectors,
+		Tokenizer: tokenizer,
+	}, nil
+}
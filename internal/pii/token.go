@@ -0,0 +1,45 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+var tokenPattern = regexp.MustCompile(`tok_[a-z]+_[0-9a-f]{24}`)
+
+// Value is a single piece of PII submitted for tokenization.
+type Value struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// NewToken derives a deterministic token for value, so the same email or
+// phone always maps to the same token and can still be compared downstream.
+func NewToken(secret []byte, kind, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return "tok_" + kind + "_" + hex.EncodeToString(mac.Sum(nil))[:24]
+}
+
+func IsToken(s string) bool {
+	return s != "" && tokenPattern.FindString(s) == s
+}
+
+// FindTokens returns every token embedded in s.
+func FindTokens(s string) []string {
+	return tokenPattern.FindAllString(s, -1)
+}
+
+// ReplaceTokens substitutes every token in s found in values.
+func ReplaceTokens(s string, values map[string]string) string {
+	return tokenPattern.ReplaceAllStringFunc(s, func(token string) string {
+		if value, ok := values[token]; ok {
+			return value
+		}
+		return token
+	})
+}
@@ -0,0 +1,127 @@
+package pii
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/models"
+)
+
+type TokenizeRequest struct {
+	Values []Value `json:"values"`
+}
+
+type TokenizeResponse struct {
+	Tokens []string `json:"tokens"`
+}
+
+type DetokenizeRequest struct {
+	Tokens []string `json:"tokens"`
+}
+
+type DetokenizeResponse struct {
+	Values map[string]string `json:"values"`
+}
+
+// VaultClient talks to vault-service. Every request is authenticated with
+// the caller's client ID and key; detokenizing requires a grant.
+type VaultClient struct {
+	baseURL  string
+	clientID string
+	key      string
+	http     *http.Client
+}
+
+func NewVaultClient(baseURL, clientID, key string) *VaultClient {
+	return &VaultClient{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		clientID: clientID,
+		key:      key,
+		http:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *VaultClient) Tokenize(ctx context.Context, values []Value) ([]string, error) {
+	var resp TokenizeResponse
+	if err := c.post(ctx, "/tokenize", TokenizeRequest{Values: values}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Tokens) != len(values) {
+		return nil, fmt.Errorf("vault returned %d tokens for %d values", len(resp.Tokens), len(values))
+	}
+	return resp.Tokens, nil
+}
+
+func (c *VaultClient) Detokenize(ctx context.Context, tokens []string) (map[string]string, error) {
+	var resp DetokenizeResponse
+	if err := c.post(ctx, "/detokenize", DetokenizeRequest{Tokens: tokens}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// DetokenizeOrder restores the customer details of an order in place.
+func (c *VaultClient) DetokenizeOrder(ctx context.Context, order *models.Order) error {
+	if order.Customer == nil {
+		return nil
+	}
+
+	fields := customerFields(order.Customer)
+	var tokens []string
+	for _, field := range fields {
+		tokens = append(tokens, FindTokens(*field)...)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	values, err := c.Detokenize(ctx, tokens)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		*field = ReplaceTokens(*field, values)
+	}
+	return nil
+}
+
+func customerFields(customer *models.Customer) []*string {
+	fields := []*string{&customer.Name, &customer.Email, &customer.Phone}
+	for _, address := range []*models.Address{customer.ShippingAddress, customer.BillingAddress} {
+		if address != nil {
+			fields = append(fields, &address.Name, &address.Address1, &address.Address2, &address.Phone)
+		}
+	}
+	return fields
+}
+
+func (c *VaultClient) post(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", c.clientID)
+	req.Header.Set("X-Client-Key", c.key)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
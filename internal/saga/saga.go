@@ -0,0 +1,170 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"ecommerce-platform/internal/kafka"
+	"ecommerce-platform/internal/models"
+)
+
+const (
+	CommandsTopic = "saga-commands"
+	RepliesTopic  = "saga-replies"
+)
+
+// Origin stands for the platform an order came from in a step definition.
+const Origin = "origin"
+
+// UpdateAction resends an order to a destination that already has it. The
+// orchestrator sends it for later versions of an order it placed, since
+// connectors leave such orders on the orders topic to the saga.
+const UpdateAction = "update_status"
+
+// Step is one step of the placement saga: Action is sent to Destination and,
+// when a later step fails, Compensation undoes it. Steps without a
+// compensation, such as status updates, are not undone.
+type Step struct {
+	Destination  string `json:"destination"`
+	Action       string `json:"action"`
+	Compensation string `json:"compensation,omitempty"`
+}
+
+// Definition is the ordered list of saga steps.
+type Definition []Step
+
+// ParseSteps reads steps written as destination:action[:compensation], for
+// example "msi:place:cancel".
+func ParseSteps(entries []string) (Definition, error) {
+	var steps Definition
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid saga step %q", entry)
+		}
+		step := Step{Destination: parts[0], Action: parts[1]}
+		if len(parts) == 3 {
+			step.Compensation = parts[2]
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Resolve returns the steps that apply to order, with Origin replaced by the
+// order's platform. Steps for destinations the order is not routed to are
+// left out.
+func (d Definition) Resolve(order models.Order) Definition {
+	var steps Definition
+	for _, step := range d {
+		if step.Destination == Origin {
+			step.Destination = order.Platform
+		}
+		if order.RoutesTo(step.Destination) {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// Drives reports whether the saga delivers order to destination, in which
+// case the destination's connector must not also take it, or any later
+// version of it, from the orders topic.
+func (d Definition) Drives(order models.Order, destination string) bool {
+	for _, step := range d.Resolve(order) {
+		if step.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// Command asks a connector to run one saga step. Attempt starts at 1 and
+// grows when a step is retried after a timeout, so connectors should treat
+// actions as idempotent per saga and step.
+type Command struct {
+	SagaID       string       `json:"saga_id"`
+	Step         int          `json:"step"`
+	Attempt      int          `json:"attempt"`
+	Destination  string       `json:"destination"`
+	Action       string       `json:"action"`
+	Compensating bool         `json:"compensating,omitempty"`
+	Order        models.Order `json:"order"`
+	IssuedAt     time.Time    `json:"issued_at"`
+}
+
+// Reply reports the outcome of a command back to the orchestrator.
+type Reply struct {
+	SagaID       string    `json:"saga_id"`
+	Step         int       `json:"step"`
+	Attempt      int       `json:"attempt"`
+	Destination  string    `json:"destination"`
+	Action       string    `json:"action"`
+	Compensating bool      `json:"compensating,omitempty"`
+	OK           bool      `json:"ok"`
+	ExternalID   string    `json:"external_id,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	At           time.Time `json:"at"`
+}
+
+// Handler runs a command against a destination system and returns the ID
+// the system gave the order, if any.
+type Handler func(ctx context.Context, cmd Command) (string, error)
+
+// Serve consumes saga commands addressed to destination, runs them through
+// handle and publishes a reply for each. It returns when ctx is done.
+func Serve(ctx context.Context, broker, destination, groupID string, handle Handler) {
+	consumer := kafka.NewConsumer(broker, CommandsTopic, groupID)
+	defer consumer.Close()
+	replies := kafka.NewProducer(broker, RepliesTopic)
+	defer replies.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			msg, err := consumer.Read(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var cmd Command
+			if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+				log.Printf("[saga] Unmarshal error: %v", err)
+				continue
+			}
+			if cmd.Destination != destination {
+				continue
+			}
+
+			reply := Reply{
+				SagaID:       cmd.SagaID,
+				Step:         cmd.Step,
+				Attempt:      cmd.Attempt,
+				Destination:  cmd.Destination,
+				Action:       cmd.Action,
+				Compensating: cmd.Compensating,
+				OK:           true,
+			}
+			reply.ExternalID, err = handle(ctx, cmd)
+			if err != nil {
+				reply.OK = false
+				reply.Error = err.Error()
+			}
+			reply.At = time.Now()
+
+			if err := replies.Send(ctx, cmd.SagaID, reply); err != nil {
+				log.Printf("[saga] Failed to reply to %s step %d: %v", cmd.SagaID, cmd.Step, err)
+			}
+		}
+	}
+}
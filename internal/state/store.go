@@ -0,0 +1,216 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const changelogTimeout = 5 * time.Second
+
+// DB is a local embedded state database. Each named Store is a separate
+// bucket inside it.
+type DB struct {
+	bolt *bolt.DB
+}
+
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &DB{bolt: db}, nil
+}
+
+func (db *DB) Close() error {
+	return db.bolt.Close()
+}
+
+func (db *DB) Store(name string) (*Store, error) {
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db.bolt, bucket: []byte(name)}, nil
+}
+
+// LoggedStore opens a store whose writes are also published to changelog,
+// so it can be rebuilt with Restore after the local file is lost.
+func (db *DB) LoggedStore(name string, changelog Changelog) (*Store, error) {
+	store, err := db.Store(name)
+	if err != nil {
+		return nil, err
+	}
+	store.changelog = changelog
+	return store, nil
+}
+
+// Changelog receives every write made to a logged store.
+type Changelog interface {
+	Send(ctx context.Context, key string, value interface{}) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Store is a keyed store of JSON values.
+type Store struct {
+	db        *bolt.DB
+	bucket    []byte
+	changelog Changelog
+}
+
+// Get decodes the value stored under key into v and reports whether it
+// was found.
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(s.bucket).Get([]byte(key)); value != nil {
+			data = append(data, value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *Store) Put(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(key), data)
+	})
+	if err != nil || s.changelog == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), changelogTimeout)
+	defer cancel()
+	return s.changelog.Send(ctx, key, json.RawMessage(data))
+}
+
+func (s *Store) Delete(key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(key))
+	})
+	if err != nil || s.changelog == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), changelogTimeout)
+	defer cancel()
+	return s.changelog.Delete(ctx, key)
+}
+
+// ForEach calls fn with the raw JSON of every entry.
+func (s *Store) ForEach(fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+// Replay calls fn for every entry of a changelog, oldest first. An empty
+// value deletes the key.
+type Replay func(ctx context.Context, fn func(key, value []byte) error) error
+
+// Restore replays the changelog into the local store without publishing
+// the entries again.
+func (s *Store) Restore(ctx context.Context, replay Replay) (int, error) {
+	restored := 0
+	err := replay(ctx, func(key, value []byte) error {
+		restored++
+		return s.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(s.bucket)
+			if len(value) == 0 {
+				return bucket.Delete(key)
+			}
+			return bucket.Put(key, value)
+		})
+	})
+	return restored, err
+}
+
+// AsyncChangelog sends writes to a changelog in the background, in the
+// order they were made, so writers holding a lock do not wait for the
+// changelog. Writes still queued when ctx is done are sent before the
+// sender stops; a write the changelog rejects is logged and lost, so it
+// suits state that is safe to lose, such as dedup state.
+type AsyncChangelog struct {
+	queue chan changelogWrite
+}
+
+type changelogWrite struct {
+	key    string
+	value  interface{}
+	delete bool
+}
+
+func NewAsyncChangelog(ctx context.Context, changelog Changelog, size int) *AsyncChangelog {
+	c := &AsyncChangelog{queue: make(chan changelogWrite, size)}
+	go c.run(ctx, changelog)
+	return c
+}
+
+func (c *AsyncChangelog) run(ctx context.Context, changelog Changelog) {
+	send := func(write changelogWrite) {
+		sendCtx, cancel := context.WithTimeout(context.Background(), changelogTimeout)
+		defer cancel()
+		var err error
+		if write.delete {
+			err = changelog.Delete(sendCtx, write.key)
+		} else {
+			err = changelog.Send(sendCtx, write.key, write.value)
+		}
+		if err != nil {
+			log.Printf("[state] Failed to log %s: %v", write.key, err)
+		}
+	}
+
+	for {
+		select {
+		case write := <-c.queue:
+			send(write)
+		case <-ctx.Done():
+			for {
+				select {
+				case write := <-c.queue:
+					send(write)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Send queues a write, waiting for room in the queue until ctx is done.
+func (c *AsyncChangelog) Send(ctx context.Context, key string, value interface{}) error {
+	return c.enqueue(ctx, changelogWrite{key: key, value: value})
+}
+
+// Delete queues a delete, waiting for room in the queue until ctx is done.
+func (c *AsyncChangelog) Delete(ctx context.Context, key string) error {
+	return c.enqueue(ctx, changelogWrite{key: key, delete: true})
+}
+
+func (c *AsyncChangelog) enqueue(ctx context.Context, write changelogWrite) error {
+	select {
+	case c.queue <- write:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}